@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+//API key scopes, operator keys can call every route while player keys can only see their own balance and join tournaments as themselves
+const (
+	ScopeOperator = "operator"
+	ScopePlayer   = "player"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+//APIKey is structure that represent api_keys table entry in database, only sha256 hash of the key is stored, player id is set for player keys
+type APIKey struct {
+	Hash     string `db:"key_hash"`
+	Scope    string `db:"scope"`
+	PlayerID string `db:"player_id"`
+}
+
+type apiKeyContextKey struct{}
+
+//newAPIKey generates random api key, it is shown only once as only its hash is stored
+func newAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+//hashAPIKey returns hex encoded sha256 hash of api key under which it is stored
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+//authenticate is middleware which looks up api key given in X-API-Key header or as bearer token and puts it into request context,
+//requests without known key are rejected with 401
+func (h *Handlers) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(apiKeyHeader)
+		if auth := r.Header.Get("Authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if key == "" {
+			writeError(w, &APIError{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Message: "api key is required"})
+			return
+		}
+		apiKey, err := h.repo.FindAPIKey(hashAPIKey(key))
+		if err == sql.ErrNoRows {
+			writeError(w, &APIError{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Message: "api key is not valid"})
+			return
+		}
+		if err != nil {
+			writeError(w, errorFor(err))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, apiKey)))
+	})
+}
+
+//requireOperator is middleware which rejects requests authenticated with player keys with 403
+func requireOperator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := requestAPIKey(r); key == nil || key.Scope != ScopeOperator {
+			writeError(w, &APIError{Status: http.StatusForbidden, Code: CodeForbidden, Message: "operator api key is required"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//requestAPIKey returns api key request was authenticated with
+func requestAPIKey(r *http.Request) *APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey{}).(*APIKey)
+	return key
+}
+
+//authorizePlayer checks that player key acts only as its own player, stakes of backers can only be charged with operator key,
+//requests which did not go through authenticate middleware are not restricted
+func authorizePlayer(r *http.Request, playerID string, backerIDs []string) *APIError {
+	key := requestAPIKey(r)
+	if key == nil || key.Scope == ScopeOperator {
+		return nil
+	}
+	if key.PlayerID != playerID {
+		return &APIError{Status: http.StatusForbidden, Code: CodeForbidden, Message: "player api key can only act as its own player", Field: "playerId"}
+	}
+	if len(backerIDs) > 0 {
+		return &APIError{Status: http.StatusForbidden, Code: CodeForbidden, Message: "player api key can not join with backers", Field: "backerId"}
+	}
+	return nil
+}
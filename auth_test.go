@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testOperatorKey = "test-operator-key"
+
+//createTestAPIKey stores api key for tests, key can already exist when tests run against postgres as keys survive database reset
+func createTestAPIKey(db Datastore, key string, scope string, playerID string) {
+	db.CreateAPIKey(&APIKey{Hash: hashAPIKey(key), Scope: scope, PlayerID: playerID})
+}
+
+func TestAPIKeys(t *testing.T) {
+	db := newTestStore(t)
+	db.ResetDatabase()
+	defer db.ResetDatabase()
+	config := defaultConfig()
+	config.AdminEnabled = true
+	router := newRouter(&Handlers{repo: db, config: config})
+	createTestAPIKey(db, testOperatorKey, ScopeOperator, "")
+	createTestAPIKey(db, "test-player-key-A1", ScopePlayer, "A1")
+	createTestAPIKey(db, "test-player-key-A2", ScopePlayer, "A2")
+
+	operator := loadSpecChecker(t, router, testOperatorKey)
+	player := loadSpecChecker(t, router, "test-player-key-A1")
+	player2 := loadSpecChecker(t, router, "test-player-key-A2")
+	anonymous := loadSpecChecker(t, router, "")
+	invalid := loadSpecChecker(t, router, "test-unknown-key")
+
+	Convey("Given api keys of operator and player A1", t, func() {
+		Convey("Given requests are made without key or with unknown key", func() {
+			w1 := anonymous.call("GET", "/fund?playerId=A1&points=100", "")
+			w2 := invalid.call("GET", "/balance?playerId=A1", "")
+			w3 := anonymous.call("GET", "/v2/tournaments/A1", "")
+			w4 := anonymous.call("GET", "/openapi.json", "")
+			Convey("They should be unauthorized except for api description", func() {
+				So(w1.Code, ShouldEqual, http.StatusUnauthorized)
+				So(errorResponse(w1).Code, ShouldEqual, CodeUnauthorized)
+				So(w2.Code, ShouldEqual, http.StatusUnauthorized)
+				So(w3.Code, ShouldEqual, http.StatusUnauthorized)
+				So(w4.Code, ShouldEqual, http.StatusOK)
+				So(anonymous.errors, ShouldBeEmpty)
+				So(invalid.errors, ShouldBeEmpty)
+			})
+		})
+		Convey("Given player key tries operator calls", func() {
+			w1 := player.call("GET", "/fund?playerId=A1&points=100", "")
+			w2 := player.call("POST", "/v2/players/A1/deposits", `{"amount": 100}`)
+			w3 := player.call("GET", "/reset", "")
+			w4 := player.call("GET", "/announceTournament?tournamentId=A1&deposit=10", "")
+			Convey("They should be forbidden", func() {
+				So(w1.Code, ShouldEqual, http.StatusForbidden)
+				So(errorResponse(w1).Code, ShouldEqual, CodeForbidden)
+				So(w2.Code, ShouldEqual, http.StatusForbidden)
+				So(w3.Code, ShouldEqual, http.StatusForbidden)
+				So(w4.Code, ShouldEqual, http.StatusForbidden)
+				So(player.errors, ShouldBeEmpty)
+			})
+		})
+		Convey("Given operator funds A1 and A2 and opens tournament A1 with bearer token", func() {
+			req, _ := http.NewRequest("GET", "/fund?playerId=A1&points=100", nil)
+			req.Header.Set("Authorization", "Bearer "+testOperatorKey)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			operator.call("GET", "/fund?playerId=A2&points=100", "")
+			operator.call("GET", "/announceTournament?tournamentId=A1&deposit=10", "")
+			operator.call("GET", "/openRegistration?tournamentId=A1", "")
+			Convey("Operator calls should succeed", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(operator.errors, ShouldBeEmpty)
+			})
+		})
+		Convey("Given player key reads balances of A1 and A2", func() {
+			w1 := player.call("GET", "/balance?playerId=A1", "")
+			w2 := player.call("GET", "/balance?playerId=A2", "")
+			w3 := player.call("GET", "/v2/players/A2", "")
+			w4 := player.call("GET", "/v2/tournaments/A1", "")
+			w5 := player.call("GET", "/v2/players/A1/transactions", "")
+			w6 := player.call("GET", "/v2/players/A2/transactions", "")
+			Convey("It should only see its own balance, transactions and tournaments", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(w2.Code, ShouldEqual, http.StatusForbidden)
+				So(errorResponse(w2).Field, ShouldEqual, "playerId")
+				So(w3.Code, ShouldEqual, http.StatusForbidden)
+				So(w4.Code, ShouldEqual, http.StatusOK)
+				So(w5.Code, ShouldEqual, http.StatusOK)
+				So(w6.Code, ShouldEqual, http.StatusForbidden)
+				So(player.errors, ShouldBeEmpty)
+			})
+		})
+		Convey("Given player key joins tournament A1 as A2, with backer A2 and as itself", func() {
+			w1 := player.call("GET", "/joinTournament?tournamentId=A1&playerId=A2", "")
+			w2 := player.call("POST", "/v2/tournaments/A1/entries", `{"playerId": "A1", "backers": [{"playerId": "A2"}]}`)
+			w3 := player.call("GET", "/joinTournament?tournamentId=A1&playerId=A1", "")
+			Convey("It should only join as itself without backers", func() {
+				So(w1.Code, ShouldEqual, http.StatusForbidden)
+				So(w2.Code, ShouldEqual, http.StatusForbidden)
+				So(errorResponse(w2).Field, ShouldEqual, "backerId")
+				So(w3.Code, ShouldEqual, http.StatusOK)
+				So(player.errors, ShouldBeEmpty)
+				p1, _ := db.FindPlayer("A1")
+				p2, _ := db.FindPlayer("A2")
+				So(p1.Balances["EUR"], ShouldEqual, 9000)
+				So(p2.Balances["EUR"], ShouldEqual, 10000)
+			})
+		})
+		Convey("Given players A1 and A2 join tournament A2 with the same idempotency key", func() {
+			operator.call("GET", "/announceTournament?tournamentId=A2&deposit=10", "")
+			operator.call("GET", "/openRegistration?tournamentId=A2", "")
+			headers := map[string]string{idempotencyHeader: "join-A2"}
+			w1 := player.callWithHeaders("GET", "/joinTournament?tournamentId=A2&playerId=A1", "", headers)
+			w2 := player2.callWithHeaders("GET", "/joinTournament?tournamentId=A2&playerId=A2", "", headers)
+			w3 := player.callWithHeaders("GET", "/joinTournament?tournamentId=A2&playerId=A1", "", headers)
+			Convey("Key should be scoped to api key so only A1's repeated request is replayed", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(w2.Code, ShouldEqual, http.StatusOK)
+				So(w2.Header().Get("Idempotent-Replayed"), ShouldBeEmpty)
+				So(w3.Header().Get("Idempotent-Replayed"), ShouldEqual, "true")
+				So(player.errors, ShouldBeEmpty)
+				So(player2.errors, ShouldBeEmpty)
+				p1, _ := db.FindPlayer("A1")
+				p2, _ := db.FindPlayer("A2")
+				So(p1.Balances["EUR"], ShouldEqual, 8000)
+				So(p2.Balances["EUR"], ShouldEqual, 9000)
+			})
+		})
+		Convey("Given player key tries to leave tournament and database is reset by operator", func() {
+			w1 := player.call("DELETE", "/v2/tournaments/A1/entries/A1", "")
+			w2 := operator.call("POST", "/v2/reset", "")
+			w3 := operator.call("GET", "/fund?playerId=A1&points=100", "")
+			Convey("Leaving should be forbidden and keys should survive reset", func() {
+				So(w1.Code, ShouldEqual, http.StatusForbidden)
+				So(w2.Code, ShouldEqual, http.StatusNoContent)
+				So(w3.Code, ShouldEqual, http.StatusOK)
+			})
+		})
+	})
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	Convey("Given apikey subcommand is run", t, func() {
+		db := NewMemoryStore()
+		operatorKey, errOperator := createAPIKey(db, []string{"operator"})
+		playerKey, errPlayer := createAPIKey(db, []string{"player", "P1"})
+		_, errMissing := createAPIKey(db, []string{"player"})
+		Convey("Generated keys should be stored hashed with their scope", func() {
+			So(errOperator, ShouldBeNil)
+			So(errPlayer, ShouldBeNil)
+			So(errMissing, ShouldNotBeNil)
+			So(operatorKey, ShouldNotEqual, playerKey)
+			key, err := db.FindAPIKey(hashAPIKey(playerKey))
+			So(err, ShouldBeNil)
+			So(*key, ShouldResemble, APIKey{Hash: hashAPIKey(playerKey), Scope: ScopePlayer, PlayerID: "P1"})
+			_, err = db.FindAPIKey(playerKey)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//envPrefix is prefix for all environment variables read into configuration
+const envPrefix = "TOURNAMENT_"
+
+//Config holds application configuration, values are taken from defaults, optional json config file, environment variables and flags, later ones overriding earlier
+type Config struct {
+	Addr            string     `json:"addr"`
+	DSN             string     `json:"dsn"`
+	Memory          bool       `json:"memory"`
+	MaxOpenConns    int        `json:"maxOpenConns"`
+	MaxIdleConns    int        `json:"maxIdleConns"`
+	ConnMaxLifetime duration   `json:"connMaxLifetime"`
+	ReadTimeout     duration   `json:"readTimeout"`
+	WriteTimeout    duration   `json:"writeTimeout"`
+	IdleTimeout     duration   `json:"idleTimeout"`
+	MinDeposit      Money      `json:"minDeposit"`
+	MaxDeposit      Money      `json:"maxDeposit"`
+	AdminEnabled    bool       `json:"adminEnabled"`
+	OperatorKey     string     `json:"operatorKey"`
+	Currencies      currencies `json:"currencies"`
+
+	SchedulerInterval  duration `json:"schedulerInterval"`
+	WebhookInterval    duration `json:"webhookInterval"`
+	WebhookMaxAttempts int      `json:"webhookMaxAttempts"`
+	WebhookBackoff     duration `json:"webhookBackoff"`
+}
+
+//defaultConfig returns configuration used when nothing else is provided
+func defaultConfig() Config {
+	return Config{
+		Addr:            ":8080",
+		DSN:             "postgres://postgres@database/postgres?sslmode=disable",
+		MaxOpenConns:    20,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: duration(30 * time.Minute),
+		ReadTimeout:     duration(10 * time.Second),
+		WriteTimeout:    duration(10 * time.Second),
+		IdleTimeout:     duration(60 * time.Second),
+		MinDeposit:      1,
+		Currencies:      currencies{"EUR", "USD", "CHIPS"},
+
+		SchedulerInterval:  duration(30 * time.Second),
+		WebhookInterval:    duration(5 * time.Second),
+		WebhookMaxAttempts: 10,
+		WebhookBackoff:     duration(30 * time.Second),
+	}
+}
+
+//LoadConfig builds configuration for given command line arguments and returns it with remaining non-flag arguments
+func LoadConfig(args []string) (Config, []string, error) {
+	cfg := defaultConfig()
+
+	path := os.Getenv(envPrefix + "CONFIG")
+	if p := configPath(args); p != "" {
+		path = p
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return cfg, nil, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return cfg, nil, err
+	}
+
+	fs := flag.NewFlagSet("tournament", flag.ContinueOnError)
+	fs.String("config", path, "path to json config file (env "+envPrefix+"CONFIG)")
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "listen address (env "+envPrefix+"ADDR)")
+	fs.StringVar(&cfg.DSN, "dsn", cfg.DSN, "postgres connection string (env "+envPrefix+"DSN, password can be given separately in "+envPrefix+"DB_PASSWORD)")
+	fs.BoolVar(&cfg.Memory, "memory", cfg.Memory, "use in-memory datastore instead of postgres, data is lost on restart (env "+envPrefix+"MEMORY)")
+	fs.IntVar(&cfg.MaxOpenConns, "max-open-conns", cfg.MaxOpenConns, "maximum open database connections, 0 is unlimited (env "+envPrefix+"MAX_OPEN_CONNS)")
+	fs.IntVar(&cfg.MaxIdleConns, "max-idle-conns", cfg.MaxIdleConns, "maximum idle database connections (env "+envPrefix+"MAX_IDLE_CONNS)")
+	fs.Var(&cfg.ConnMaxLifetime, "conn-max-lifetime", "maximum database connection lifetime, 0 is unlimited (env "+envPrefix+"CONN_MAX_LIFETIME)")
+	fs.Var(&cfg.ReadTimeout, "read-timeout", "http request read timeout (env "+envPrefix+"READ_TIMEOUT)")
+	fs.Var(&cfg.WriteTimeout, "write-timeout", "http response write timeout (env "+envPrefix+"WRITE_TIMEOUT)")
+	fs.Var(&cfg.IdleTimeout, "idle-timeout", "http keep-alive idle timeout (env "+envPrefix+"IDLE_TIMEOUT)")
+	fs.Var(&cfg.MinDeposit, "min-deposit", "minimal tournament deposit (env "+envPrefix+"MIN_DEPOSIT)")
+	fs.Var(&cfg.MaxDeposit, "max-deposit", "maximal tournament deposit, 0 is unlimited (env "+envPrefix+"MAX_DEPOSIT)")
+	fs.Var(&cfg.Currencies, "currencies", "comma separated currency codes players can hold and tournaments can be run in, first one is used when call does not give currency (env "+envPrefix+"CURRENCIES)")
+	fs.BoolVar(&cfg.AdminEnabled, "admin", cfg.AdminEnabled, "enable admin endpoints such as /reset (env "+envPrefix+"ADMIN_ENABLED)")
+	fs.Var(&cfg.SchedulerInterval, "scheduler-interval", "how often scheduled tournaments are checked, 0 disables scheduler (env "+envPrefix+"SCHEDULER_INTERVAL)")
+	fs.Var(&cfg.WebhookInterval, "webhook-interval", "how often queued webhook deliveries are sent, 0 disables delivery (env "+envPrefix+"WEBHOOK_INTERVAL)")
+	fs.IntVar(&cfg.WebhookMaxAttempts, "webhook-max-attempts", cfg.WebhookMaxAttempts, "delivery attempts before webhook delivery fails (env "+envPrefix+"WEBHOOK_MAX_ATTEMPTS)")
+	fs.Var(&cfg.WebhookBackoff, "webhook-backoff", "delay before first webhook delivery retry, doubled with every further attempt (env "+envPrefix+"WEBHOOK_BACKOFF)")
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	if err := cfg.applyDBPassword(os.Getenv(envPrefix + "DB_PASSWORD")); err != nil {
+		return cfg, nil, err
+	}
+	return cfg, fs.Args(), cfg.Validate()
+}
+
+//configPath finds -config flag value in arguments before rest of the flags are parsed
+func configPath(args []string) string {
+	for i, v := range args {
+		if v == "--" {
+			return ""
+		}
+		name := strings.TrimLeft(v, "-")
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+	}
+	return ""
+}
+
+//loadFile reads json config file over current values
+func (cfg *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	return nil
+}
+
+//loadEnv reads environment variables over current values
+func (cfg *Config) loadEnv() error {
+	vars := []struct {
+		name string
+		set  func(string) error
+	}{
+		{"ADDR", func(v string) error { cfg.Addr = v; return nil }},
+		{"DSN", func(v string) error { cfg.DSN = v; return nil }},
+		{"MEMORY", boolSetter(&cfg.Memory)},
+		{"MAX_OPEN_CONNS", intSetter(&cfg.MaxOpenConns)},
+		{"MAX_IDLE_CONNS", intSetter(&cfg.MaxIdleConns)},
+		{"CONN_MAX_LIFETIME", cfg.ConnMaxLifetime.Set},
+		{"READ_TIMEOUT", cfg.ReadTimeout.Set},
+		{"WRITE_TIMEOUT", cfg.WriteTimeout.Set},
+		{"IDLE_TIMEOUT", cfg.IdleTimeout.Set},
+		{"MIN_DEPOSIT", cfg.MinDeposit.Set},
+		{"MAX_DEPOSIT", cfg.MaxDeposit.Set},
+		{"CURRENCIES", cfg.Currencies.Set},
+		{"ADMIN_ENABLED", boolSetter(&cfg.AdminEnabled)},
+		{"OPERATOR_KEY", func(v string) error { cfg.OperatorKey = v; return nil }},
+		{"SCHEDULER_INTERVAL", cfg.SchedulerInterval.Set},
+		{"WEBHOOK_INTERVAL", cfg.WebhookInterval.Set},
+		{"WEBHOOK_MAX_ATTEMPTS", intSetter(&cfg.WebhookMaxAttempts)},
+		{"WEBHOOK_BACKOFF", cfg.WebhookBackoff.Set},
+	}
+	for _, v := range vars {
+		value, ok := os.LookupEnv(envPrefix + v.name)
+		if !ok {
+			continue
+		}
+		if err := v.set(value); err != nil {
+			return fmt.Errorf("%s%s: %v", envPrefix, v.name, err)
+		}
+	}
+	return nil
+}
+
+//applyDBPassword puts password into DSN so it does not have to be written in config file or flags
+func (cfg *Config) applyDBPassword(password string) error {
+	if password == "" {
+		return nil
+	}
+	u, err := url.Parse(cfg.DSN)
+	if err != nil || u.User == nil {
+		return errors.New("database password is given but dsn is not url with user name")
+	}
+	u.User = url.UserPassword(u.User.Username(), password)
+	cfg.DSN = u.String()
+	return nil
+}
+
+//Validate checks that configuration values are usable
+func (cfg Config) Validate() error {
+	if cfg.Addr == "" {
+		return errors.New("config: addr is required")
+	}
+	if !cfg.Memory && cfg.DSN == "" {
+		return errors.New("config: dsn is required unless in-memory datastore is used")
+	}
+	if cfg.MaxOpenConns < 0 || cfg.MaxIdleConns < 0 {
+		return errors.New("config: connection pool sizes can not be negative")
+	}
+	if cfg.MaxOpenConns > 0 && cfg.MaxIdleConns > cfg.MaxOpenConns {
+		return errors.New("config: max idle connections can not exceed max open connections")
+	}
+	if cfg.ConnMaxLifetime < 0 || cfg.ReadTimeout < 0 || cfg.WriteTimeout < 0 || cfg.IdleTimeout < 0 || cfg.SchedulerInterval < 0 || cfg.WebhookInterval < 0 {
+		return errors.New("config: timeouts can not be negative")
+	}
+	if cfg.WebhookMaxAttempts < 1 || cfg.WebhookBackoff <= 0 {
+		return errors.New("config: webhook deliveries need at least one attempt and positive backoff")
+	}
+	if cfg.MinDeposit <= 0 {
+		return errors.New("config: min deposit must be positive")
+	}
+	if cfg.MaxDeposit != 0 && cfg.MaxDeposit < cfg.MinDeposit {
+		return errors.New("config: max deposit can not be less than min deposit")
+	}
+	if len(cfg.Currencies) == 0 {
+		return errors.New("config: at least one currency is required")
+	}
+	seen := make(map[string]bool)
+	for _, v := range cfg.Currencies {
+		if !currencyPattern.MatchString(v) || seen[v] {
+			return fmt.Errorf("config: currency %q must be unique code of up to 8 capital letters or digits", v)
+		}
+		seen[v] = true
+	}
+	return nil
+}
+
+func boolSetter(p *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*p = b
+		return nil
+	}
+}
+
+func intSetter(p *int) func(string) error {
+	return func(v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*p = i
+		return nil
+	}
+}
+
+//currencies is list of currency codes which can be read from json as array and from environment and flags as "EUR,USD"
+type currencies []string
+
+//contains tells if currency code is one of configured currencies
+func (c currencies) contains(currency string) bool {
+	for _, v := range c {
+		if v == currency {
+			return true
+		}
+	}
+	return false
+}
+
+//currencyPattern matches currency codes, they have to fit currency columns
+var currencyPattern = regexp.MustCompile(`^[A-Z0-9]{1,8}$`)
+
+func (c currencies) String() string {
+	return strings.Join(c, ",")
+}
+
+func (c *currencies) Set(value string) error {
+	*c = nil
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*c = append(*c, v)
+		}
+	}
+	return nil
+}
+
+//duration is time.Duration which can be read from json, environment and flags as "30s"
+type duration time.Duration
+
+func (d duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *duration) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return d.Set(value)
+}
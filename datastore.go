@@ -4,48 +4,314 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"strconv"
+	"sort"
+	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+//Ledger reasons describe why player balance was changed
+const (
+	ReasonFund    = "fund"
+	ReasonTake    = "take"
+	ReasonEntry   = "entry"
+	ReasonBacking = "backing"
+	ReasonPrize   = "prize"
+	ReasonRefund  = "refund"
+	ReasonFee     = "fee"
+)
+
+//HouseAccountID is id of player account entry fees are credited to
+const HouseAccountID = "__house__"
+
 //Tournament is structure that represent tournament table entry in database
 type Tournament struct {
-	ID       string `db:"id"`
-	Deposit  int    `db:"deposit"`
-	Finished bool   `db:"finished"`
+	ID          string `db:"id"`
+	Deposit     int    `db:"deposit"`
+	State       string `db:"state"`
+	MinEntrants int    `db:"min_entrants"`
+	MaxEntrants int    `db:"max_entrants"`
+	PrizePool   int    `db:"prize_pool"`
+	Payout      string `db:"payout"`
+	Fee         int    `db:"fee"`
+	FeeIncluded bool   `db:"fee_included"`
+
+	StartsAt             *time.Time `db:"starts_at"`
+	RegistrationDeadline *time.Time `db:"registration_deadline"`
+	AutoCancel           bool       `db:"auto_cancel"`
+
+	GameServerID string `db:"game_server_id"`
+	Currency     string `db:"currency"`
+
+	CreatedAt time.Time `db:"created_at"`
+	Entrants  int       `db:"entrants"`
 }
 
-//Player is structure that represent player table entry in database
+//MarshalJSON is custom json marshaler to present tournament with deposit, fee and prize pool in points of its currency
+func (t *Tournament) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		ID                   string     `json:"id"`
+		State                string     `json:"state"`
+		Currency             string     `json:"currency"`
+		Deposit              Money      `json:"deposit"`
+		Fee                  Money      `json:"fee"`
+		FeeIncluded          bool       `json:"feeIncluded"`
+		PrizePool            Money      `json:"prizePool"`
+		Payout               string     `json:"payout"`
+		MinEntrants          int        `json:"minEntrants"`
+		MaxEntrants          int        `json:"maxEntrants"`
+		StartsAt             *time.Time `json:"startsAt,omitempty"`
+		RegistrationDeadline *time.Time `json:"registrationDeadline,omitempty"`
+		AutoCancel           bool       `json:"autoCancel"`
+		GameServerID         string     `json:"gameServerId,omitempty"`
+		Entrants             int        `json:"entrants"`
+		CreatedAt            time.Time  `json:"createdAt"`
+	}{
+		ID:                   t.ID,
+		State:                t.State,
+		Currency:             t.Currency,
+		Deposit:              Money(t.Deposit),
+		Fee:                  Money(t.Fee),
+		FeeIncluded:          t.FeeIncluded,
+		PrizePool:            Money(t.PrizePool),
+		Payout:               t.Payout,
+		MinEntrants:          t.MinEntrants,
+		MaxEntrants:          t.MaxEntrants,
+		StartsAt:             t.StartsAt,
+		RegistrationDeadline: t.RegistrationDeadline,
+		AutoCancel:           t.AutoCancel,
+		GameServerID:         t.GameServerID,
+		Entrants:             t.Entrants,
+		CreatedAt:            t.CreatedAt,
+	})
+}
+
+//entryCost returns what player and its backers are charged together for entry, entry fee is either included in deposit or added on top of it
+func (t *Tournament) entryCost() int {
+	if t.FeeIncluded {
+		return t.Deposit
+	}
+	return t.Deposit + t.Fee
+}
+
+//listedAt is time tournaments are filtered and ordered by when listed, start time of scheduled tournament or time it was announced at
+func (t *Tournament) listedAt() time.Time {
+	if t.StartsAt != nil {
+		return *t.StartsAt
+	}
+	return t.CreatedAt
+}
+
+//TournamentFilter selects page of listed tournaments, optionally by state and listing time in range [From, To),
+//tournaments are ordered by listing time and id and only those after cursor are returned
+type TournamentFilter struct {
+	State string
+	From  *time.Time
+	To    *time.Time
+	After *TournamentCursor
+	Limit int
+}
+
+//TournamentCursor points to last tournament of previous page by its listing time and id
+type TournamentCursor struct {
+	At time.Time
+	ID string
+}
+
+//Player is structure that represent player table entry in database with its player_balance entries, balances are keyed by currency
 type Player struct {
-	ID      string `json:"playerId" db:"id"`
-	Balance int    `json:"balance" db:"balance"`
+	ID       string
+	Balances map[string]int
 }
 
-//MarshalJSON is custom json marshaler to present points in float format (points / 100 and remainder)
+//MarshalJSON is custom json marshaler to present balances in points
 func (p *Player) MarshalJSON() ([]byte, error) {
-	balance, _ := strconv.ParseFloat(fmt.Sprintf("%d.%d", p.Balance/100, p.Balance%100), 64)
+	balances := make(map[string]Money, len(p.Balances))
+	for currency, v := range p.Balances {
+		balances[currency] = Money(v)
+	}
+	return json.Marshal(&struct {
+		ID       string           `json:"playerId"`
+		Balances map[string]Money `json:"balances"`
+	}{
+		ID:       p.ID,
+		Balances: balances,
+	})
+}
+
+//Wallet is player balance in single currency
+type Wallet struct {
+	PlayerID string `json:"playerId"`
+	Currency string `json:"currency"`
+	Balance  Money  `json:"balance"`
+}
+
+//wallet returns player balance in given currency, currency player never held has zero balance
+func (p *Player) wallet(currency string) *Wallet {
+	return &Wallet{PlayerID: p.ID, Currency: currency, Balance: Money(p.Balances[currency])}
+}
+
+//Stake is part of tournament deposit paid by player or one of its backers, it also decides their part of the prize
+type Stake struct {
+	PlayerID string `db:"user_id"`
+	Amount   int    `db:"amount"`
+}
+
+//LedgerEntry is structure that represent ledger table entry in database, amount is signed and balance is player balance after the change
+type LedgerEntry struct {
+	ID           int       `db:"id"`
+	PlayerID     string    `db:"player_id"`
+	Currency     string    `db:"currency"`
+	Amount       int       `db:"amount"`
+	Balance      int       `db:"balance"`
+	Reason       string    `db:"reason"`
+	TournamentID *string   `db:"tournament_id"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+//MarshalJSON is custom json marshaler to present ledger entry as player transaction with amounts in points
+func (l *LedgerEntry) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
-		ID      string  `json:"playerId"`
-		Balance float64 `json:"balance"`
+		ID           int       `json:"id"`
+		Type         string    `json:"type"`
+		Currency     string    `json:"currency"`
+		Amount       Money     `json:"amount"`
+		Balance      Money     `json:"balance"`
+		TournamentID *string   `json:"tournamentId,omitempty"`
+		CreatedAt    time.Time `json:"createdAt"`
 	}{
-		ID:      p.ID,
-		Balance: balance,
+		ID:           l.ID,
+		Type:         l.Reason,
+		Currency:     l.Currency,
+		Amount:       Money(l.Amount),
+		Balance:      Money(l.Balance),
+		TournamentID: l.TournamentID,
+		CreatedAt:    l.CreatedAt,
 	})
 }
 
+//TournamentResult is structure that represent tournament_results table entry, result of single stake in finished tournament,
+//backingID is empty for player own stake, position of entrant is empty when it was not placed and cost is what stake holder was charged
+type TournamentResult struct {
+	TournamentID string    `db:"tournament_id"`
+	UserID       string    `db:"user_id"`
+	BackingID    string    `db:"backing_id"`
+	Position     *int      `db:"position"`
+	Cost         int       `db:"cost"`
+	Prize        int       `db:"prize"`
+	FinishedAt   time.Time `db:"finished_at"`
+}
+
+//MarshalJSON is custom json marshaler to present cost and prize of stake in points, backedPlayerId is given for backing stakes
+func (r *TournamentResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		PlayerID       string `json:"playerId"`
+		BackedPlayerID string `json:"backedPlayerId,omitempty"`
+		Position       *int   `json:"position,omitempty"`
+		Cost           Money  `json:"cost"`
+		Prize          Money  `json:"prize"`
+	}{
+		PlayerID:       r.UserID,
+		BackedPlayerID: r.BackingID,
+		Position:       r.Position,
+		Cost:           Money(r.Cost),
+		Prize:          Money(r.Prize),
+	})
+}
+
+//LeaderboardFilter selects results of player own entries, or of backing stakes when Backers is set, in tournaments of currency finished
+//in time range [From, To), optionally of single player, players are ranked by Sort statistic best first
+type LeaderboardFilter struct {
+	Backers  bool
+	Currency string
+	PlayerID string
+	From     *time.Time
+	To       *time.Time
+	Sort     string
+	Limit    int
+}
+
+//HistoryFilter selects page of player ledger entries, optionally by currency, reasons and time in range [From, To),
+//entries are ordered newest first and only those with id below Before are returned when it is set
+type HistoryFilter struct {
+	PlayerID string
+	Currency string
+	Reasons  []string
+	From     *time.Time
+	To       *time.Time
+	Before   int
+	Limit    int
+}
+
+//TournamentRevenue is sum of entry fees collected by house for tournament, reduced by fees refunded
+type TournamentRevenue struct {
+	TournamentID string `db:"tournament_id"`
+	Amount       int    `db:"amount"`
+}
+
+//IdempotentResponse is structure that represent idempotency_keys table entry in database, status is 0 while request is still in progress,
+//request hash identifies request key was first used with
+type IdempotentResponse struct {
+	KeyHash     string `db:"key_hash"`
+	Key         string `db:"key"`
+	Endpoint    string `db:"endpoint"`
+	RequestHash string `db:"request_hash"`
+	Status      int    `db:"status"`
+	Body        string `db:"body"`
+}
+
+//Errors returned by datastore implementations which are not coming from database driver
+var (
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrHouseBalance        = errors.New("house account can not return entry fees it has paid out")
+	ErrAlreadyExists       = errors.New("already exists")
+	ErrInvalidState        = errors.New("tournament is not in required state")
+	ErrInvalidTransition   = errors.New("tournament can not move to requested state")
+	ErrInvalidStakes       = errors.New("stakes must be positive for backers and add up to entry cost")
+	ErrNotEnoughEntrants   = errors.New("tournament does not have minimum number of entrants")
+	ErrDeadlinePassed      = errors.New("tournament registration deadline has passed")
+	ErrInvalidPlacings     = errors.New("placings must be distinct and cover every paid place")
+	ErrPrizeExceedsPool    = errors.New("prizes add up to more than tournament prize pool")
+	ErrPlayerNotFound      = errors.New("player not found")
+	ErrPlayerNotEntered    = errors.New("player has no entry in tournament")
+)
+
 //Datastore is interface that holds all methods for data access layer
 type Datastore interface {
 	FindPlayer(playerID string) (*Player, error)
 	FindOrCreatePlayer(playerID string) (*Player, error)
-	TakeFunds(player *Player, points int) error
-	AddFunds(player *Player, points int) error
-	CreateTournament(tournamentID string, deposit int) error
+	TakeFunds(player *Player, currency string, points int) error
+	AddFunds(player *Player, currency string, points int) error
+	CreateTournament(tournament *Tournament) error
 	FindTournament(tournamentID string) (*Tournament, error)
-	TournamentJoinPlayers(tournament *Tournament, playerID string, backers []string) error
-	FinishTournament(tournament *Tournament, winners []Winner) error
+	ListTournaments(filter TournamentFilter) ([]Tournament, error)
+	TournamentEntries(tournamentID string) ([]Entry, error)
+	ScheduledTournaments(now time.Time) ([]Tournament, error)
+	TransitionTournament(tournament *Tournament, state string) error
+	CancelTournament(tournament *Tournament) error
+	LeaveTournament(tournament *Tournament, playerID string) error
+	TournamentJoinPlayers(tournament *Tournament, player Stake, backers []Stake) (bool, error)
+	FinishTournament(tournament *Tournament, placings []string, winners []Winner) error
+	Revenue(tournamentID string, currency string, from *time.Time, to *time.Time) ([]TournamentRevenue, error)
+	PlayerHistory(filter HistoryFilter) ([]LedgerEntry, error)
+	TournamentResults(tournamentID string) ([]TournamentResult, error)
+	Leaderboard(filter LeaderboardFilter) ([]PlayerStatistics, error)
+	ReserveIdempotencyKey(keyHash string, key string, endpoint string, requestHash string) (*IdempotentResponse, error)
+	CompleteIdempotencyKey(keyHash string, key string, endpoint string, status int, body string) error
+	ReleaseIdempotencyKey(keyHash string, key string, endpoint string) error
+	CreateAPIKey(key *APIKey) error
+	FindAPIKey(hash string) (*APIKey, error)
+	CreateGameServer(server *GameServer) error
+	FindGameServer(gameServerID string) (*GameServer, error)
+	UseResultNonce(gameServerID string, nonce string, expireBefore time.Time) error
+	CreateWebhook(webhook *Webhook) error
+	FindWebhook(webhookID string) (*Webhook, error)
+	ListWebhooks() ([]Webhook, error)
+	DeleteWebhook(webhookID string) error
+	DueDeliveries(now time.Time, leaseUntil time.Time, limit int) ([]WebhookDelivery, error)
+	RecordDeliveryAttempt(delivery *WebhookDelivery) error
+	WebhookDeliveries(filter DeliveryFilter) ([]WebhookDelivery, error)
 	ResetDatabase()
 }
 
@@ -67,13 +333,23 @@ func NewDB(dsn string) (*DB, error) {
 	return &DB{db}, nil
 }
 
-//FindPlayer returns player by its id
+//FindPlayer returns player by its id with balances in every currency it holds
 func (db *DB) FindPlayer(playerID string) (*Player, error) {
-	var player Player
-	err := db.Get(&player, "SELECT id, balance FROM player WHERE id = $1;", playerID)
+	player := Player{Balances: make(map[string]int)}
+	err := db.Get(&player.ID, "SELECT id FROM player WHERE id = $1;", playerID)
 	if err != nil {
 		return nil, err
 	}
+	var balances []struct {
+		Currency string `db:"currency"`
+		Balance  int    `db:"balance"`
+	}
+	if err := db.Select(&balances, "SELECT currency, balance FROM player_balance WHERE player_id = $1;", playerID); err != nil {
+		return nil, err
+	}
+	for _, v := range balances {
+		player.Balances[v.Currency] = v.Balance
+	}
 
 	return &player, nil
 }
@@ -90,150 +366,846 @@ func (db *DB) FindOrCreatePlayer(playerID string) (*Player, error) {
 	return player, nil
 }
 
-//CreatePlayer creates new player with given id
+//CreatePlayer creates new player with given id and no balances
 func (db *DB) CreatePlayer(playerID string) (*Player, error) {
-	var player Player
-	err := db.Get(&player, "INSERT INTO player (id) VALUES ($1) RETURNING id, balance;", playerID)
+	player := Player{Balances: make(map[string]int)}
+	err := db.Get(&player.ID, "INSERT INTO player (id) VALUES ($1) RETURNING id;", playerID)
 	if err != nil {
 		return nil, err
 	}
 	return &player, nil
 }
 
-//TakeFunds takes player and deducts given points away from its balance
-func (db *DB) TakeFunds(player *Player, points int) error {
-	_, err := db.Exec("UPDATE player SET balance = balance - $1 WHERE id = $2;", points, player.ID)
-	if err != nil {
+//TakeFunds takes player and deducts given points away from its balance in currency, queueing funds taken event with the change
+func (db *DB) TakeFunds(player *Player, currency string, points int) error {
+	tx := db.MustBegin()
+	defer tx.Rollback()
+	if err := changeBalance(tx, player.ID, currency, -points, ReasonTake, ""); err != nil {
 		return err
 	}
-	return nil
+	if err := enqueueFundsEvent(tx, EventFundsTaken, player.ID, currency, points); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-//AddFunds takes player and adds given points to its balance
-func (db *DB) AddFunds(player *Player, points int) error {
-	_, err := db.Exec("UPDATE player SET balance = balance + $1 WHERE id = $2;", points, player.ID)
-	if err != nil {
+//AddFunds takes player and adds given points to its balance in currency, queueing funds added event with the change
+func (db *DB) AddFunds(player *Player, currency string, points int) error {
+	tx := db.MustBegin()
+	defer tx.Rollback()
+	if err := changeBalance(tx, player.ID, currency, points, ReasonFund, ""); err != nil {
 		return err
 	}
-	return nil
+	if err := enqueueFundsEvent(tx, EventFundsAdded, player.ID, currency, points); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-//CreateTournament creates new tournament entry with it's deposit
-func (db *DB) CreateTournament(tournamentID string, deposit int) error {
-	if _, err := db.Exec("INSERT INTO tournament (id, deposit) VALUES ($1, $2);", tournamentID, deposit); err != nil {
+//enqueueFundsEvent queues funds event of points added to or taken from player with its balance in currency after the change
+func enqueueFundsEvent(tx *sqlx.Tx, eventType string, playerID string, currency string, points int) error {
+	var balance int
+	if err := tx.Get(&balance, "SELECT balance FROM player_balance WHERE player_id = $1 AND currency = $2;", playerID, currency); err != nil {
 		return err
 	}
-	return nil
+	return enqueueEvent(tx, eventType, &FundsResponse{PlayerID: playerID, Currency: currency, Amount: Money(points), Balance: Money(balance)})
+}
+
+//CreateTournament creates new tournament entry with it's currency, deposit, entry fee, entrant limits, payout structure, schedule and game server,
+//queueing tournament announced event with it
+func (db *DB) CreateTournament(tournament *Tournament) error {
+	if tournament.Payout == "" {
+		tournament.Payout = PayoutWinnerTakesAll
+	}
+	tx := db.MustBegin()
+	defer tx.Rollback()
+	if err := tx.Get(&tournament.CreatedAt, "INSERT INTO tournament (id, deposit, fee, fee_included, min_entrants, max_entrants, payout, starts_at, registration_deadline, auto_cancel, game_server_id, currency) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''), $12) RETURNING created_at;",
+		tournament.ID, tournament.Deposit, tournament.Fee, tournament.FeeIncluded, tournament.MinEntrants, tournament.MaxEntrants, tournament.Payout, tournament.StartsAt, tournament.RegistrationDeadline, tournament.AutoCancel, tournament.GameServerID, tournament.Currency); err != nil {
+		return err
+	}
+	tournament.State = StateAnnounced
+	tournament.PrizePool = 0
+	tournament.Entrants = 0
+	if err := enqueueEvent(tx, EventTournamentAnnounced, tournament); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-//FindTournament returns tournament which is not finished or error
+//FindTournament returns tournament in any state or error
 func (db *DB) FindTournament(tournamentID string) (*Tournament, error) {
 	var tournament Tournament
-	if err := db.Get(&tournament, "SELECT id, deposit FROM tournament WHERE finished = false AND id = $1", tournamentID); err != nil {
+	if err := db.Get(&tournament, "SELECT "+tournamentColumns+" FROM tournament WHERE id = $1", tournamentID); err != nil {
 		return nil, err
 	}
 	return &tournament, nil
 }
 
-//TournamentJoinPlayers takes tournament and takes points for players and adds them to tournament entries
-func (db *DB) TournamentJoinPlayers(tournament *Tournament, playerID string, backers []string) error {
-	if len(backers) == 0 {
-		tx := db.MustBegin()
-		var err error
-		_, err = tx.Exec("UPDATE player SET balance = balance - $1 WHERE id = $2;", tournament.Deposit, playerID)
+//ListTournaments returns page of tournaments matching filter ordered by listing time and id
+func (db *DB) ListTournaments(filter TournamentFilter) ([]Tournament, error) {
+	var afterAt *time.Time
+	var afterID string
+	if filter.After != nil {
+		afterAt, afterID = &filter.After.At, filter.After.ID
+	}
+	var tournaments []Tournament
+	err := db.Select(&tournaments, `SELECT `+tournamentColumns+` FROM tournament
+		WHERE ($1 = '' OR state = $1)
+		AND ($2::timestamp with time zone IS NULL OR COALESCE(starts_at, created_at) >= $2)
+		AND ($3::timestamp with time zone IS NULL OR COALESCE(starts_at, created_at) < $3)
+		AND ($4::timestamp with time zone IS NULL OR (COALESCE(starts_at, created_at), id) > ($4, $5))
+		ORDER BY COALESCE(starts_at, created_at), id LIMIT $6;`, filter.State, filter.From, filter.To, afterAt, afterID, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return tournaments, nil
+}
+
+//TournamentEntries returns entries of tournament with stakes of player and its backers, followed by waitlisted ones, in order they joined
+func (db *DB) TournamentEntries(tournamentID string) ([]Entry, error) {
+	var rows []entryRow
+	err := db.Select(&rows, `SELECT user_id, COALESCE(backing_id, '') AS backing_id, amount, waitlisted FROM (
+			SELECT id, user_id, backing_id, amount, false AS waitlisted FROM tournament_entries WHERE tournament_id = $1
+			UNION ALL
+			SELECT id, user_id, backing_id, amount, true AS waitlisted FROM tournament_waitlist WHERE tournament_id = $1
+		) entries ORDER BY waitlisted, id;`, tournamentID)
+	if err != nil {
+		return nil, err
+	}
+	return groupEntries(tournamentID, rows), nil
+}
+
+//entryRow is single stake row of tournament entries or waitlist, backingID is empty for player own stake
+type entryRow struct {
+	UserID     string `db:"user_id"`
+	BackingID  string `db:"backing_id"`
+	Amount     int    `db:"amount"`
+	Waitlisted bool   `db:"waitlisted"`
+}
+
+//groupEntries groups stake rows into entries of players with their backers, keeping order in which players first appear
+func groupEntries(tournamentID string, rows []entryRow) []Entry {
+	type entryKey struct {
+		playerID   string
+		waitlisted bool
+	}
+	entries := []Entry{}
+	index := make(map[entryKey]int)
+	for _, v := range rows {
+		playerID := v.UserID
+		if v.BackingID != "" {
+			playerID = v.BackingID
+		}
+		key := entryKey{playerID, v.Waitlisted}
+		i, ok := index[key]
+		if !ok {
+			i = len(entries)
+			index[key] = i
+			entries = append(entries, Entry{TournamentID: tournamentID, PlayerID: playerID, Waitlisted: v.Waitlisted, Stakes: []Stake{{PlayerID: playerID}}})
+		}
+		if v.BackingID == "" {
+			entries[i].Stakes[0].Amount = v.Amount
+		} else {
+			entries[i].Stakes = append(entries[i].Stakes, Stake{PlayerID: v.UserID, Amount: v.Amount})
+		}
+	}
+	return entries
+}
+
+//ScheduledTournaments returns tournaments whose registration deadline or start time has passed and scheduler has to act on them
+func (db *DB) ScheduledTournaments(now time.Time) ([]Tournament, error) {
+	var tournaments []Tournament
+	err := db.Select(&tournaments, `SELECT `+tournamentColumns+` FROM tournament WHERE
+		(state = 'registration_open' AND (registration_deadline <= $1 OR starts_at <= $1)) OR
+		(state = 'registration_closed' AND starts_at <= $1) OR
+		(state = 'announced' AND auto_cancel AND starts_at <= $1)
+		ORDER BY id;`, now)
+	if err != nil {
+		return nil, err
+	}
+	return tournaments, nil
+}
+
+//TransitionTournament moves tournament to given state if it is legal transition from its current state, tournament can only start with minimum number of entrants
+func (db *DB) TransitionTournament(tournament *Tournament, state string) error {
+	if !canTransition(tournament.State, state) {
+		return ErrInvalidTransition
+	}
+	tx := db.MustBegin()
+	defer tx.Rollback()
+
+	current, err := lockTournament(tx, tournament.ID)
+	if err != nil {
+		return err
+	}
+	if current != tournament.State {
+		return ErrInvalidTransition
+	}
+	if state == StateRunning && tournament.MinEntrants > 0 {
+		entrants, err := countEntrants(tx, tournament.ID)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
-		_, err = tx.Exec("INSERT INTO tournament_entries (tournament_id, user_id, backing_id) VALUES ($1, $2, null)", tournament.ID, playerID)
+		if entrants < tournament.MinEntrants {
+			return ErrNotEnoughEntrants
+		}
+	}
+	if _, err := tx.Exec("UPDATE tournament SET state = $1 WHERE id = $2;", state, tournament.ID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	tournament.State = state
+	return nil
+}
+
+//tournamentColumns lists tournament table columns selected into Tournament
+const tournamentColumns = "id, deposit, fee, fee_included, state, min_entrants, max_entrants, prize_pool, payout, starts_at, registration_deadline, auto_cancel, COALESCE(game_server_id, '') AS game_server_id, currency, created_at, (SELECT count(*) FROM tournament_entries e WHERE e.tournament_id = tournament.id AND e.backing_id IS NULL) AS entrants"
+
+//TournamentJoinPlayers takes tournament and takes each stake amount from balances of player and its backers in tournament currency and adds them to tournament entries,
+//when tournament is full they are put on waitlist without being charged and true is returned, player already entered results in ErrAlreadyExists,
+//entry accepted event is queued with accepted entry
+func (db *DB) TournamentJoinPlayers(tournament *Tournament, player Stake, backers []Stake) (bool, error) {
+	if err := validateStakes(tournament.entryCost(), player, backers); err != nil {
+		return false, err
+	}
+	if tournament.registrationPassed(time.Now()) {
+		return false, ErrDeadlinePassed
+	}
+
+	tx := db.MustBegin()
+	defer tx.Rollback()
+	if err := lockTournamentInState(tx, tournament.ID, StateRegistrationOpen); err != nil {
+		return false, err
+	}
+	entered, err := findEntrants(tx, tournament.ID)
+	if err != nil {
+		return false, err
+	}
+	if entered[player.PlayerID] {
+		return false, ErrAlreadyExists
+	}
+	if tournament.MaxEntrants > 0 {
+		entrants, err := countEntrants(tx, tournament.ID)
 		if err != nil {
-			tx.Rollback()
+			return false, err
+		}
+		if entrants >= tournament.MaxEntrants {
+			if err := addToWaitlist(tx, tournament.ID, player, backers); err != nil {
+				return false, err
+			}
+			return true, tx.Commit()
+		}
+	}
+	if err := addEntries(tx, tournament, player, backers); err != nil {
+		return false, err
+	}
+	if err := enqueueEvent(tx, EventEntryAccepted, newEntry(tournament.ID, player, backers, false)); err != nil {
+		return false, err
+	}
+	return false, tx.Commit()
+}
+
+//addEntries charges player and backers their stakes in tournament currency, adds them to tournament entries, credits entry fee to house and adds the rest to prize pool
+func addEntries(tx *sqlx.Tx, tournament *Tournament, player Stake, backers []Stake) error {
+	tournamentID := tournament.ID
+	if err := changeBalance(tx, player.PlayerID, tournament.Currency, -player.Amount, ReasonEntry, tournamentID); err != nil {
+		return err
+	}
+	res, err := tx.Exec("INSERT INTO tournament_entries (tournament_id, user_id, backing_id, amount, fee) VALUES ($1, $2, null, $3, $4) ON CONFLICT DO NOTHING;", tournamentID, player.PlayerID, player.Amount, tournament.Fee)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return ErrAlreadyExists
+	}
+	collected := player.Amount
+
+	for _, v := range backers {
+		if err := changeBalance(tx, v.PlayerID, tournament.Currency, -v.Amount, ReasonBacking, tournamentID); err != nil {
 			return err
 		}
-		if err := tx.Commit(); err != nil {
-			tx.Rollback()
+		if _, err := tx.Exec("INSERT INTO tournament_entries (tournament_id, user_id, backing_id, amount) VALUES ($1, $2, $3, $4)", tournamentID, v.PlayerID, player.PlayerID, v.Amount); err != nil {
 			return err
 		}
+		collected += v.Amount
+	}
+	if err := changeHouseBalance(tx, tournament.Currency, tournament.Fee, tournamentID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE tournament SET prize_pool = prize_pool + $1 WHERE id = $2;", collected-tournament.Fee, tournamentID)
+	return err
+}
+
+//changeHouseBalance credits signed entry fee amount in currency to house account, creating the account when it does not exist yet
+func changeHouseBalance(tx *sqlx.Tx, currency string, amount int, tournamentID string) error {
+	if amount == 0 {
+		return nil
+	}
+	if _, err := tx.Exec("INSERT INTO player (id) VALUES ($1) ON CONFLICT DO NOTHING;", HouseAccountID); err != nil {
+		return err
+	}
+	return changeBalance(tx, HouseAccountID, currency, amount, ReasonFee, tournamentID)
+}
+
+//takeBackFees takes entry fees recorded on refunded entries back from house account, house which has paid them out already results in ErrHouseBalance
+func takeBackFees(tx *sqlx.Tx, currency string, fees int, tournamentID string) error {
+	err := changeHouseBalance(tx, currency, -fees, tournamentID)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pqCheckViolation && pqErr.Constraint == "player_balance_check" {
+		return ErrHouseBalance
+	}
+	return err
+}
+
+//addToWaitlist puts player and backers with their stakes at the end of tournament waitlist, player can be waitlisted only once
+func addToWaitlist(tx *sqlx.Tx, tournamentID string, player Stake, backers []Stake) error {
+	var waiting int
+	if err := tx.Get(&waiting, "SELECT count(*) FROM tournament_waitlist WHERE tournament_id = $1 AND user_id = $2 AND backing_id IS NULL;", tournamentID, player.PlayerID); err != nil {
+		return err
+	}
+	if waiting > 0 {
+		return ErrAlreadyExists
+	}
+	if _, err := tx.Exec("INSERT INTO tournament_waitlist (tournament_id, user_id, backing_id, amount) VALUES ($1, $2, null, $3)", tournamentID, player.PlayerID, player.Amount); err != nil {
+		return err
+	}
+	for _, v := range backers {
+		if _, err := tx.Exec("INSERT INTO tournament_waitlist (tournament_id, user_id, backing_id, amount) VALUES ($1, $2, $3, $4)", tournamentID, v.PlayerID, player.PlayerID, v.Amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//promoteFromWaitlist gives free seats to waitlisted players in order they joined, charging them and their backers at that point,
+//waitlisted players who can not pay anymore are dropped from waitlist, entry accepted event is queued for every promoted player
+func promoteFromWaitlist(tx *sqlx.Tx, tournament *Tournament) error {
+	if tournament.MaxEntrants == 0 {
 		return nil
 	}
-	parts := splitEvenly(tournament.Deposit, len(backers)+1)
+	for {
+		entrants, err := countEntrants(tx, tournament.ID)
+		if err != nil || entrants >= tournament.MaxEntrants {
+			return err
+		}
+		var next []string
+		if err := tx.Select(&next, "SELECT user_id FROM tournament_waitlist WHERE tournament_id = $1 AND backing_id IS NULL ORDER BY id LIMIT 1;", tournament.ID); err != nil || len(next) == 0 {
+			return err
+		}
+		var stakes []Stake
+		if err := tx.Select(&stakes, "DELETE FROM tournament_waitlist WHERE tournament_id = $1 AND (( user_id = $2 AND backing_id IS NULL ) OR backing_id = $2) RETURNING user_id, amount;", tournament.ID, next[0]); err != nil {
+			return err
+		}
+		player, backers := splitPlayerStake(next[0], stakes)
 
+		if _, err := tx.Exec("SAVEPOINT promote;"); err != nil {
+			return err
+		}
+		if err := addEntries(tx, tournament, player, backers); err != nil {
+			if _, err := tx.Exec("ROLLBACK TO SAVEPOINT promote;"); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := tx.Exec("RELEASE SAVEPOINT promote;"); err != nil {
+			return err
+		}
+		if err := enqueueEvent(tx, EventEntryAccepted, newEntry(tournament.ID, player, backers, false)); err != nil {
+			return err
+		}
+	}
+}
+
+//countEntrants returns number of players entered in tournament, backers are not counted
+func countEntrants(tx *sqlx.Tx, tournamentID string) (int, error) {
+	var entrants int
+	err := tx.Get(&entrants, "SELECT count(*) FROM tournament_entries WHERE tournament_id = $1 AND backing_id IS NULL;", tournamentID)
+	return entrants, err
+}
+
+//findEntrants returns set of players entered in tournament, backers are not included
+func findEntrants(tx *sqlx.Tx, tournamentID string) (map[string]bool, error) {
+	var players []string
+	if err := tx.Select(&players, "SELECT user_id FROM tournament_entries WHERE tournament_id = $1 AND backing_id IS NULL;", tournamentID); err != nil {
+		return nil, err
+	}
+	entrants := make(map[string]bool, len(players))
+	for _, v := range players {
+		entrants[v] = true
+	}
+	return entrants, nil
+}
+
+//FinishTournament takes tournament and its ranked placings or winners with prizes, and correspondingly gives out prize pool to winning entries and their backers in tournament currency,
+//results are validated against tournament entries before any balance is changed, tournament finished and prize credited events are queued with the payout
+func (db *DB) FinishTournament(tournament *Tournament, placings []string, winners []Winner) error {
 	tx := db.MustBegin()
-	var err error
-	_, err = tx.Exec("UPDATE player SET balance = balance - $1 WHERE id = $2;", parts[0], playerID)
+
+	defer tx.Rollback()
+	if err := lockTournamentInState(tx, tournament.ID, StateRunning); err != nil {
+		return err
+	}
+	var pool int
+	if err := tx.Get(&pool, "SELECT prize_pool FROM tournament WHERE id = $1;", tournament.ID); err != nil {
+		return err
+	}
+	entrants, err := findEntrants(tx, tournament.ID)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
-	_, err = tx.Exec("INSERT INTO tournament_entries (tournament_id, user_id, backing_id) VALUES ($1, $2, null)", tournament.ID, playerID)
+	prizes, err := tournamentPrizes(tournament, pool, entrants, placings, winners)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
-
-	for i, v := range backers {
-		_, err = tx.Exec("UPDATE player SET balance = balance - $1 WHERE id = $2;", parts[i+1], v)
+	paid := make(map[stakePrize]int)
+	for _, prize := range prizes {
+		stakes, err := findPlayersWithBackers(tx, tournament.ID, prize.PlayerID)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
-		_, err = tx.Exec("INSERT INTO tournament_entries (tournament_id, user_id, backing_id) VALUES ($1, $2, $3)", tournament.ID, v, playerID)
-		if err != nil {
-			tx.Rollback()
+		rewards := splitByStakes(prize.Amount, stakes)
+		for i, v := range stakes {
+			if err := changeBalance(tx, v.PlayerID, tournament.Currency, rewards[i], ReasonPrize, tournament.ID); err != nil {
+				return err
+			}
+			paid[stakePrize{v.PlayerID, prize.PlayerID}] += rewards[i]
+		}
+	}
+	var rows []entryRow
+	if err := tx.Select(&rows, "SELECT user_id, COALESCE(backing_id, '') AS backing_id, amount FROM tournament_entries WHERE tournament_id = $1 ORDER BY id;", tournament.ID); err != nil {
+		return err
+	}
+	results := tournamentResults(tournament.ID, rows, finishingPositions(placings, winners), paid)
+	for _, v := range results {
+		if _, err := tx.Exec("INSERT INTO tournament_results (tournament_id, user_id, backing_id, position, cost, prize) VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6);",
+			v.TournamentID, v.UserID, v.BackingID, v.Position, v.Cost, v.Prize); err != nil {
 			return err
 		}
 	}
-	if err := tx.Commit(); err != nil {
-		tx.Rollback()
+	if _, err := tx.Exec("UPDATE tournament SET state = $1 WHERE id = $2;", StateFinished, tournament.ID); err != nil {
+		return err
+	}
+	var finished Tournament
+	if err := tx.Get(&finished, "SELECT "+tournamentColumns+" FROM tournament WHERE id = $1;", tournament.ID); err != nil {
+		return err
+	}
+	if err := enqueueEvent(tx, EventTournamentFinished, &finished); err != nil {
+		return err
+	}
+	for _, v := range prizeCredits(&finished, results) {
+		if err := enqueueEvent(tx, EventPrizeCredited, &v); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+//CancelTournament refunds every player and backer exactly what they were charged for their entries, takes entry fees back from house and marks tournament cancelled
+func (db *DB) CancelTournament(tournament *Tournament) error {
+	tx := db.MustBegin()
+	defer tx.Rollback()
+
+	state, err := lockTournament(tx, tournament.ID)
+	if err != nil {
 		return err
 	}
+	if !canTransition(state, StateCancelled) {
+		return ErrInvalidTransition
+	}
 
+	var fees int
+	if err := tx.Get(&fees, "SELECT COALESCE(SUM(fee), 0) FROM tournament_entries WHERE tournament_id = $1;", tournament.ID); err != nil {
+		return err
+	}
+	if err := takeBackFees(tx, tournament.Currency, fees, tournament.ID); err != nil {
+		return err
+	}
+	var entries []Stake
+	if err := tx.Select(&entries, "SELECT user_id, amount FROM tournament_entries WHERE tournament_id = $1 ORDER BY id;", tournament.ID); err != nil {
+		return err
+	}
+	for _, v := range entries {
+		if v.Amount == 0 {
+			continue
+		}
+		if err := changeBalance(tx, v.PlayerID, tournament.Currency, v.Amount, ReasonRefund, tournament.ID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM tournament_waitlist WHERE tournament_id = $1;", tournament.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE tournament SET state = $1, prize_pool = 0 WHERE id = $2;", StateCancelled, tournament.ID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	tournament.State = StateCancelled
 	return nil
 }
 
-//FinishTournament takes tournament and winners, and correspondingly gives out points to winning entries and their backers
-func (db *DB) FinishTournament(tournament *Tournament, winners []Winner) error {
+//LeaveTournament removes player entry and entries of its backers while registration is open, refunding what each of them was charged
+//including entry fee, freed seat goes to next player on waitlist, waitlisted player is just removed from waitlist
+func (db *DB) LeaveTournament(tournament *Tournament, playerID string) error {
 	tx := db.MustBegin()
-
 	defer tx.Rollback()
-	for _, v := range winners {
-		players, err := db.findPlayersWithBackers(tournament.ID, v.PlayerID)
+
+	if err := lockTournamentInState(tx, tournament.ID, StateRegistrationOpen); err != nil {
+		return err
+	}
+	var fee int
+	if err := tx.Get(&fee, "SELECT COALESCE(SUM(fee), 0) FROM tournament_entries WHERE tournament_id = $1 AND user_id = $2 AND backing_id IS NULL;", tournament.ID, playerID); err != nil {
+		return err
+	}
+	if err := takeBackFees(tx, tournament.Currency, fee, tournament.ID); err != nil {
+		return err
+	}
+	var entries []Stake
+	if err := tx.Select(&entries, "DELETE FROM tournament_entries WHERE tournament_id = $1 AND (( user_id = $2 AND backing_id IS NULL ) OR backing_id = $2) RETURNING user_id, amount;", tournament.ID, playerID); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		res, err := tx.Exec("DELETE FROM tournament_waitlist WHERE tournament_id = $1 AND (( user_id = $2 AND backing_id IS NULL ) OR backing_id = $2);", tournament.ID, playerID)
 		if err != nil {
 			return err
 		}
-		var rewards []int
-		if len(players) == 1 {
-			rewards = []int{v.Prize * 100}
-		} else {
-			rewards = splitEvenly(v.Prize*100, len(players))
-		}
-		for i, v := range players {
-			_, err := tx.Exec("UPDATE player SET balance = balance + $1 WHERE id = $2;", rewards[i], v)
-			if err != nil {
-				return err
+		if removed, err := res.RowsAffected(); err != nil || removed == 0 {
+			if err == nil {
+				err = sql.ErrNoRows
 			}
+			return err
 		}
+		return tx.Commit()
 	}
-	_, err := tx.Exec("UPDATE tournament SET finished = true WHERE id = $1;", tournament.ID)
+	refunded := 0
+	for _, v := range entries {
+		refunded += v.Amount
+		if v.Amount == 0 {
+			continue
+		}
+		if err := changeBalance(tx, v.PlayerID, tournament.Currency, v.Amount, ReasonRefund, tournament.ID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE tournament SET prize_pool = prize_pool - $1 WHERE id = $2;", refunded-fee, tournament.ID); err != nil {
+		return err
+	}
+	if err := promoteFromWaitlist(tx, tournament); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//lockTournament locks tournament row until end of transaction and returns its state
+func lockTournament(tx *sqlx.Tx, tournamentID string) (string, error) {
+	var state string
+	if err := tx.Get(&state, "SELECT state FROM tournament WHERE id = $1 FOR UPDATE;", tournamentID); err != nil {
+		return "", err
+	}
+	return state, nil
+}
+
+//lockTournamentInState locks tournament row until end of transaction and checks it is in given state
+func lockTournamentInState(tx *sqlx.Tx, tournamentID string, state string) error {
+	current, err := lockTournament(tx, tournamentID)
 	if err != nil {
 		return err
 	}
-	tx.Commit()
+	if current != state {
+		return ErrInvalidState
+	}
 	return nil
 }
 
-func (db *DB) findPlayersWithBackers(tournamentID string, playerID string) ([]string, error) {
-	var players []string
-	if err := db.Select(&players, "SELECT user_id FROM tournament_entries WHERE tournament_id = $1 AND (( user_id = $2 AND backing_id IS NULL ) OR backing_id = $2);", tournamentID, playerID); err != nil {
+//findPlayersWithBackers returns stakes of player entry and entries of its backers
+func findPlayersWithBackers(tx *sqlx.Tx, tournamentID string, playerID string) ([]Stake, error) {
+	var players []Stake
+	if err := tx.Select(&players, "SELECT user_id, amount FROM tournament_entries WHERE tournament_id = $1 AND (( user_id = $2 AND backing_id IS NULL ) OR backing_id = $2) ORDER BY id;", tournamentID, playerID); err != nil {
 		return nil, err
 	}
 	if len(players) == 0 {
-		return nil, errors.New("no players found")
+		return nil, ErrPlayerNotEntered
 	}
 	return players, nil
 }
 
-// ResetDatabase truncates all tables for clean database
+//Revenue returns entry fees in currency collected by house per tournament, optionally only for one tournament and for fees booked in time range [from, to)
+func (db *DB) Revenue(tournamentID string, currency string, from *time.Time, to *time.Time) ([]TournamentRevenue, error) {
+	var revenue []TournamentRevenue
+	err := db.Select(&revenue, `SELECT tournament_id, sum(amount) AS amount FROM ledger
+		WHERE player_id = $1 AND reason = $2 AND currency = $3 AND ($4 = '' OR tournament_id = $4)
+		AND ($5::timestamp with time zone IS NULL OR created_at >= $5) AND ($6::timestamp with time zone IS NULL OR created_at < $6)
+		GROUP BY tournament_id ORDER BY tournament_id;`, HouseAccountID, ReasonFee, currency, tournamentID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return revenue, nil
+}
+
+//PlayerHistory returns page of player ledger entries matching filter, newest first
+func (db *DB) PlayerHistory(filter HistoryFilter) ([]LedgerEntry, error) {
+	var reasons pq.StringArray
+	if len(filter.Reasons) > 0 {
+		reasons = pq.StringArray(filter.Reasons)
+	}
+	var history []LedgerEntry
+	err := db.Select(&history, `SELECT id, player_id, currency, amount, balance, reason, tournament_id, created_at FROM ledger
+		WHERE player_id = $1 AND ($2 = '' OR currency = $2) AND ($3::text[] IS NULL OR reason = ANY($3))
+		AND ($4::timestamp with time zone IS NULL OR created_at >= $4) AND ($5::timestamp with time zone IS NULL OR created_at < $5)
+		AND ($6 = 0 OR id < $6)
+		ORDER BY id DESC LIMIT $7;`, filter.PlayerID, filter.Currency, reasons, filter.From, filter.To, filter.Before, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+//TournamentResults returns results of every stake in finished tournament ordered by position, stakes of players who were not placed come last
+func (db *DB) TournamentResults(tournamentID string) ([]TournamentResult, error) {
+	var results []TournamentResult
+	err := db.Select(&results, `SELECT tournament_id, user_id, COALESCE(backing_id, '') AS backing_id, position, cost, prize, finished_at
+		FROM tournament_results WHERE tournament_id = $1 ORDER BY position NULLS LAST, id;`, tournamentID)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+//Leaderboard returns statistics of players ranked by filter sort, computed from results of tournaments matching filter
+func (db *DB) Leaderboard(filter LeaderboardFilter) ([]PlayerStatistics, error) {
+	order, ok := leaderboardOrder[filter.Sort]
+	if !ok {
+		order = leaderboardOrder[SortWinnings]
+	}
+	var statistics []PlayerStatistics
+	err := db.Select(&statistics, `SELECT * FROM (
+			SELECT r.user_id AS player_id, count(DISTINCT r.tournament_id) AS tournaments,
+			count(DISTINCT CASE WHEN r.prize > 0 THEN r.tournament_id END) AS in_the_money, sum(r.cost) AS cost, sum(r.prize) AS winnings
+			FROM tournament_results r JOIN tournament t ON t.id = r.tournament_id
+			WHERE t.currency = $1 AND (r.backing_id IS NOT NULL) = $2 AND ($3 = '' OR r.user_id = $3)
+			AND ($4::timestamp with time zone IS NULL OR r.finished_at >= $4) AND ($5::timestamp with time zone IS NULL OR r.finished_at < $5)
+			GROUP BY r.user_id
+		) statistics ORDER BY `+order+`, player_id LIMIT $6;`, filter.Currency, filter.Backers, filter.PlayerID, filter.From, filter.To, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return statistics, nil
+}
+
+//ReserveIdempotencyKey stores new key for endpoint and api key hash with hash of the request and returns nil,
+//or returns previously stored response if key was already used by the same api key
+func (db *DB) ReserveIdempotencyKey(keyHash string, key string, endpoint string, requestHash string) (*IdempotentResponse, error) {
+	res, err := db.Exec("INSERT INTO idempotency_keys (key_hash, key, endpoint, request_hash) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING;", keyHash, key, endpoint, requestHash)
+	if err != nil {
+		return nil, err
+	}
+	if inserted, err := res.RowsAffected(); err != nil || inserted == 1 {
+		return nil, err
+	}
+	var response IdempotentResponse
+	if err := db.Get(&response, "SELECT key_hash, key, endpoint, request_hash, COALESCE(status, 0) AS status, body FROM idempotency_keys WHERE key_hash = $1 AND key = $2 AND endpoint = $3;", keyHash, key, endpoint); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+//CompleteIdempotencyKey stores outcome of the request made with reserved key
+func (db *DB) CompleteIdempotencyKey(keyHash string, key string, endpoint string, status int, body string) error {
+	_, err := db.Exec("UPDATE idempotency_keys SET status = $1, body = $2 WHERE key_hash = $3 AND key = $4 AND endpoint = $5;", status, body, keyHash, key, endpoint)
+	return err
+}
+
+//ReleaseIdempotencyKey removes reserved key so request can be retried
+func (db *DB) ReleaseIdempotencyKey(keyHash string, key string, endpoint string) error {
+	_, err := db.Exec("DELETE FROM idempotency_keys WHERE key_hash = $1 AND key = $2 AND endpoint = $3;", keyHash, key, endpoint)
+	return err
+}
+
+//CreateAPIKey stores hashed api key, player keys are not tied to existing player so they can be issued before player is funded
+func (db *DB) CreateAPIKey(key *APIKey) error {
+	res, err := db.Exec("INSERT INTO api_keys (key_hash, scope, player_id) VALUES ($1, $2, NULLIF($3, '')) ON CONFLICT DO NOTHING;", key.Hash, key.Scope, key.PlayerID)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+//FindAPIKey returns api key by hash of the key
+func (db *DB) FindAPIKey(hash string) (*APIKey, error) {
+	var key APIKey
+	if err := db.Get(&key, "SELECT key_hash, scope, COALESCE(player_id, '') AS player_id FROM api_keys WHERE key_hash = $1;", hash); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+//CreateGameServer stores game server with its shared secret
+func (db *DB) CreateGameServer(server *GameServer) error {
+	res, err := db.Exec("INSERT INTO game_servers (id, secret) VALUES ($1, $2) ON CONFLICT DO NOTHING;", server.ID, server.Secret)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+//FindGameServer returns game server with its shared secret by id
+func (db *DB) FindGameServer(gameServerID string) (*GameServer, error) {
+	var server GameServer
+	if err := db.Get(&server, "SELECT id, secret FROM game_servers WHERE id = $1;", gameServerID); err != nil {
+		return nil, err
+	}
+	return &server, nil
+}
+
+//UseResultNonce records nonce of signed result submission, nonce already used by game server results in ErrAlreadyExists,
+//nonces recorded before expireBefore are removed as requests signed with them are rejected by timestamp anyway
+func (db *DB) UseResultNonce(gameServerID string, nonce string, expireBefore time.Time) error {
+	if _, err := db.Exec("DELETE FROM result_nonces WHERE created_at < $1;", expireBefore); err != nil {
+		return err
+	}
+	res, err := db.Exec("INSERT INTO result_nonces (game_server_id, nonce) VALUES ($1, $2) ON CONFLICT DO NOTHING;", gameServerID, nonce)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+//CreateWebhook stores webhook endpoint with its signing secret and sets its creation time
+func (db *DB) CreateWebhook(webhook *Webhook) error {
+	err := db.Get(&webhook.CreatedAt, "INSERT INTO webhooks (id, url, secret, events) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING RETURNING created_at;",
+		webhook.ID, webhook.URL, webhook.Secret, webhook.Events)
+	if err == sql.ErrNoRows {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+//FindWebhook returns webhook with its signing secret by id
+func (db *DB) FindWebhook(webhookID string) (*Webhook, error) {
+	var webhook Webhook
+	if err := db.Get(&webhook, "SELECT id, url, secret, events, created_at FROM webhooks WHERE id = $1;", webhookID); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+//ListWebhooks returns every webhook in order they were registered
+func (db *DB) ListWebhooks() ([]Webhook, error) {
+	var webhooks []Webhook
+	if err := db.Select(&webhooks, "SELECT id, url, secret, events, created_at FROM webhooks ORDER BY created_at, id;"); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+//DeleteWebhook removes webhook together with its delivery log and deliveries still pending
+func (db *DB) DeleteWebhook(webhookID string) error {
+	res, err := db.Exec("DELETE FROM webhooks WHERE id = $1;", webhookID)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//enqueueEvent queues delivery of event to every webhook subscribed to its type within transaction of the change which caused it,
+//so event is delivered only when the change is committed, webhooks without events receive all of them
+func enqueueEvent(tx *sqlx.Tx, eventType string, data interface{}) error {
+	eventID, payload, err := encodeEvent(eventType, data)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+		SELECT id, $1, $2, $3 FROM webhooks WHERE events = '{}' OR $2 = ANY(events) ORDER BY created_at, id;`, eventID, eventType, payload)
+	return err
+}
+
+//DueDeliveries claims pending deliveries whose next attempt is due at now by moving their next attempt to leaseUntil,
+//so other dispatchers skip them while they are sent, deliveries are returned oldest first with url and secret of their webhook
+func (db *DB) DueDeliveries(now time.Time, leaseUntil time.Time, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := db.Select(&deliveries, `UPDATE webhook_deliveries d SET next_attempt_at = $2 FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (SELECT id FROM webhook_deliveries WHERE status = $3 AND next_attempt_at <= $1
+			ORDER BY next_attempt_at, id LIMIT $4 FOR UPDATE SKIP LOCKED)
+		RETURNING d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at, d.last_status_code, d.last_error,
+			d.created_at, d.delivered_at, w.url, w.secret;`, now, leaseUntil, DeliveryPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries, nil
+}
+
+//RecordDeliveryAttempt stores outcome of delivery attempt: its status, attempts made, next attempt time and last response
+func (db *DB) RecordDeliveryAttempt(delivery *WebhookDelivery) error {
+	_, err := db.Exec(`UPDATE webhook_deliveries SET status = $2, attempts = $3, next_attempt_at = $4, last_status_code = $5, last_error = $6, delivered_at = $7
+		WHERE id = $1;`, delivery.ID, delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastStatusCode, delivery.LastError, delivery.DeliveredAt)
+	return err
+}
+
+//WebhookDeliveries returns page of webhook delivery log matching filter, newest first
+func (db *DB) WebhookDeliveries(filter DeliveryFilter) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := db.Select(&deliveries, `SELECT id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at
+		FROM webhook_deliveries WHERE webhook_id = $1 AND ($2 = '' OR status = $2) AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC LIMIT $4;`, filter.WebhookID, filter.Status, filter.Before, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+//changeBalance adds signed amount to player balance in currency and records the change in ledger within the same transaction,
+//balance row is created first when player never held the currency so check constraint is applied to the updated balance only
+func changeBalance(tx *sqlx.Tx, playerID string, currency string, amount int, reason string, tournamentID string) error {
+	if _, err := tx.Exec("INSERT INTO player_balance (player_id, currency) SELECT id, $2 FROM player WHERE id = $1 ON CONFLICT DO NOTHING;", playerID, currency); err != nil {
+		return err
+	}
+	var balance int
+	if err := tx.Get(&balance, "UPDATE player_balance SET balance = balance + $1 WHERE player_id = $2 AND currency = $3 RETURNING balance;", amount, playerID, currency); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrPlayerNotFound
+		}
+		return err
+	}
+	_, err := tx.Exec("INSERT INTO ledger (player_id, currency, amount, balance, reason, tournament_id) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''));", playerID, currency, amount, balance, reason, tournamentID)
+	return err
+}
+
+// ResetDatabase truncates all tables for clean database, api keys and game servers are kept so operators and game servers do not lose access
 func (db *DB) ResetDatabase() {
-	db.Exec("TRUNCATE tournament_entries, tournament, player;")
+	db.Exec("TRUNCATE webhook_deliveries, webhooks, result_nonces, idempotency_keys, ledger, tournament_results, tournament_waitlist, tournament_entries, tournament, player_balance, player;")
 }
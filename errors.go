@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+//Error codes returned in error response body, they are stable and clients can rely on them
+const (
+	CodeInvalidParameter    = "invalid_parameter"
+	CodePlayerNotFound      = "player_not_found"
+	CodeTournamentNotFound  = "tournament_not_found"
+	CodeEntryNotFound       = "entry_not_found"
+	CodeNotFound            = "not_found"
+	CodeInsufficientBalance = "insufficient_balance"
+	CodeHouseBalance        = "insufficient_house_balance"
+	CodeInvalidState        = "invalid_state"
+	CodeInvalidTransition   = "invalid_transition"
+	CodeNotEnoughEntrants   = "not_enough_entrants"
+	CodeAlreadyExists       = "already_exists"
+	CodeDeadlinePassed      = "registration_deadline_passed"
+	CodeInvalidStakes       = "invalid_stakes"
+	CodeInvalidPlacings     = "invalid_placings"
+	CodePrizeExceedsPool    = "prize_exceeds_pool"
+	CodePlayerNotEntered    = "player_not_entered"
+	CodeConstraintViolation = "constraint_violation"
+	CodeRequestInProgress   = "request_in_progress"
+	CodeKeyMismatch         = "idempotency_key_mismatch"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeInvalidSignature    = "invalid_signature"
+	CodeNonceReused         = "nonce_reused"
+	CodeGameServerRequired  = "game_server_required"
+	CodeAmountOutOfRange    = "amount_out_of_range"
+	CodeInvalidWinners      = "invalid_winners"
+	CodeWebhookNotFound     = "webhook_not_found"
+	CodeInternal            = "internal_error"
+)
+
+//Problem codes of single winner or placing listed in invalid_winners and invalid_placings errors
+const (
+	CodeDuplicatePlayer = "duplicate_player"
+	CodeNegativePrize   = "negative_prize"
+)
+
+//postgres error codes mapped to error responses
+const (
+	pqForeignKeyViolation = "23503"
+	pqUniqueViolation     = "23505"
+	pqCheckViolation      = "23514"
+	pqNumericOutOfRange   = "22003"
+)
+
+//APIError is error response with http status, machine-readable code, human message, request field which caused it if there is one
+//and problems of each winner or placing when tournament result is rejected
+type APIError struct {
+	Status   int             `json:"-"`
+	Code     string          `json:"code"`
+	Message  string          `json:"message"`
+	Field    string          `json:"field,omitempty"`
+	Problems []ResultProblem `json:"problems,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+//invalidParameter returns error for request field which is missing or malformed
+func invalidParameter(field string, message string) *APIError {
+	return &APIError{Status: http.StatusUnprocessableEntity, Code: CodeInvalidParameter, Message: message, Field: field}
+}
+
+//invalidAmount returns error for request field with malformed amount, or amount too large to be stored
+func invalidAmount(field string, message string, err error) *APIError {
+	if err == ErrAmountOutOfRange {
+		return errorFor(err).withField(field)
+	}
+	return invalidParameter(field, message)
+}
+
+//withField returns copy of error pointing to given request field
+func (e *APIError) withField(field string) *APIError {
+	copied := *e
+	copied.Field = field
+	return &copied
+}
+
+//writeError writes error response as {"error": {"code": ..., "message": ..., "field": ...}}
+func writeError(w http.ResponseWriter, e *APIError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(struct {
+		Error *APIError `json:"error"`
+	}{e})
+}
+
+//notFound returns error with given code and 404 status when datastore did not find looked up row, other errors are mapped by errorFor
+func notFound(err error, code string, field string) *APIError {
+	if err != sql.ErrNoRows {
+		return errorFor(err)
+	}
+	return &APIError{Status: http.StatusNotFound, Code: code, Message: field + " not found", Field: field}
+}
+
+//errorFor maps datastore or postgres error to error response, tournament state and duplicate conflicts are 409, invalid stakes, results and prizes 422,
+//balance problems and missing players or entries 400, missing rows 404 and unexpected errors 500
+func errorFor(err error) *APIError {
+	switch err {
+	case ErrInvalidState:
+		return &APIError{Status: http.StatusConflict, Code: CodeInvalidState, Message: err.Error()}
+	case ErrInvalidTransition:
+		return &APIError{Status: http.StatusConflict, Code: CodeInvalidTransition, Message: err.Error()}
+	case ErrNotEnoughEntrants:
+		return &APIError{Status: http.StatusConflict, Code: CodeNotEnoughEntrants, Message: err.Error()}
+	case ErrAlreadyExists:
+		return &APIError{Status: http.StatusConflict, Code: CodeAlreadyExists, Message: err.Error()}
+	case ErrDeadlinePassed:
+		return &APIError{Status: http.StatusConflict, Code: CodeDeadlinePassed, Message: err.Error()}
+	case ErrInvalidStakes:
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: CodeInvalidStakes, Message: err.Error(), Field: "backerId"}
+	case ErrInvalidPlacings:
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: CodeInvalidPlacings, Message: err.Error(), Field: "placings"}
+	case ErrPrizeExceedsPool:
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: CodePrizeExceedsPool, Message: err.Error(), Field: "winners"}
+	case ErrInsufficientBalance:
+		return &APIError{Status: http.StatusBadRequest, Code: CodeInsufficientBalance, Message: err.Error()}
+	case ErrHouseBalance:
+		return &APIError{Status: http.StatusConflict, Code: CodeHouseBalance, Message: err.Error()}
+	case ErrPlayerNotFound:
+		return &APIError{Status: http.StatusBadRequest, Code: CodePlayerNotFound, Message: err.Error(), Field: "playerId"}
+	case ErrAmountOutOfRange:
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: CodeAmountOutOfRange, Message: err.Error()}
+	case ErrPlayerNotEntered:
+		return &APIError{Status: http.StatusBadRequest, Code: CodePlayerNotEntered, Message: err.Error(), Field: "winners"}
+	case sql.ErrNoRows:
+		return &APIError{Status: http.StatusNotFound, Code: CodeNotFound, Message: "not found"}
+	}
+
+	if resultsErr, ok := err.(*ResultsError); ok {
+		code := CodeInvalidWinners
+		if resultsErr.Field == "placings" {
+			code = CodeInvalidPlacings
+		}
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: code, Message: err.Error(), Field: resultsErr.Field, Problems: resultsErr.Problems}
+	}
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code {
+		case pqCheckViolation:
+			if pqErr.Constraint == "player_balance_check" {
+				return errorFor(ErrInsufficientBalance)
+			}
+			return &APIError{Status: http.StatusBadRequest, Code: CodeConstraintViolation, Message: pqErr.Message, Field: pqErr.Column}
+		case pqForeignKeyViolation:
+			return &APIError{Status: http.StatusBadRequest, Code: CodePlayerNotFound, Message: pqErr.Message, Field: "playerId"}
+		case pqUniqueViolation:
+			return errorFor(ErrAlreadyExists)
+		case pqNumericOutOfRange:
+			return errorFor(ErrAmountOutOfRange)
+		}
+	}
+	return &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "internal error"}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/lib/pq"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestErrorFor(t *testing.T) {
+	Convey("Given datastore errors", t, func() {
+		Convey("Sentinel errors should keep their status and get stable codes", func() {
+			So(errorFor(ErrInvalidState).Status, ShouldEqual, http.StatusConflict)
+			So(errorFor(ErrInvalidState).Code, ShouldEqual, CodeInvalidState)
+			So(errorFor(ErrInvalidStakes).Status, ShouldEqual, http.StatusUnprocessableEntity)
+			So(errorFor(ErrInvalidStakes).Field, ShouldEqual, "backerId")
+			So(errorFor(ErrInsufficientBalance).Code, ShouldEqual, CodeInsufficientBalance)
+			So(errorFor(ErrPlayerNotEntered).Status, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Results errors should carry their problems", func() {
+			winners := errorFor(&ResultsError{Field: "winners", Problems: []ResultProblem{{Index: 1, PlayerID: "P1", Code: CodeNegativePrize}}})
+			placings := errorFor(&ResultsError{Field: "placings"})
+			So(winners.Status, ShouldEqual, http.StatusUnprocessableEntity)
+			So(winners.Code, ShouldEqual, CodeInvalidWinners)
+			So(winners.Problems, ShouldHaveLength, 1)
+			So(placings.Code, ShouldEqual, CodeInvalidPlacings)
+		})
+		Convey("Postgres constraint errors should be mapped to codes", func() {
+			balance := errorFor(&pq.Error{Code: "23514", Constraint: "player_balance_check"})
+			check := errorFor(&pq.Error{Code: "23514", Constraint: "tournament_fee_check", Column: "fee"})
+			reference := errorFor(&pq.Error{Code: "23503"})
+			duplicate := errorFor(&pq.Error{Code: "23505"})
+			So(balance.Code, ShouldEqual, CodeInsufficientBalance)
+			So(check.Code, ShouldEqual, CodeConstraintViolation)
+			So(check.Field, ShouldEqual, "fee")
+			So(reference.Code, ShouldEqual, CodePlayerNotFound)
+			So(duplicate.Status, ShouldEqual, http.StatusConflict)
+		})
+		Convey("Missing rows should be not found and unknown errors internal", func() {
+			So(errorFor(sql.ErrNoRows).Status, ShouldEqual, http.StatusNotFound)
+			So(notFound(sql.ErrNoRows, CodeTournamentNotFound, "tournamentId").Code, ShouldEqual, CodeTournamentNotFound)
+			So(errorFor(errors.New("connection reset")).Status, ShouldEqual, http.StatusInternalServerError)
+		})
+	})
+}
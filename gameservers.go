@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//Headers of signed result submissions, signature is hex encoded HMAC-SHA256 of signedResultMessage keyed with game server secret
+const (
+	signatureHeader = "X-Signature"
+	timestampHeader = "X-Timestamp"
+	nonceHeader     = "X-Nonce"
+)
+
+//resultSignatureWindow is how far signed result timestamp can be from server time, nonces are remembered for as long
+const resultSignatureWindow = 5 * time.Minute
+
+const maxNonceLength = 64
+
+//GameServer is structure that represent game_servers table entry in database, secret is shared with game server to sign tournament results
+type GameServer struct {
+	ID     string `db:"id" json:"id"`
+	Secret string `db:"secret" json:"secret"`
+}
+
+//GameServerRequest is request body for v2 game server registration
+type GameServerRequest struct {
+	ID string `json:"id"`
+}
+
+//signedResultMessage returns message game server signs: unix timestamp, nonce, method, path and raw body separated by new lines
+func signedResultMessage(timestamp string, nonce string, method string, path string, body []byte) []byte {
+	return append([]byte(timestamp+"\n"+nonce+"\n"+method+"\n"+path+"\n"), body...)
+}
+
+//signResult returns hex encoded HMAC-SHA256 signature of result submission
+func signResult(secret string, timestamp string, nonce string, method string, path string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(signedResultMessage(timestamp, nonce, method, path, body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//authenticateResults is middleware for result submissions, they are authenticated only by signature of tournament game server
+//which handler verifies, unsigned requests are rejected whatever api key they have
+func (h *Handlers) authenticateResults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(signatureHeader) == "" {
+			writeError(w, invalidSignature("results must be signed by tournament game server"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//verifyResults checks signature of raw result submission body, results have to be signed by game server tournament is tied to
+//with timestamp within resultSignatureWindow and nonce it did not use before, tournament without game server can not get results
+func (h *Handlers) verifyResults(r *http.Request, body []byte, tournamentID string) *APIError {
+	tournament, e := h.tournament(tournamentID)
+	if e != nil {
+		return e
+	}
+	if tournament.GameServerID == "" {
+		return &APIError{Status: http.StatusConflict, Code: CodeGameServerRequired, Message: "tournament is not tied to game server which could sign its results", Field: "tournamentId"}
+	}
+
+	signature := r.Header.Get(signatureHeader)
+	if signature == "" {
+		return invalidSignature("results must be signed by tournament game server")
+	}
+
+	timestamp := r.Header.Get(timestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return invalidSignature(timestampHeader + " must be unix time in seconds")
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > resultSignatureWindow || age < -resultSignatureWindow {
+		return invalidSignature(timestampHeader + " is too far from server time")
+	}
+	nonce := r.Header.Get(nonceHeader)
+	if nonce == "" || len(nonce) > maxNonceLength {
+		return invalidSignature(nonceHeader + " is required and can not be longer than 64 characters")
+	}
+	server, err := h.repo.FindGameServer(tournament.GameServerID)
+	if err != nil {
+		return errorFor(err)
+	}
+	expected := signResult(server.Secret, timestamp, nonce, r.Method, r.URL.Path, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return invalidSignature("signature does not match")
+	}
+
+	if err := h.repo.UseResultNonce(server.ID, nonce, time.Now().Add(-resultSignatureWindow)); err == ErrAlreadyExists {
+		return &APIError{Status: http.StatusConflict, Code: CodeNonceReused, Message: "nonce was already used", Field: nonceHeader}
+	} else if err != nil {
+		return errorFor(err)
+	}
+	return nil
+}
+
+//invalidSignature returns error for result submission which is not signed as required
+func invalidSignature(message string) *APIError {
+	return &APIError{Status: http.StatusUnauthorized, Code: CodeInvalidSignature, Message: message, Field: signatureHeader}
+}
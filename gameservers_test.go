@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSignedResults(t *testing.T) {
+	db := newTestStore(t)
+	db.ResetDatabase()
+	defer db.ResetDatabase()
+	router := newRouter(&Handlers{repo: db, config: defaultConfig()})
+	createTestAPIKey(db, testOperatorKey, ScopeOperator, "")
+
+	operator := loadSpecChecker(t, router, testOperatorKey)
+	server := loadSpecChecker(t, router, "")
+	var secret string
+
+	Convey("Given game servers can sign tournament results", t, func() {
+		Convey("Given operator registers game server G1 twice", func() {
+			w1 := operator.call("POST", "/v2/game-servers", `{"id": "G1"}`)
+			w2 := operator.call("POST", "/v2/game-servers", `{"id": "G1"}`)
+			Convey("Secret should be returned once and duplicate should conflict", func() {
+				So(w1.Code, ShouldEqual, http.StatusCreated)
+				var gameServer GameServer
+				json.NewDecoder(w1.Body).Decode(&gameServer)
+				So(gameServer.ID, ShouldEqual, "G1")
+				So(gameServer.Secret, ShouldNotBeEmpty)
+				secret = gameServer.Secret
+				So(w2.Code, ShouldEqual, http.StatusConflict)
+				So(operator.errors, ShouldBeEmpty)
+			})
+		})
+		Convey("Given tournaments G1 tied to game server and G2 without one are started with player G1 entered", func() {
+			w := operator.call("POST", "/v2/tournaments", `{"id": "G0", "deposit": 10, "gameServerId": "G9"}`)
+			operator.call("GET", "/fund?playerId=G1&points=100", "")
+			operator.call("POST", "/v2/tournaments", `{"id": "G1", "deposit": 10, "gameServerId": "G1"}`)
+			operator.call("POST", "/v2/tournaments", `{"id": "G2", "deposit": 10}`)
+			for _, v := range []string{"G1", "G2"} {
+				operator.call("PUT", "/v2/tournaments/"+v+"/state", `{"state": "registration_open"}`)
+				operator.call("POST", "/v2/tournaments/"+v+"/entries", `{"playerId": "G1"}`)
+				operator.call("PUT", "/v2/tournaments/"+v+"/state", `{"state": "registration_closed"}`)
+				operator.call("PUT", "/v2/tournaments/"+v+"/state", `{"state": "running"}`)
+			}
+			Convey("Tournament with unknown game server should be rejected", func() {
+				So(w.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorResponse(w).Field, ShouldEqual, "gameServerId")
+				So(operator.errors, ShouldBeEmpty)
+				tournament, _ := db.FindTournament("G1")
+				So(tournament.GameServerID, ShouldEqual, "G1")
+				So(tournament.State, ShouldEqual, StateRunning)
+			})
+		})
+		Convey("Given results of tournament G1 are posted unsigned, with wrong secret, expired timestamp or signed for other path", func() {
+			body := `{"tournamentId": "G1", "placings": ["G1"]}`
+			now := strconv.FormatInt(time.Now().Unix(), 10)
+			old := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+			w1 := operator.call("POST", "/resultTournament", body)
+			w2 := server.callWithHeaders("POST", "/resultTournament", body, signedHeaders("wrong", now, "n1", "/resultTournament", body))
+			w3 := server.callWithHeaders("POST", "/resultTournament", body, signedHeaders(secret, old, "n2", "/resultTournament", body))
+			w4 := server.callWithHeaders("POST", "/resultTournament", body, signedHeaders(secret, now, "n3", "/v2/tournaments/G1/results", body))
+			w5 := server.callWithHeaders("POST", "/resultTournament", body, signedHeaders(secret, now, "", "/resultTournament", body))
+			Convey("They should be rejected without paying out", func() {
+				for _, w := range []int{w1.Code, w2.Code, w3.Code, w4.Code, w5.Code} {
+					So(w, ShouldEqual, http.StatusUnauthorized)
+				}
+				So(errorResponse(w1).Code, ShouldEqual, CodeInvalidSignature)
+				So(errorResponse(w2).Code, ShouldEqual, CodeInvalidSignature)
+				So(server.errors, ShouldBeEmpty)
+				tournament, _ := db.FindTournament("G1")
+				So(tournament.State, ShouldEqual, StateRunning)
+			})
+		})
+		Convey("Given game server signs results of tournament G1 and request is replayed", func() {
+			body := `{"tournamentId": "G1", "placings": ["G1"]}`
+			headers := signedHeaders(secret, strconv.FormatInt(time.Now().Unix(), 10), "n4", "/resultTournament", body)
+			w1 := server.callWithHeaders("POST", "/resultTournament", body, headers)
+			w2 := server.callWithHeaders("POST", "/resultTournament", body, headers)
+			Convey("Prize should be paid once and replay rejected", func() {
+				//10 points deposit is still in tournament G2
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(w2.Code, ShouldEqual, http.StatusConflict)
+				So(errorResponse(w2).Code, ShouldEqual, CodeNonceReused)
+				So(server.errors, ShouldBeEmpty)
+				player, _ := db.FindPlayer("G1")
+				So(player.Balances["EUR"], ShouldEqual, 9000)
+			})
+		})
+		Convey("Given results of tournament G2 which is not tied to game server are posted unsigned by operator and signed by G1", func() {
+			body := `{"placings": ["G1"]}`
+			w1 := operator.call("POST", "/v2/tournaments/G2/results", body)
+			w2 := server.callWithHeaders("POST", "/v2/tournaments/G2/results", body, signedHeaders(secret, strconv.FormatInt(time.Now().Unix(), 10), "n5", "/v2/tournaments/G2/results", body))
+			Convey("They should be rejected without paying out", func() {
+				So(w1.Code, ShouldEqual, http.StatusUnauthorized)
+				So(errorResponse(w1).Code, ShouldEqual, CodeInvalidSignature)
+				So(w2.Code, ShouldEqual, http.StatusConflict)
+				So(errorResponse(w2).Code, ShouldEqual, CodeGameServerRequired)
+				So(server.errors, ShouldBeEmpty)
+				So(operator.errors, ShouldBeEmpty)
+				tournament, _ := db.FindTournament("G2")
+				So(tournament.State, ShouldEqual, StateRunning)
+				player, _ := db.FindPlayer("G1")
+				So(player.Balances["EUR"], ShouldEqual, 9000)
+			})
+		})
+	})
+}
+
+//Test game server tournaments in tests are tied to, so their results can be signed
+const (
+	testGameServerID     = "test-server"
+	testGameServerSecret = "test-server-secret"
+)
+
+//testNonce makes nonces of results signed by test game server unique
+var testNonce int
+
+//createTestGameServer registers test game server, it is kept on database reset same as api keys
+func createTestGameServer(db Datastore) {
+	db.CreateGameServer(&GameServer{ID: testGameServerID, Secret: testGameServerSecret})
+}
+
+//testResultHeaders returns headers of result submission signed by test game server with fresh nonce
+func testResultHeaders(path string, body string) map[string]string {
+	testNonce++
+	return signedHeaders(testGameServerSecret, strconv.FormatInt(time.Now().Unix(), 10), "test-"+strconv.Itoa(testNonce), path, body)
+}
+
+//signedHeaders returns headers of result submission signed by game server with given secret
+func signedHeaders(secret string, timestamp string, nonce string, path string, body string) map[string]string {
+	return map[string]string{
+		signatureHeader: signResult(secret, timestamp, nonce, "POST", path, []byte(body)),
+		timestampHeader: timestamp,
+		nonceHeader:     nonce,
+	}
+}
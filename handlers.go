@@ -1,25 +1,47 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 )
 
-// ResultsRequest is request for /POST resultTournament call body decoding
+const idempotencyHeader = "Idempotency-Key"
+const maxIdempotencyKeyLength = 128
+
+// ResultsRequest is request for /POST resultTournament call body decoding, either ranked placings or winners with prizes are given
 type ResultsRequest struct {
 	TournamentID string   `json:"tournamentId"`
+	Placings     []string `json:"placings"`
 	Winners      []Winner `json:"winners"`
 }
 
 // Winner holds winning entries in for ResultsRequest
 type Winner struct {
 	PlayerID string `json:"playerId"`
-	Prize    int    `json:"prize"`
+	Prize    Money  `json:"prize"`
+}
+
+//RevenueReport is response for GET /revenue call with entry fees collected by house in points of single currency
+type RevenueReport struct {
+	Currency    string                    `json:"currency"`
+	Total       Money                     `json:"total"`
+	Tournaments []TournamentRevenueReport `json:"tournaments"`
+}
+
+//TournamentRevenueReport holds revenue of single tournament in RevenueReport
+type TournamentRevenueReport struct {
+	TournamentID string `json:"tournamentId"`
+	Revenue      Money  `json:"revenue"`
 }
 
-//Handlers structure holds our handlers and access to datastore interface
+//Handlers structure holds our handlers, access to datastore interface and application configuration
 type Handlers struct {
-	repo Datastore
+	repo   Datastore
+	config Config
 }
 
 /**
@@ -28,21 +50,13 @@ type Handlers struct {
 func (h *Handlers) takeHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	playerID := r.Form.Get("playerId")
-	points, err := getPointsFromString(r.Form.Get("points"))
-	if err != nil || playerID == "" || points < 0 {
-		w.WriteHeader(http.StatusUnprocessableEntity)
+	points, err := ParseMoney(r.Form.Get("points"))
+	if err != nil && playerID != "" {
+		writeError(w, invalidAmount("points", "points must be non-negative number", err))
 		return
 	}
-
-	player, err := h.repo.FindPlayer(playerID)
-
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-
-	if err := h.repo.TakeFunds(player, points); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if _, e := h.take(playerID, r.Form.Get("currency"), int(points)); e != nil {
+		writeError(w, e)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -55,21 +69,13 @@ func (h *Handlers) fundHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 
 	playerID := r.Form.Get("playerId")
-	points, err := getPointsFromString(r.Form.Get("points"))
-
-	if err != nil || playerID == "" || points < 0 {
-		w.WriteHeader(http.StatusUnprocessableEntity)
+	points, err := ParseMoney(r.Form.Get("points"))
+	if err != nil && playerID != "" {
+		writeError(w, invalidAmount("points", "points must be non-negative number", err))
 		return
 	}
-
-	player, err := h.repo.FindOrCreatePlayer(playerID)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	if err := h.repo.AddFunds(player, points); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if _, e := h.fund(playerID, r.Form.Get("currency"), int(points)); e != nil {
+		writeError(w, e)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -82,14 +88,49 @@ func (h *Handlers) announceHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 
 	tournamentID := r.Form.Get("tournamentId")
-	deposit, err := getPointsFromString(r.Form.Get("deposit"))
-	if err != nil || tournamentID == "" || deposit <= 0 {
-		w.WriteHeader(http.StatusUnprocessableEntity)
+	if tournamentID == "" {
+		writeError(w, invalidParameter("tournamentId", "tournamentId is required"))
+		return
+	}
+	deposit, err := ParseMoney(r.Form.Get("deposit"))
+	if err != nil {
+		writeError(w, invalidAmount("deposit", "deposit must be positive number", err))
+		return
+	}
+	fee, err := getOptionalMoney(r.Form.Get("fee"))
+	if err != nil {
+		writeError(w, invalidAmount("fee", "fee must be non-negative number", err))
+		return
+	}
+	minEntrants, errMin := getOptionalCount(r.Form.Get("minEntrants"))
+	maxEntrants, errMax := getOptionalCount(r.Form.Get("maxEntrants"))
+	if errMin != nil || errMax != nil {
+		writeError(w, invalidParameter("minEntrants", "minEntrants and maxEntrants must be non-negative whole numbers"))
+		return
+	}
+	startsAt, errStart := getOptionalTime(r.Form.Get("startsAt"))
+	deadline, errDeadline := getOptionalTime(r.Form.Get("registrationDeadline"))
+	if errStart != nil || errDeadline != nil {
+		writeError(w, invalidParameter("startsAt", "startsAt and registrationDeadline must be RFC3339 times"))
 		return
 	}
 
-	if err := h.repo.CreateTournament(tournamentID, deposit); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	tournament := &Tournament{
+		ID:                   tournamentID,
+		Deposit:              int(deposit),
+		Fee:                  int(fee),
+		FeeIncluded:          r.Form.Get("feeIncluded") == "true",
+		MinEntrants:          minEntrants,
+		MaxEntrants:          maxEntrants,
+		Payout:               r.Form.Get("payout"),
+		StartsAt:             startsAt,
+		RegistrationDeadline: deadline,
+		AutoCancel:           r.Form.Get("autoCancel") == "true",
+		GameServerID:         r.Form.Get("gameServerId"),
+		Currency:             r.Form.Get("currency"),
+	}
+	if e := h.announce(tournament); e != nil {
+		writeError(w, e)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -101,44 +142,52 @@ func (h *Handlers) announceHandler(w http.ResponseWriter, r *http.Request) {
 **/
 func (h *Handlers) joinHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
-	tournamentID := r.Form.Get("tournamentId")
-	playerID := r.Form.Get("playerId")
-	if tournamentID == "" || playerID == "" {
-		w.WriteHeader(http.StatusUnprocessableEntity)
+	if e := authorizePlayer(r, r.Form.Get("playerId"), r.Form["backerId"]); e != nil {
+		writeError(w, e)
 		return
 	}
-	tournament, err := h.repo.FindTournament(tournamentID)
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+	entry, e := h.join(r.Form.Get("tournamentId"), r.Form.Get("playerId"), r.Form["backerId"], r.Form["backerShare"], r.Form["backerAmount"])
+	if e != nil {
+		writeError(w, e)
 		return
 	}
-	if err := h.repo.TournamentJoinPlayers(tournament, playerID, r.Form["backerId"]); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if entry.Waitlisted {
+		w.WriteHeader(http.StatusAccepted)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 
 }
 
+/**
+* GET /leaveTournament
+**/
+func (h *Handlers) leaveHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if e := h.leave(r.Form.Get("tournamentId"), r.Form.Get("playerId")); e != nil {
+		writeError(w, e)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 /**
 * POST /resultTournament
 **/
 func (h *Handlers) resultHandler(w http.ResponseWriter, r *http.Request) {
 
-	decoder := json.NewDecoder(r.Body)
+	body, err := ioutil.ReadAll(r.Body)
 	var results ResultsRequest
-	if err := decoder.Decode(&results); err != nil {
-		w.WriteHeader(http.StatusUnprocessableEntity)
+	if err != nil || json.Unmarshal(body, &results) != nil {
+		writeError(w, invalidParameter("", "request body must be json results"))
 		return
 	}
-
-	tournament, err := h.repo.FindTournament(results.TournamentID)
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+	if e := h.verifyResults(r, body, results.TournamentID); e != nil {
+		writeError(w, e)
 		return
 	}
-	if err := h.repo.FinishTournament(tournament, results.Winners); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if _, e := h.finish(results); e != nil {
+		writeError(w, e)
 		return
 	}
 
@@ -146,17 +195,66 @@ func (h *Handlers) resultHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+/**
+* GET /openRegistration
+* GET /closeRegistration
+* GET /startTournament
+* GET /cancelTournament
+**/
+func (h *Handlers) transitionHandler(state string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if _, e := h.changeState(r.Form.Get("tournamentId"), state); e != nil {
+			writeError(w, e)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
 /**
 * GET /balance
 **/
 func (h *Handlers) balanceHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
-	player, err := h.repo.FindPlayer(r.Form.Get("playerId"))
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+	if e := authorizePlayer(r, r.Form.Get("playerId"), nil); e != nil {
+		writeError(w, e)
 		return
 	}
-	json.NewEncoder(w).Encode(player)
+	wallet, e := h.wallet(r.Form.Get("playerId"), r.Form.Get("currency"))
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	json.NewEncoder(w).Encode(wallet)
+}
+
+/**
+* GET /revenue
+**/
+func (h *Handlers) revenueHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	from, errFrom := getOptionalTime(r.Form.Get("from"))
+	to, errTo := getOptionalTime(r.Form.Get("to"))
+	if errFrom != nil {
+		writeError(w, invalidParameter("from", "from must be RFC3339 time"))
+		return
+	}
+	if errTo != nil {
+		writeError(w, invalidParameter("to", "to must be RFC3339 time"))
+		return
+	}
+	currency, e := h.currency(r.Form.Get("currency"))
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	report, e := h.revenue(r.Form.Get("tournamentId"), currency, from, to)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	json.NewEncoder(w).Encode(report)
 }
 
 /**
@@ -166,3 +264,92 @@ func (h *Handlers) resetHandler(w http.ResponseWriter, r *http.Request) {
 	h.repo.ResetDatabase()
 	w.WriteHeader(http.StatusOK)
 }
+
+//idempotent wraps handler so request repeated with the same idempotency key (header or idempotencyKey form field) gets the first response replayed,
+//key reused for different request is rejected
+func (h *Handlers) idempotent(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if r.Body != nil {
+			var err error
+			if body, err = ioutil.ReadAll(r.Body); err != nil {
+				writeError(w, invalidParameter("body", "request body can not be read"))
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			key = r.FormValue("idempotencyKey")
+		}
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, invalidParameter(idempotencyHeader, "idempotency key is too long"))
+			return
+		}
+
+		var keyHash string
+		if apiKey := requestAPIKey(r); apiKey != nil {
+			keyHash = apiKey.Hash
+		}
+		hash := requestHash(r, body)
+		previous, err := h.repo.ReserveIdempotencyKey(keyHash, key, endpoint, hash)
+		if err != nil {
+			writeError(w, errorFor(err))
+			return
+		}
+		if previous != nil {
+			if previous.RequestHash != hash {
+				writeError(w, &APIError{Status: http.StatusUnprocessableEntity, Code: CodeKeyMismatch, Message: "idempotency key was used for different request", Field: idempotencyHeader})
+				return
+			}
+			if previous.Status == 0 {
+				writeError(w, &APIError{Status: http.StatusConflict, Code: CodeRequestInProgress, Message: "request with the same idempotency key is in progress"})
+				return
+			}
+			if previous.Body != "" {
+				w.Header().Set("Content-Type", "application/json")
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(previous.Status)
+			w.Write([]byte(previous.Body))
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		if rec.status >= http.StatusInternalServerError {
+			h.repo.ReleaseIdempotencyKey(keyHash, key, endpoint)
+			return
+		}
+		h.repo.CompleteIdempotencyKey(keyHash, key, endpoint, rec.status, rec.body.String())
+	}
+}
+
+//requestHash returns hex encoded SHA-256 of request method, path with query and body, which identifies request idempotency key is used for
+func requestHash(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+//responseRecorder passes response through while keeping status and body for idempotency keys
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
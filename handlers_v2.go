@@ -0,0 +1,683 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/lib/pq"
+)
+
+const defaultPageSize = 20
+const maxPageSize = 100
+
+//FundsRequest is request body for v2 deposit and withdrawal calls, default currency is used when currency is not given
+type FundsRequest struct {
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency"`
+}
+
+//FundsResponse is response for v2 deposit and withdrawal calls with player balance in the currency after the change
+type FundsResponse struct {
+	PlayerID string `json:"playerId"`
+	Currency string `json:"currency"`
+	Amount   Money  `json:"amount"`
+	Balance  Money  `json:"balance"`
+}
+
+//TournamentRequest is request body for v2 tournament creation, amounts are in points of tournament currency
+type TournamentRequest struct {
+	ID                   string      `json:"id"`
+	Currency             string      `json:"currency"`
+	Deposit              json.Number `json:"deposit"`
+	Fee                  json.Number `json:"fee"`
+	FeeIncluded          bool        `json:"feeIncluded"`
+	MinEntrants          int         `json:"minEntrants"`
+	MaxEntrants          int         `json:"maxEntrants"`
+	Payout               string      `json:"payout"`
+	StartsAt             *time.Time  `json:"startsAt"`
+	RegistrationDeadline *time.Time  `json:"registrationDeadline"`
+	AutoCancel           bool        `json:"autoCancel"`
+	GameServerID         string      `json:"gameServerId"`
+}
+
+//EntryRequest is request body for v2 tournament entry, backers give either share in percent or amount in points
+type EntryRequest struct {
+	PlayerID string `json:"playerId"`
+	Backers  []struct {
+		PlayerID string      `json:"playerId"`
+		Share    json.Number `json:"share"`
+		Amount   json.Number `json:"amount"`
+	} `json:"backers"`
+}
+
+//StateRequest is request body for v2 tournament state change
+type StateRequest struct {
+	State string `json:"state"`
+}
+
+//TournamentList is response for v2 tournament listing, next cursor is given only when there are more tournaments to list
+type TournamentList struct {
+	Tournaments []Tournament `json:"tournaments"`
+	NextCursor  string       `json:"nextCursor,omitempty"`
+}
+
+//TransactionList is response for v2 player transaction history, next cursor is given only when there are more transactions to list
+type TransactionList struct {
+	Transactions []LedgerEntry `json:"transactions"`
+	NextCursor   string        `json:"nextCursor,omitempty"`
+}
+
+//Leaderboard is response for v2 leaderboards with player statistics in points of single currency, best ranked first
+type Leaderboard struct {
+	Currency string             `json:"currency"`
+	Sort     string             `json:"sort"`
+	Players  []PlayerStatistics `json:"players"`
+}
+
+//PlayerResults is response for v2 player statistics with results of player own entries and of its backing stakes in single currency
+type PlayerResults struct {
+	PlayerID string           `json:"playerId"`
+	Currency string           `json:"currency"`
+	Player   PlayerStatistics `json:"player"`
+	Backer   PlayerStatistics `json:"backer"`
+}
+
+//routesV2 registers resource-oriented api taking and returning json bodies, player keys can only read players and tournaments and create entries
+func (h *Handlers) routesV2(r chi.Router) {
+	r.With(h.authenticateResults).Post("/tournaments/{tournamentId}/results", h.createResultsV2)
+
+	r.Group(func(r chi.Router) {
+		r.Use(h.authenticate)
+		r.Get("/players/{playerId}", h.getPlayerV2)
+		r.Get("/players/{playerId}/transactions", h.listTransactionsV2)
+		r.Get("/players/{playerId}/statistics", h.getStatisticsV2)
+		r.Get("/leaderboards/players", h.leaderboardV2(false))
+		r.Get("/leaderboards/backers", h.leaderboardV2(true))
+		r.Get("/tournaments", h.listTournamentsV2)
+		r.Get("/tournaments/{tournamentId}", h.getTournamentV2)
+		r.Post("/tournaments/{tournamentId}/entries", h.idempotent("v2.entries", h.createEntryV2))
+
+		r.Group(func(r chi.Router) {
+			r.Use(requireOperator)
+			r.Post("/players/{playerId}/deposits", h.idempotent("v2.deposits", h.fundsV2(h.fund)))
+			r.Post("/players/{playerId}/withdrawals", h.idempotent("v2.withdrawals", h.fundsV2(h.take)))
+			r.Post("/tournaments", h.createTournamentV2)
+			r.Put("/tournaments/{tournamentId}/state", h.changeStateV2)
+			r.Get("/tournaments/{tournamentId}/entries", h.listEntriesV2)
+			r.Get("/tournaments/{tournamentId}/results", h.listResultsV2)
+			r.Delete("/tournaments/{tournamentId}/entries/{playerId}", h.deleteEntryV2)
+			r.Post("/game-servers", h.createGameServerV2)
+			r.Post("/webhooks", h.createWebhookV2)
+			r.Get("/webhooks", h.listWebhooksV2)
+			r.Delete("/webhooks/{webhookId}", h.deleteWebhookV2)
+			r.Get("/webhooks/{webhookId}/deliveries", h.listDeliveriesV2)
+			r.Get("/revenue", h.revenueHandler)
+			if h.config.AdminEnabled {
+				r.Post("/reset", h.resetV2)
+			}
+		})
+	})
+}
+
+/**
+* GET /v2/players/{playerId}
+**/
+func (h *Handlers) getPlayerV2(w http.ResponseWriter, r *http.Request) {
+	if e := authorizePlayer(r, chi.URLParam(r, "playerId"), nil); e != nil {
+		writeError(w, e)
+		return
+	}
+	player, e := h.player(chi.URLParam(r, "playerId"))
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	writeJSON(w, http.StatusOK, player)
+}
+
+/**
+* GET /v2/players/{playerId}/transactions
+**/
+func (h *Handlers) listTransactionsV2(w http.ResponseWriter, r *http.Request) {
+	playerID := chi.URLParam(r, "playerId")
+	if e := authorizePlayer(r, playerID, nil); e != nil {
+		writeError(w, e)
+		return
+	}
+	query := r.URL.Query()
+	filter := HistoryFilter{PlayerID: playerID, Reasons: query["type"]}
+	for _, v := range filter.Reasons {
+		switch v {
+		case ReasonFund, ReasonTake, ReasonEntry, ReasonBacking, ReasonPrize, ReasonRefund, ReasonFee:
+		default:
+			writeError(w, invalidParameter("type", "type must be one of fund, take, entry, backing, prize, refund or fee"))
+			return
+		}
+	}
+	if query.Get("currency") != "" {
+		currency, e := h.currency(query.Get("currency"))
+		if e != nil {
+			writeError(w, e)
+			return
+		}
+		filter.Currency = currency
+	}
+	var e *APIError
+	if filter.From, filter.To, e = parseTimeRange(query.Get("from"), query.Get("to")); e != nil {
+		writeError(w, e)
+		return
+	}
+	var err error
+	if filter.Before, err = getOptionalIDCursor(query.Get("cursor")); err != nil {
+		writeError(w, invalidParameter("cursor", "cursor must be nextCursor of previous page"))
+		return
+	}
+	if filter.Limit, err = getPageSize(query.Get("limit")); err != nil {
+		writeError(w, invalidParameter("limit", "limit must be whole number from 1 to "+strconv.Itoa(maxPageSize)))
+		return
+	}
+	list, e := h.history(filter)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+/**
+* GET /v2/players/{playerId}/statistics
+**/
+func (h *Handlers) getStatisticsV2(w http.ResponseWriter, r *http.Request) {
+	playerID := chi.URLParam(r, "playerId")
+	if e := authorizePlayer(r, playerID, nil); e != nil {
+		writeError(w, e)
+		return
+	}
+	query := r.URL.Query()
+	from, to, e := parseTimeRange(query.Get("from"), query.Get("to"))
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	currency, e := h.currency(query.Get("currency"))
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	results, e := h.statistics(playerID, currency, from, to)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+/**
+* GET /v2/leaderboards/players
+* GET /v2/leaderboards/backers
+**/
+func (h *Handlers) leaderboardV2(backers bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := LeaderboardFilter{Backers: backers, Sort: query.Get("sort")}
+		switch filter.Sort {
+		case "":
+			filter.Sort = SortWinnings
+		case SortWinnings, SortProfit, SortROI, SortITM, SortTournaments:
+		default:
+			writeError(w, invalidParameter("sort", "sort must be one of winnings, profit, roi, itm or tournaments"))
+			return
+		}
+		var e *APIError
+		if filter.From, filter.To, e = parseTimeRange(query.Get("from"), query.Get("to")); e != nil {
+			writeError(w, e)
+			return
+		}
+		if filter.Currency, e = h.currency(query.Get("currency")); e != nil {
+			writeError(w, e)
+			return
+		}
+		var err error
+		if filter.Limit, err = getPageSize(query.Get("limit")); err != nil {
+			writeError(w, invalidParameter("limit", "limit must be whole number from 1 to "+strconv.Itoa(maxPageSize)))
+			return
+		}
+		board, e := h.leaderboard(filter)
+		if e != nil {
+			writeError(w, e)
+			return
+		}
+		writeJSON(w, http.StatusOK, board)
+	}
+}
+
+/**
+* POST /v2/players/{playerId}/deposits
+* POST /v2/players/{playerId}/withdrawals
+**/
+func (h *Handlers) fundsV2(change func(playerID string, currency string, points int) (*Wallet, *APIError)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body FundsRequest
+		if e := decodeJSON(r, &body); e != nil {
+			writeError(w, e)
+			return
+		}
+		amount, e := parseAmount(body.Amount, "amount")
+		if e != nil {
+			writeError(w, e)
+			return
+		}
+		wallet, e := change(chi.URLParam(r, "playerId"), body.Currency, amount)
+		if e != nil {
+			writeError(w, e)
+			return
+		}
+		writeJSON(w, http.StatusCreated, FundsResponse{PlayerID: wallet.PlayerID, Currency: wallet.Currency, Amount: Money(amount), Balance: wallet.Balance})
+	}
+}
+
+/**
+* POST /v2/tournaments
+**/
+func (h *Handlers) createTournamentV2(w http.ResponseWriter, r *http.Request) {
+	var body TournamentRequest
+	if e := decodeJSON(r, &body); e != nil {
+		writeError(w, e)
+		return
+	}
+	deposit, e := parseAmount(body.Deposit, "deposit")
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	fee := 0
+	if body.Fee != "" {
+		if fee, e = parseAmount(body.Fee, "fee"); e != nil {
+			writeError(w, e)
+			return
+		}
+	}
+
+	tournament := &Tournament{
+		ID:                   body.ID,
+		Currency:             body.Currency,
+		Deposit:              deposit,
+		Fee:                  fee,
+		FeeIncluded:          body.FeeIncluded,
+		MinEntrants:          body.MinEntrants,
+		MaxEntrants:          body.MaxEntrants,
+		Payout:               body.Payout,
+		StartsAt:             body.StartsAt,
+		RegistrationDeadline: body.RegistrationDeadline,
+		AutoCancel:           body.AutoCancel,
+		GameServerID:         body.GameServerID,
+	}
+	if e := h.announce(tournament); e != nil {
+		if e.Field == "tournamentId" {
+			e.Field = "id"
+		}
+		writeError(w, e)
+		return
+	}
+	w.Header().Set("Location", "/v2/tournaments/"+tournament.ID)
+	writeJSON(w, http.StatusCreated, tournament)
+}
+
+/**
+* GET /v2/tournaments/{tournamentId}
+**/
+func (h *Handlers) getTournamentV2(w http.ResponseWriter, r *http.Request) {
+	tournament, e := h.tournament(chi.URLParam(r, "tournamentId"))
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	writeJSON(w, http.StatusOK, tournament)
+}
+
+/**
+* GET /v2/tournaments
+**/
+func (h *Handlers) listTournamentsV2(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := TournamentFilter{State: query.Get("state")}
+	switch filter.State {
+	case "", StateAnnounced, StateRegistrationOpen, StateRegistrationClosed, StateRunning, StateFinished, StateCancelled:
+	default:
+		writeError(w, invalidParameter("state", "state must be one of announced, registration_open, registration_closed, running, finished or cancelled"))
+		return
+	}
+	var e *APIError
+	if filter.From, filter.To, e = parseTimeRange(query.Get("from"), query.Get("to")); e != nil {
+		writeError(w, e)
+		return
+	}
+	var err error
+	if filter.After, err = getOptionalCursor(query.Get("cursor")); err != nil {
+		writeError(w, invalidParameter("cursor", "cursor must be nextCursor of previous page"))
+		return
+	}
+	if filter.Limit, err = getPageSize(query.Get("limit")); err != nil {
+		writeError(w, invalidParameter("limit", "limit must be whole number from 1 to "+strconv.Itoa(maxPageSize)))
+		return
+	}
+	list, e := h.tournaments(filter)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+/**
+* GET /v2/tournaments/{tournamentId}/entries
+**/
+func (h *Handlers) listEntriesV2(w http.ResponseWriter, r *http.Request) {
+	entries, e := h.entries(chi.URLParam(r, "tournamentId"))
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Entries []Entry `json:"entries"`
+	}{entries})
+}
+
+/**
+* GET /v2/tournaments/{tournamentId}/results
+**/
+func (h *Handlers) listResultsV2(w http.ResponseWriter, r *http.Request) {
+	results, e := h.results(chi.URLParam(r, "tournamentId"))
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	if results == nil {
+		results = []TournamentResult{}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Results []TournamentResult `json:"results"`
+	}{results})
+}
+
+/**
+* PUT /v2/tournaments/{tournamentId}/state
+**/
+func (h *Handlers) changeStateV2(w http.ResponseWriter, r *http.Request) {
+	var body StateRequest
+	if e := decodeJSON(r, &body); e != nil {
+		writeError(w, e)
+		return
+	}
+	switch body.State {
+	case StateRegistrationOpen, StateRegistrationClosed, StateRunning, StateCancelled:
+	default:
+		writeError(w, invalidParameter("state", "state must be one of registration_open, registration_closed, running or cancelled, tournament is finished by posting its results"))
+		return
+	}
+	tournament, e := h.changeState(chi.URLParam(r, "tournamentId"), body.State)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	writeJSON(w, http.StatusOK, tournament)
+}
+
+/**
+* POST /v2/tournaments/{tournamentId}/entries
+**/
+func (h *Handlers) createEntryV2(w http.ResponseWriter, r *http.Request) {
+	var body EntryRequest
+	if e := decodeJSON(r, &body); e != nil {
+		writeError(w, e)
+		return
+	}
+	var backerIDs, shares, amounts []string
+	for _, v := range body.Backers {
+		backerIDs = append(backerIDs, v.PlayerID)
+		if v.Share != "" {
+			shares = append(shares, v.Share.String())
+		}
+		if v.Amount != "" {
+			amounts = append(amounts, v.Amount.String())
+		}
+	}
+	if e := authorizePlayer(r, body.PlayerID, backerIDs); e != nil {
+		writeError(w, e)
+		return
+	}
+	entry, e := h.join(chi.URLParam(r, "tournamentId"), body.PlayerID, backerIDs, shares, amounts)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	if entry.Waitlisted {
+		writeJSON(w, http.StatusAccepted, entry)
+		return
+	}
+	w.Header().Set("Location", "/v2/tournaments/"+entry.TournamentID+"/entries/"+entry.PlayerID)
+	writeJSON(w, http.StatusCreated, entry)
+}
+
+/**
+* DELETE /v2/tournaments/{tournamentId}/entries/{playerId}
+**/
+func (h *Handlers) deleteEntryV2(w http.ResponseWriter, r *http.Request) {
+	if e := h.leave(chi.URLParam(r, "tournamentId"), chi.URLParam(r, "playerId")); e != nil {
+		writeError(w, e)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+* POST /v2/tournaments/{tournamentId}/results
+**/
+func (h *Handlers) createResultsV2(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	var results ResultsRequest
+	if err != nil || json.Unmarshal(body, &results) != nil {
+		writeError(w, invalidParameter("", "request body must be valid json"))
+		return
+	}
+	results.TournamentID = chi.URLParam(r, "tournamentId")
+	if e := h.verifyResults(r, body, results.TournamentID); e != nil {
+		writeError(w, e)
+		return
+	}
+	tournament, e := h.finish(results)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	writeJSON(w, http.StatusOK, tournament)
+}
+
+/**
+* POST /v2/game-servers
+**/
+func (h *Handlers) createGameServerV2(w http.ResponseWriter, r *http.Request) {
+	var body GameServerRequest
+	if e := decodeJSON(r, &body); e != nil {
+		writeError(w, e)
+		return
+	}
+	if body.ID == "" || len(body.ID) > 64 {
+		writeError(w, invalidParameter("id", "id is required and can not be longer than 64 characters"))
+		return
+	}
+	secret, err := newAPIKey()
+	if err != nil {
+		writeError(w, errorFor(err))
+		return
+	}
+	server := &GameServer{ID: body.ID, Secret: secret}
+	if err := h.repo.CreateGameServer(server); err != nil {
+		writeError(w, errorFor(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, server)
+}
+
+/**
+* POST /v2/webhooks
+**/
+func (h *Handlers) createWebhookV2(w http.ResponseWriter, r *http.Request) {
+	var body WebhookRequest
+	if e := decodeJSON(r, &body); e != nil {
+		writeError(w, e)
+		return
+	}
+	if body.ID == "" || len(body.ID) > 64 {
+		writeError(w, invalidParameter("id", "id is required and can not be longer than 64 characters"))
+		return
+	}
+	if u, err := url.Parse(body.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		writeError(w, invalidParameter("url", "url must be absolute http or https url"))
+		return
+	}
+	seen := make(map[string]bool)
+	for _, v := range body.Events {
+		if !validEvent(v) || seen[v] {
+			writeError(w, invalidParameter("events", "events must be distinct event types: tournament.announced, entry.accepted, tournament.finished, prize.credited, funds.added or funds.taken"))
+			return
+		}
+		seen[v] = true
+	}
+	secret, err := newAPIKey()
+	if err != nil {
+		writeError(w, errorFor(err))
+		return
+	}
+	webhook := &Webhook{ID: body.ID, URL: body.URL, Secret: secret, Events: pq.StringArray(body.Events)}
+	if webhook.Events == nil {
+		webhook.Events = pq.StringArray{}
+	}
+	if err := h.repo.CreateWebhook(webhook); err != nil {
+		writeError(w, errorFor(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, webhook)
+}
+
+/**
+* GET /v2/webhooks
+**/
+func (h *Handlers) listWebhooksV2(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := h.repo.ListWebhooks()
+	if err != nil {
+		writeError(w, errorFor(err))
+		return
+	}
+	list := make([]Webhook, 0, len(webhooks))
+	for _, v := range webhooks {
+		v.Secret = ""
+		list = append(list, v)
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Webhooks []Webhook `json:"webhooks"`
+	}{list})
+}
+
+/**
+* DELETE /v2/webhooks/{webhookId}
+**/
+func (h *Handlers) deleteWebhookV2(w http.ResponseWriter, r *http.Request) {
+	if err := h.repo.DeleteWebhook(chi.URLParam(r, "webhookId")); err != nil {
+		writeError(w, notFound(err, CodeWebhookNotFound, "webhookId"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+* GET /v2/webhooks/{webhookId}/deliveries
+**/
+func (h *Handlers) listDeliveriesV2(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := DeliveryFilter{WebhookID: chi.URLParam(r, "webhookId"), Status: query.Get("status")}
+	switch filter.Status {
+	case "", DeliveryPending, DeliveryDelivered, DeliveryFailed:
+	default:
+		writeError(w, invalidParameter("status", "status must be one of pending, delivered or failed"))
+		return
+	}
+	var err error
+	if filter.Before, err = getOptionalIDCursor(query.Get("cursor")); err != nil {
+		writeError(w, invalidParameter("cursor", "cursor must be nextCursor of previous page"))
+		return
+	}
+	if filter.Limit, err = getPageSize(query.Get("limit")); err != nil {
+		writeError(w, invalidParameter("limit", "limit must be whole number from 1 to "+strconv.Itoa(maxPageSize)))
+		return
+	}
+	if _, err := h.repo.FindWebhook(filter.WebhookID); err != nil {
+		writeError(w, notFound(err, CodeWebhookNotFound, "webhookId"))
+		return
+	}
+
+	limit := filter.Limit
+	filter.Limit++
+	deliveries, err := h.repo.WebhookDeliveries(filter)
+	if err != nil {
+		writeError(w, errorFor(err))
+		return
+	}
+	list := &DeliveryList{Deliveries: make([]WebhookDelivery, 0, len(deliveries))}
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+		list.NextCursor = encodeIDCursor(deliveries[limit-1].ID)
+	}
+	list.Deliveries = append(list.Deliveries, deliveries...)
+	writeJSON(w, http.StatusOK, list)
+}
+
+/**
+* POST /v2/reset
+**/
+func (h *Handlers) resetV2(w http.ResponseWriter, r *http.Request) {
+	h.repo.ResetDatabase()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//decodeJSON decodes json request body into v
+func decodeJSON(r *http.Request, v interface{}) *APIError {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return invalidParameter("", "request body must be valid json")
+	}
+	return nil
+}
+
+//parseTimeRange parses optional from and to query parameters
+func parseTimeRange(from string, to string) (*time.Time, *time.Time, *APIError) {
+	fromTime, err := getOptionalTime(from)
+	if err != nil {
+		return nil, nil, invalidParameter("from", "from must be RFC3339 time")
+	}
+	toTime, err := getOptionalTime(to)
+	if err != nil {
+		return nil, nil, invalidParameter("to", "to must be RFC3339 time")
+	}
+	return fromTime, toTime, nil
+}
+
+//parseAmount parses points amount given as json number
+func parseAmount(amount json.Number, field string) (int, *APIError) {
+	points, err := ParseMoney(amount.String())
+	if err != nil {
+		return 0, invalidAmount(field, field+" must be number with at most two decimal places", err)
+	}
+	return int(points), nil
+}
+
+//writeJSON writes v as json response with given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAPIV2(t *testing.T) {
+	db := newTestStore(t)
+	db.ResetDatabase()
+	defer db.ResetDatabase()
+	router := newRouter(&Handlers{repo: db, config: defaultConfig()})
+	createTestAPIKey(db, testOperatorKey, ScopeOperator, "")
+	createTestGameServer(db)
+
+	Convey("Given v2 api", t, func() {
+		Convey("Given i deposit 100 points to player V1 and try to withdraw 150", func() {
+			w1 := requestV2(router, "POST", "/v2/players/V1/deposits", `{"amount": 100}`)
+			w2 := requestV2(router, "POST", "/v2/players/V1/withdrawals", `{"amount": "150"}`)
+			Convey("Deposit should be created with new balance and withdrawal should fail", func() {
+				So(w1.Code, ShouldEqual, http.StatusCreated)
+				var funds FundsResponse
+				json.NewDecoder(w1.Body).Decode(&funds)
+				So(funds, ShouldResemble, FundsResponse{PlayerID: "V1", Currency: "EUR", Amount: 10000, Balance: 10000})
+				So(w2.Code, ShouldEqual, http.StatusBadRequest)
+				So(errorResponse(w2).Code, ShouldEqual, CodeInsufficientBalance)
+			})
+		})
+		Convey("Given i create tournament V1 twice", func() {
+			w1 := requestV2(router, "POST", "/v2/tournaments", `{"id": "V1", "deposit": 10, "payout": "top3", "maxEntrants": 4, "gameServerId": "test-server"}`)
+			w2 := requestV2(router, "POST", "/v2/tournaments", `{"id": "V1", "deposit": 10}`)
+			Convey("First should return created tournament and second conflict", func() {
+				So(w1.Code, ShouldEqual, http.StatusCreated)
+				So(w1.Header().Get("Location"), ShouldEqual, "/v2/tournaments/V1")
+				tournament := decodeMap(w1)
+				So(tournament["state"], ShouldEqual, StateAnnounced)
+				So(tournament["deposit"], ShouldEqual, 10)
+				So(tournament["payout"], ShouldEqual, PayoutTop3)
+				So(w2.Code, ShouldEqual, http.StatusConflict)
+			})
+		})
+		Convey("Given i open registration and V1 and V2 backed by V1 with 50% join", func() {
+			requestV2(router, "POST", "/v2/players/V2/deposits", `{"amount": 10}`)
+			w1 := requestV2(router, "PUT", "/v2/tournaments/V1/state", `{"state": "registration_open"}`)
+			w2 := requestV2(router, "POST", "/v2/tournaments/V1/entries", `{"playerId": "V1"}`)
+			w3 := requestV2(router, "POST", "/v2/tournaments/V1/entries", `{"playerId": "V2", "backers": [{"playerId": "V1", "share": 50}]}`)
+			Convey("Entries should be created with their stakes", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(decodeMap(w1)["state"], ShouldEqual, StateRegistrationOpen)
+				So(w2.Code, ShouldEqual, http.StatusCreated)
+				So(w3.Code, ShouldEqual, http.StatusCreated)
+				So(w3.Header().Get("Location"), ShouldEqual, "/v2/tournaments/V1/entries/V2")
+				var entry struct {
+					Stakes []struct {
+						PlayerID string  `json:"playerId"`
+						Amount   float64 `json:"amount"`
+					} `json:"stakes"`
+				}
+				json.NewDecoder(w3.Body).Decode(&entry)
+				So(len(entry.Stakes), ShouldEqual, 2)
+				So(entry.Stakes[1].PlayerID, ShouldEqual, "V1")
+				So(entry.Stakes[1].Amount, ShouldEqual, 5)
+				player, _ := db.FindPlayer("V1")
+				So(player.Balances["EUR"], ShouldEqual, 10000-1000-500)
+			})
+		})
+		Convey("Given V2 entry is deleted twice", func() {
+			w1 := requestV2(router, "DELETE", "/v2/tournaments/V1/entries/V2", "")
+			w2 := requestV2(router, "DELETE", "/v2/tournaments/V1/entries/V2", "")
+			Convey("First should refund and second should not find entry", func() {
+				So(w1.Code, ShouldEqual, http.StatusNoContent)
+				So(w2.Code, ShouldEqual, http.StatusNotFound)
+				So(errorResponse(w2).Code, ShouldEqual, CodeEntryNotFound)
+				player, _ := db.FindPlayer("V1")
+				So(player.Balances["EUR"], ShouldEqual, 10000-1000)
+			})
+		})
+		Convey("Given i try to finish tournament V1 through state change", func() {
+			w := requestV2(router, "PUT", "/v2/tournaments/V1/state", `{"state": "finished"}`)
+			Convey("it should result in unprocessable entity", func() {
+				So(w.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorResponse(w).Field, ShouldEqual, "state")
+			})
+		})
+		Convey("Given tournament V1 is started and its results are posted", func() {
+			requestV2(router, "PUT", "/v2/tournaments/V1/state", `{"state": "registration_closed"}`)
+			requestV2(router, "PUT", "/v2/tournaments/V1/state", `{"state": "running"}`)
+			w1 := postResults(db, "/v2/tournaments/V1/results", `{"placings": ["V1"]}`)
+			w2 := requestV2(router, "GET", "/v2/players/V1", "")
+			w3 := requestV2(router, "GET", "/balance?playerId=V1", "")
+			Convey("Tournament should be finished and prize paid out, legacy routes should keep working", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				tournament := decodeMap(w1)
+				So(tournament["state"], ShouldEqual, StateFinished)
+				So(tournament["prizePool"], ShouldEqual, 10)
+				So(w2.Code, ShouldEqual, http.StatusOK)
+				So(decodeMap(w2)["balances"], ShouldResemble, map[string]interface{}{"EUR": 100.0})
+				So(w3.Code, ShouldEqual, http.StatusOK)
+			})
+		})
+		Convey("Given M1 holds EUR and USD, M2 only USD, and both enter USD tournament M1 which M1 wins", func() {
+			requestV2(router, "POST", "/v2/players/M1/deposits", `{"amount": 10}`)
+			requestV2(router, "POST", "/v2/players/M1/deposits", `{"amount": 6, "currency": "USD"}`)
+			requestV2(router, "POST", "/v2/players/M2/deposits", `{"amount": 6, "currency": "USD"}`)
+			w1 := requestV2(router, "POST", "/v2/tournaments", `{"id": "M1", "deposit": 5, "fee": 1, "currency": "USD", "gameServerId": "test-server"}`)
+			w2 := requestV2(router, "POST", "/v2/tournaments", `{"id": "M2", "deposit": 5, "currency": "GBP"}`)
+			requestV2(router, "PUT", "/v2/tournaments/M1/state", `{"state": "registration_open"}`)
+			requestV2(router, "POST", "/v2/tournaments/M1/entries", `{"playerId": "M1"}`)
+			requestV2(router, "POST", "/v2/tournaments/M1/entries", `{"playerId": "M2"}`)
+			requestV2(router, "PUT", "/v2/tournaments/M1/state", `{"state": "registration_closed"}`)
+			requestV2(router, "PUT", "/v2/tournaments/M1/state", `{"state": "running"}`)
+			postResults(db, "/v2/tournaments/M1/results", `{"placings": ["M1"]}`)
+			w3 := requestV2(router, "GET", "/balance?playerId=M1&currency=USD", "")
+			w4 := requestV2(router, "POST", "/v2/players/M2/withdrawals", `{"amount": 1}`)
+			w5 := requestV2(router, "GET", "/v2/revenue?currency=USD", "")
+			Convey("Only USD balances should be charged and paid out", func() {
+				So(w1.Code, ShouldEqual, http.StatusCreated)
+				So(decodeMap(w1)["currency"], ShouldEqual, "USD")
+				So(w2.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorResponse(w2).Field, ShouldEqual, "currency")
+				m1, _ := db.FindPlayer("M1")
+				m2, _ := db.FindPlayer("M2")
+				So(m1.Balances, ShouldResemble, map[string]int{"EUR": 1000, "USD": 1000})
+				So(m2.Balances, ShouldResemble, map[string]int{"USD": 0})
+				So(w3.Body.String(), ShouldEqual, `{"playerId":"M1","currency":"USD","balance":10.00}`+"\n")
+				So(w4.Code, ShouldEqual, http.StatusBadRequest)
+				So(errorResponse(w4).Code, ShouldEqual, CodeInsufficientBalance)
+				So(decodeMap(w5)["total"], ShouldEqual, 2)
+			})
+		})
+		Convey("Given L1, L2 and L3 start in two, one and three hours and L1 backed by L3 enters L2", func() {
+			startsAt := func(hours int) string {
+				return time.Now().Add(time.Duration(hours) * time.Hour).UTC().Format(time.RFC3339)
+			}
+			requestV2(router, "POST", "/v2/players/L1/deposits", `{"amount": 10}`)
+			requestV2(router, "POST", "/v2/players/L3/deposits", `{"amount": 10}`)
+			requestV2(router, "POST", "/v2/tournaments", `{"id": "L1", "deposit": 10, "startsAt": "`+startsAt(2)+`"}`)
+			requestV2(router, "POST", "/v2/tournaments", `{"id": "L2", "deposit": 10, "startsAt": "`+startsAt(1)+`"}`)
+			requestV2(router, "POST", "/v2/tournaments", `{"id": "L3", "deposit": 10, "startsAt": "`+startsAt(3)+`"}`)
+			requestV2(router, "PUT", "/v2/tournaments/L2/state", `{"state": "registration_open"}`)
+			requestV2(router, "POST", "/v2/tournaments/L2/entries", `{"playerId": "L1", "backers": [{"playerId": "L3", "amount": 2.5}]}`)
+			from := url.QueryEscape(time.Now().Add(30 * time.Minute).UTC().Format(time.RFC3339))
+
+			w1 := requestV2(router, "GET", "/v2/tournaments?limit=2&from="+from, "")
+			page1 := decodeTournamentList(w1)
+			w2 := requestV2(router, "GET", "/v2/tournaments?limit=2&from="+from+"&cursor="+page1.NextCursor, "")
+			page2 := decodeTournamentList(w2)
+			w3 := requestV2(router, "GET", "/v2/tournaments?state=finished", "")
+			w4 := requestV2(router, "GET", "/v2/tournaments/L2/entries", "")
+			w5 := requestV2(router, "GET", "/v2/tournaments/V1/entries", "")
+			Convey("Tournaments should be listed by start time page after page, finished ones read back and entry returned with backer stakes and shares", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(page1.Tournaments, ShouldHaveLength, 2)
+				So(page1.Tournaments[0]["id"], ShouldEqual, "L2")
+				So(page1.Tournaments[0]["entrants"], ShouldEqual, 1)
+				So(page1.Tournaments[0]["prizePool"], ShouldEqual, 10)
+				So(page1.Tournaments[1]["id"], ShouldEqual, "L1")
+				So(page1.NextCursor, ShouldNotBeEmpty)
+				So(w2.Code, ShouldEqual, http.StatusOK)
+				So(page2.Tournaments, ShouldHaveLength, 1)
+				So(page2.Tournaments[0]["id"], ShouldEqual, "L3")
+				So(page2.NextCursor, ShouldBeEmpty)
+				finished := decodeTournamentList(w3)
+				So(finished.Tournaments, ShouldHaveLength, 2)
+				So(finished.Tournaments[0]["id"], ShouldEqual, "V1")
+				So(finished.Tournaments[0]["state"], ShouldEqual, StateFinished)
+				So(finished.Tournaments[1]["id"], ShouldEqual, "M1")
+				So(w5.Code, ShouldEqual, http.StatusOK)
+				So(w5.Body.String(), ShouldEqual, `{"entries":[{"tournamentId":"V1","playerId":"V1","waitlisted":false,"stakes":[{"playerId":"V1","amount":10.00,"share":100}]}]}`+"\n")
+				So(w4.Code, ShouldEqual, http.StatusOK)
+				So(w4.Body.String(), ShouldEqual, `{"entries":[{"tournamentId":"L2","playerId":"L1","waitlisted":false,"stakes":[{"playerId":"L1","amount":7.50,"share":75},{"playerId":"L3","amount":2.50,"share":25}]}]}`+"\n")
+			})
+		})
+		Convey("Given tournaments are listed with unknown state, bad cursor and too large limit and entries of unknown tournament are read", func() {
+			w1 := requestV2(router, "GET", "/v2/tournaments?state=paused", "")
+			w2 := requestV2(router, "GET", "/v2/tournaments?cursor=bm9wZQ", "")
+			w3 := requestV2(router, "GET", "/v2/tournaments?limit=101", "")
+			w4 := requestV2(router, "GET", "/v2/tournaments/L9/entries", "")
+			Convey("It should result in unprocessable entity and not found", func() {
+				So(w1.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorResponse(w1).Field, ShouldEqual, "state")
+				So(w2.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorResponse(w2).Field, ShouldEqual, "cursor")
+				So(w3.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorResponse(w3).Field, ShouldEqual, "limit")
+				So(w4.Code, ShouldEqual, http.StatusNotFound)
+				So(errorResponse(w4).Code, ShouldEqual, CodeTournamentNotFound)
+			})
+		})
+		Convey("Given H1 deposits and withdraws, enters tournament H1 backed by H2 and wins it", func() {
+			requestV2(router, "POST", "/v2/players/H1/deposits", `{"amount": 20}`)
+			requestV2(router, "POST", "/v2/players/H1/withdrawals", `{"amount": 5}`)
+			requestV2(router, "POST", "/v2/players/H2/deposits", `{"amount": 5}`)
+			requestV2(router, "POST", "/v2/players/H1/deposits", `{"amount": 3, "currency": "USD"}`)
+			requestV2(router, "POST", "/v2/tournaments", `{"id": "H1", "deposit": 10, "gameServerId": "test-server"}`)
+			requestV2(router, "PUT", "/v2/tournaments/H1/state", `{"state": "registration_open"}`)
+			requestV2(router, "POST", "/v2/tournaments/H1/entries", `{"playerId": "H1", "backers": [{"playerId": "H2", "share": 40}]}`)
+			requestV2(router, "PUT", "/v2/tournaments/H1/state", `{"state": "registration_closed"}`)
+			requestV2(router, "PUT", "/v2/tournaments/H1/state", `{"state": "running"}`)
+			postResults(db, "/v2/tournaments/H1/results", `{"placings": ["H1"]}`)
+
+			w1 := requestV2(router, "GET", "/v2/players/H1/transactions?currency=EUR&limit=3", "")
+			page1 := decodeTransactionList(w1)
+			w2 := requestV2(router, "GET", "/v2/players/H1/transactions?currency=EUR&limit=3&cursor="+page1.NextCursor, "")
+			page2 := decodeTransactionList(w2)
+			w3 := requestV2(router, "GET", "/v2/players/H2/transactions?type=backing&type=prize", "")
+			w4 := requestV2(router, "GET", "/v2/players/H1/transactions?to="+url.QueryEscape(time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)), "")
+			w5 := requestV2(router, "GET", "/v2/players/H1/transactions?type=bonus", "")
+			w6 := requestV2(router, "GET", "/v2/players/H9/transactions", "")
+			Convey("EUR transactions should be listed newest first with running balance page after page", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(page1.Transactions, ShouldHaveLength, 3)
+				So(page1.Transactions[0]["type"], ShouldEqual, ReasonPrize)
+				So(page1.Transactions[0]["tournamentId"], ShouldEqual, "H1")
+				So(page1.Transactions[0]["amount"], ShouldEqual, 6)
+				So(page1.Transactions[0]["balance"], ShouldEqual, 15)
+				So(page1.Transactions[1]["type"], ShouldEqual, ReasonEntry)
+				So(page1.Transactions[1]["amount"], ShouldEqual, -6)
+				So(page1.Transactions[1]["balance"], ShouldEqual, 9)
+				So(page1.Transactions[2]["type"], ShouldEqual, ReasonTake)
+				So(page1.Transactions[2]["currency"], ShouldEqual, "EUR")
+				So(page1.Transactions[2]["tournamentId"], ShouldBeNil)
+				So(page1.NextCursor, ShouldNotBeEmpty)
+				So(page2.Transactions, ShouldHaveLength, 1)
+				So(page2.Transactions[0]["type"], ShouldEqual, ReasonFund)
+				So(page2.Transactions[0]["balance"], ShouldEqual, 20)
+				So(page2.NextCursor, ShouldBeEmpty)
+			})
+			Convey("Transactions should be filtered by type and date", func() {
+				backer := decodeTransactionList(w3)
+				So(backer.Transactions, ShouldHaveLength, 2)
+				So(backer.Transactions[0]["type"], ShouldEqual, ReasonPrize)
+				So(backer.Transactions[0]["amount"], ShouldEqual, 4)
+				So(backer.Transactions[1]["type"], ShouldEqual, ReasonBacking)
+				So(backer.Transactions[1]["amount"], ShouldEqual, -4)
+				So(decodeTransactionList(w4).Transactions, ShouldBeEmpty)
+			})
+			Convey("Unknown type and player should result in unprocessable entity and not found", func() {
+				So(w5.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorResponse(w5).Field, ShouldEqual, "type")
+				So(w6.Code, ShouldEqual, http.StatusNotFound)
+				So(errorResponse(w6).Code, ShouldEqual, CodePlayerNotFound)
+			})
+		})
+		Convey("Given reset is requested while admin endpoints are disabled", func() {
+			w := requestV2(router, "POST", "/v2/reset", "")
+			Convey("It should not be registered", func() {
+				So(w.Code, ShouldNotEqual, http.StatusNoContent)
+				_, err := db.FindPlayer("V1")
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func requestV2(router http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(apiKeyHeader, testOperatorKey)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func decodeMap(w *httptest.ResponseRecorder) map[string]interface{} {
+	var response map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&response)
+	return response
+}
+
+type tournamentList struct {
+	Tournaments []map[string]interface{} `json:"tournaments"`
+	NextCursor  string                   `json:"nextCursor"`
+}
+
+func decodeTournamentList(w *httptest.ResponseRecorder) tournamentList {
+	var list tournamentList
+	json.NewDecoder(w.Body).Decode(&list)
+	return list
+}
+
+type transactionList struct {
+	Transactions []map[string]interface{} `json:"transactions"`
+	NextCursor   string                   `json:"nextCursor"`
+}
+
+func decodeTransactionList(w *httptest.ResponseRecorder) transactionList {
+	var list transactionList
+	json.NewDecoder(w.Body).Decode(&list)
+	return list
+}
@@ -1,6 +1,13 @@
 package main
 
-import "strconv"
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
 
 func splitEvenly(amount, parts int) []int {
 	var result []int
@@ -15,11 +22,212 @@ func splitEvenly(amount, parts int) []int {
 	return result
 }
 
-func getPointsFromString(input string) (int, error) {
-	points, err := strconv.ParseFloat(input, 64)
+//splitByStakes divides amount proportionally to stake amounts, cents left after rounding down go one by one to stakes with largest remainders, earlier stakes first
+func splitByStakes(amount int, stakes []Stake) []int {
+	total := 0
+	for _, v := range stakes {
+		total += v.Amount
+	}
+	if total == 0 {
+		return splitEvenly(amount, len(stakes))
+	}
+
+	result := make([]int, len(stakes))
+	remainders := make([]int, len(stakes))
+	order := make([]int, len(stakes))
+	distributed := 0
+	for i, v := range stakes {
+		result[i] = amount * v.Amount / total
+		remainders[i] = amount * v.Amount % total
+		order[i] = i
+		distributed += result[i]
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for i := 0; i < amount-distributed; i++ {
+		result[order[i]]++
+	}
+	return result
+}
+
+//buildStakes splits entry cost between player and backers, evenly by default, or by backer percentages or backer amounts, in which case player stakes the remainder
+func buildStakes(cost int, playerID string, backers []string, percentages []string, amounts []string) (Stake, []Stake, error) {
+	player := Stake{PlayerID: playerID}
+	if len(percentages) > 0 && len(amounts) > 0 {
+		return player, nil, errors.New("backer stakes can be given either as percentages or as amounts")
+	}
+	if len(percentages) == 0 && len(amounts) == 0 {
+		parts := splitEvenly(cost, len(backers)+1)
+		player.Amount = parts[0]
+		var stakes []Stake
+		for i, v := range backers {
+			stakes = append(stakes, Stake{PlayerID: v, Amount: parts[i+1]})
+		}
+		return player, stakes, nil
+	}
+
+	values := amounts
+	if len(percentages) > 0 {
+		values = percentages
+	}
+	if len(values) != len(backers) {
+		return player, nil, errors.New("every backer needs its own stake")
+	}
+	var stakes []Stake
+	remainder := cost
+	for i, v := range values {
+		parsed, err := ParseMoney(v)
+		value := int(parsed)
+		if err != nil || value <= 0 {
+			return player, nil, errors.New("backer stake must be positive number")
+		}
+		if len(percentages) > 0 {
+			//percentage with two decimals parsed as points is in hundredths of percent
+			if value > 10000 {
+				return player, nil, errors.New("backer percentage can not exceed 100")
+			}
+			value = cost * value / 10000
+		}
+		stakes = append(stakes, Stake{PlayerID: backers[i], Amount: value})
+		remainder -= value
+	}
+	if remainder < 0 {
+		return player, nil, errors.New("backer stakes exceed entry cost")
+	}
+	player.Amount = remainder
+	return player, stakes, nil
+}
+
+//splitPlayerStake separates player own stake from stakes of its backers
+func splitPlayerStake(playerID string, stakes []Stake) (Stake, []Stake) {
+	player := Stake{PlayerID: playerID}
+	var backers []Stake
+	for _, v := range stakes {
+		if v.PlayerID == playerID {
+			player = v
+			continue
+		}
+		backers = append(backers, v)
+	}
+	return player, backers
+}
+
+//validateStakes checks that backers stake positive amounts, are not the player, repeated or house account, and that all stakes add up to entry cost
+func validateStakes(cost int, player Stake, backers []Stake) error {
+	if player.Amount < 0 || player.PlayerID == HouseAccountID {
+		return ErrInvalidStakes
+	}
+	total := player.Amount
+	seen := map[string]bool{player.PlayerID: true, HouseAccountID: true}
+	for _, v := range backers {
+		if v.Amount <= 0 || seen[v.PlayerID] {
+			return ErrInvalidStakes
+		}
+		seen[v.PlayerID] = true
+		total += v.Amount
+	}
+	if total != cost {
+		return ErrInvalidStakes
+	}
+	return nil
+}
+
+//getOptionalCount parses non-negative whole number, empty input is 0
+func getOptionalCount(input string) (int, error) {
+	if input == "" {
+		return 0, nil
+	}
+	count, err := strconv.Atoi(input)
+	if err != nil {
+		return 0, err
+	}
+	if count < 0 {
+		return 0, errors.New("count can not be negative")
+	}
+	return count, nil
+}
+
+//getOptionalTime parses RFC3339 time, empty input is nil
+func getOptionalTime(input string) (*time.Time, error) {
+	if input == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, input)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+//getPageSize parses number of items listed on one page, empty input is default page size
+func getPageSize(input string) (int, error) {
+	if input == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(input)
+	if err != nil {
+		return 0, err
+	}
+	if limit < 1 || limit > maxPageSize {
+		return 0, errors.New("limit is out of range")
+	}
+	return limit, nil
+}
+
+//getOptionalMoney parses money, empty input is 0
+func getOptionalMoney(input string) (Money, error) {
+	if input == "" {
+		return 0, nil
+	}
+	return ParseMoney(input)
+}
+
+//encodeCursor encodes tournament listing cursor as opaque url safe string
+func encodeCursor(cursor TournamentCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor.At.UTC().Format(time.RFC3339Nano) + "|" + cursor.ID))
+}
+
+//getOptionalCursor decodes tournament listing cursor, empty input is no cursor
+func getOptionalCursor(input string) (*TournamentCursor, error) {
+	if input == "" {
+		return nil, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(input)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(string(decoded), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, errors.New("cursor must hold time and tournament id")
+	}
+	at, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, err
+	}
+	return &TournamentCursor{At: at, ID: parts[1]}, nil
+}
+
+//encodeIDCursor encodes id of last row on page listed newest first, such as transaction history, as opaque url safe string
+func encodeIDCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+//getOptionalIDCursor decodes cursor of page listed newest first into id of last row on previous page, empty input is 0
+func getOptionalIDCursor(input string) (int, error) {
+	if input == "" {
+		return 0, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(input)
 	if err != nil {
 		return 0, err
 	}
-	points *= 100
-	return int(points), nil
+	id, err := strconv.Atoi(string(decoded))
+	if err != nil {
+		return 0, err
+	}
+	if id <= 0 {
+		return 0, errors.New("cursor must hold positive id")
+	}
+	return id, nil
 }
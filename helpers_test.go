@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSplitByStakes(t *testing.T) {
+	Convey("Given prize split between stakes", t, func() {
+		Convey("Even stakes should split like splitEvenly", func() {
+			So(splitByStakes(10000, []Stake{{"P1", 1667}, {"P2", 1667}, {"P3", 1666}}), ShouldResemble, []int{3334, 3334, 3332})
+			So(splitByStakes(100, []Stake{{"P1", 1}, {"P2", 1}, {"P3", 1}}), ShouldResemble, []int{34, 33, 33})
+		})
+		Convey("Uneven stakes should split proportionally with leftover cents going to largest remainders", func() {
+			So(splitByStakes(1000, []Stake{{"P1", 15}, {"P2", 60}, {"P3", 25}}), ShouldResemble, []int{150, 600, 250})
+			So(splitByStakes(101, []Stake{{"P1", 1}, {"P2", 2}}), ShouldResemble, []int{34, 67})
+		})
+		Convey("Player without stake should get nothing", func() {
+			So(splitByStakes(999, []Stake{{"P1", 0}, {"P2", 500}}), ShouldResemble, []int{0, 999})
+		})
+	})
+}
+
+func TestBuildStakes(t *testing.T) {
+	Convey("Given entry cost of 10.00", t, func() {
+		Convey("Without shares it should be split evenly", func() {
+			player, backers, err := buildStakes(1000, "P1", []string{"P2", "P3"}, nil, nil)
+			So(err, ShouldBeNil)
+			So(player, ShouldResemble, Stake{"P1", 334})
+			So(backers, ShouldResemble, []Stake{{"P2", 333}, {"P3", 333}})
+		})
+		Convey("With percentages player should stake the rest", func() {
+			player, backers, err := buildStakes(1000, "P1", []string{"P2", "P3"}, []string{"33.33", "50"}, nil)
+			So(err, ShouldBeNil)
+			So(player, ShouldResemble, Stake{"P1", 1000 - 333 - 500})
+			So(backers, ShouldResemble, []Stake{{"P2", 333}, {"P3", 500}})
+		})
+		Convey("Backer stakes covering whole entry cost should leave player without stake", func() {
+			player, _, err := buildStakes(1000, "P1", []string{"P2"}, nil, []string{"10"})
+			So(err, ShouldBeNil)
+			So(player.Amount, ShouldEqual, 0)
+		})
+		Convey("Zero or negative backer stakes should be rejected", func() {
+			_, _, err1 := buildStakes(1000, "P1", []string{"P2"}, []string{"0"}, nil)
+			_, _, err2 := buildStakes(1000, "P1", []string{"P2"}, nil, []string{"-1"})
+			So(err1, ShouldNotBeNil)
+			So(err2, ShouldNotBeNil)
+		})
+	})
+	Convey("Given deposit of 10.00 with fee of 1.00 on top", t, func() {
+		tournament := &Tournament{Deposit: 1000, Fee: 100}
+		Convey("Percentages should be of entry cost including fee", func() {
+			player, backers, err := buildStakes(tournament.entryCost(), "P1", []string{"P2"}, []string{"50"}, nil)
+			So(err, ShouldBeNil)
+			So(player, ShouldResemble, Stake{"P1", 550})
+			So(backers, ShouldResemble, []Stake{{"P2", 550}})
+			So(validateStakes(tournament.entryCost(), player, backers), ShouldBeNil)
+		})
+	})
+	Convey("Given stakes where backer is the player or repeated", t, func() {
+		Convey("They should not be valid", func() {
+			So(validateStakes(1000, Stake{"P1", 500}, []Stake{{"P1", 500}}), ShouldEqual, ErrInvalidStakes)
+			So(validateStakes(1000, Stake{"P1", 0}, []Stake{{"P2", 500}, {"P2", 500}}), ShouldEqual, ErrInvalidStakes)
+		})
+	})
+}
+
+func TestPayoutPrizes(t *testing.T) {
+	Convey("Given prize pool of 100.00", t, func() {
+		Convey("Winner should take all", func() {
+			prizes, err := payoutPrizes(PayoutWinnerTakesAll, 10000, 5, []string{"P1", "P2"})
+			So(err, ShouldBeNil)
+			So(prizes, ShouldResemble, []Prize{{"P1", 10000}})
+		})
+		Convey("Top 3 should be paid 50/30/20 and scaled up when there are only 2 entrants", func() {
+			prizes, err := payoutPrizes(PayoutTop3, 10000, 10, []string{"P1", "P2", "P3", "P4"})
+			So(err, ShouldBeNil)
+			So(prizes, ShouldResemble, []Prize{{"P1", 5000}, {"P2", 3000}, {"P3", 2000}})
+			prizes, err = payoutPrizes(PayoutTop3, 10000, 2, []string{"P1", "P2"})
+			So(err, ShouldBeNil)
+			So(prizes, ShouldResemble, []Prize{{"P1", 6250}, {"P2", 3750}})
+		})
+		Convey("Top 15% should pay places by sliding table", func() {
+			So(payoutPercentages(PayoutTop15Percent, 6), ShouldResemble, []int{100})
+			So(payoutPercentages(PayoutTop15Percent, 13), ShouldResemble, []int{65, 35})
+			So(payoutPercentages(PayoutTop15Percent, 26), ShouldResemble, []int{40, 27, 19, 14})
+			So(len(payoutPercentages(PayoutTop15Percent, 1000)), ShouldEqual, len(slidingPayout))
+			for _, v := range slidingPayout {
+				total := 0
+				for _, percentage := range v {
+					total += percentage
+				}
+				So(total, ShouldEqual, 100)
+			}
+		})
+		Convey("Duplicate placings or placings not covering paid places should be rejected", func() {
+			_, err1 := payoutPrizes(PayoutTop3, 10000, 3, []string{"P1", "P2", "P1"})
+			_, err2 := payoutPrizes(PayoutTop3, 10000, 3, []string{"P1", "P2"})
+			So(err1, ShouldEqual, ErrInvalidPlacings)
+			So(err2, ShouldEqual, ErrInvalidPlacings)
+		})
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+)
+
+//Leaderboard sorts rank players by one of their statistics, best first
+const (
+	SortWinnings    = "winnings"
+	SortProfit      = "profit"
+	SortROI         = "roi"
+	SortITM         = "itm"
+	SortTournaments = "tournaments"
+)
+
+//leaderboardOrder is sql ordering of player statistics for each sort, players who were not charged anything are ranked last by roi
+var leaderboardOrder = map[string]string{
+	SortWinnings:    "winnings DESC",
+	SortProfit:      "winnings - cost DESC",
+	SortROI:         "(winnings - cost)::float / NULLIF(cost, 0) DESC NULLS LAST",
+	SortITM:         "in_the_money::float / tournaments DESC",
+	SortTournaments: "tournaments DESC",
+}
+
+//PlayerStatistics sums results of player own entries, or of its backing stakes, in finished tournaments of single currency
+type PlayerStatistics struct {
+	PlayerID    string `db:"player_id"`
+	Tournaments int    `db:"tournaments"`
+	InTheMoney  int    `db:"in_the_money"`
+	Cost        int    `db:"cost"`
+	Winnings    int    `db:"winnings"`
+}
+
+//MarshalJSON is custom json marshaler to present amounts in points with return on investment and in the money percentages,
+//roi is left out when player was not charged anything
+func (s *PlayerStatistics) MarshalJSON() ([]byte, error) {
+	var roi *float64
+	if s.Cost > 0 {
+		value := percent(s.Winnings-s.Cost, s.Cost)
+		roi = &value
+	}
+	itm := 0.0
+	if s.Tournaments > 0 {
+		itm = percent(s.InTheMoney, s.Tournaments)
+	}
+	return json.Marshal(&struct {
+		PlayerID    string   `json:"playerId"`
+		Tournaments int      `json:"tournaments"`
+		InTheMoney  int      `json:"inTheMoney"`
+		ITM         float64  `json:"itm"`
+		Cost        Money    `json:"cost"`
+		Winnings    Money    `json:"winnings"`
+		Profit      Money    `json:"profit"`
+		ROI         *float64 `json:"roi,omitempty"`
+	}{
+		PlayerID:    s.PlayerID,
+		Tournaments: s.Tournaments,
+		InTheMoney:  s.InTheMoney,
+		ITM:         itm,
+		Cost:        Money(s.Cost),
+		Winnings:    Money(s.Winnings),
+		Profit:      Money(s.Winnings - s.Cost),
+		ROI:         roi,
+	})
+}
+
+//rankValue returns statistic player is ranked by for sort, same as leaderboardOrder does in sql
+func (s *PlayerStatistics) rankValue(sort string) float64 {
+	switch sort {
+	case SortProfit:
+		return float64(s.Winnings - s.Cost)
+	case SortROI:
+		if s.Cost == 0 {
+			return math.Inf(-1)
+		}
+		return float64(s.Winnings-s.Cost) / float64(s.Cost)
+	case SortITM:
+		return float64(s.InTheMoney) / float64(s.Tournaments)
+	case SortTournaments:
+		return float64(s.Tournaments)
+	default:
+		return float64(s.Winnings)
+	}
+}
+
+//rankedBefore tells if player with statistics a is ranked before b by sort, ties are ordered by player id
+func rankedBefore(a *PlayerStatistics, b *PlayerStatistics, sort string) bool {
+	if va, vb := a.rankValue(sort), b.rankValue(sort); va != vb {
+		return va > vb
+	}
+	return a.PlayerID < b.PlayerID
+}
+
+//percent returns part of whole in percent rounded to two decimal places
+func percent(part int, whole int) float64 {
+	return math.Floor(float64(part)*10000/float64(whole)+0.5) / 100
+}
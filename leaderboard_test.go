@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLeaderboards(t *testing.T) {
+	db := newTestStore(t)
+	db.ResetDatabase()
+	defer db.ResetDatabase()
+	router := newRouter(&Handlers{repo: db, config: defaultConfig()})
+	createTestAPIKey(db, testOperatorKey, ScopeOperator, "")
+	createTestGameServer(db)
+	createTestAPIKey(db, "test-player-key-B1", ScopePlayer, "B1")
+
+	operator := loadSpecChecker(t, router, testOperatorKey)
+	player := loadSpecChecker(t, router, "test-player-key-B1")
+
+	Convey("Given results of finished tournaments are kept", t, func() {
+		Convey("Given B1 backed by B3 with 50% wins tournament R1 against B2 and B2 wins tournament R2 against B1", func() {
+			for _, v := range []string{"B1", "B2", "B3"} {
+				operator.call("POST", "/v2/players/"+v+"/deposits", `{"amount": 100}`)
+			}
+			operator.call("POST", "/v2/tournaments", `{"id": "R1", "deposit": 10, "payout": "top3", "gameServerId": "test-server"}`)
+			operator.call("POST", "/v2/tournaments", `{"id": "R2", "deposit": 10, "gameServerId": "test-server"}`)
+			for _, v := range []string{"R1", "R2"} {
+				operator.call("PUT", "/v2/tournaments/"+v+"/state", `{"state": "registration_open"}`)
+			}
+			operator.call("POST", "/v2/tournaments/R1/entries", `{"playerId": "B1", "backers": [{"playerId": "B3", "share": 50}]}`)
+			operator.call("POST", "/v2/tournaments/R1/entries", `{"playerId": "B2"}`)
+			operator.call("POST", "/v2/tournaments/R2/entries", `{"playerId": "B1"}`)
+			operator.call("POST", "/v2/tournaments/R2/entries", `{"playerId": "B2"}`)
+			for _, v := range []string{"R1", "R2"} {
+				operator.call("PUT", "/v2/tournaments/"+v+"/state", `{"state": "registration_closed"}`)
+				operator.call("PUT", "/v2/tournaments/"+v+"/state", `{"state": "running"}`)
+			}
+			postResults(db, "/v2/tournaments/R1/results", `{"placings": ["B1", "B2"]}`)
+			w := postResults(db, "/v2/tournaments/R2/results", `{"winners": [{"playerId": "B2", "prize": 20}]}`)
+			Convey("Both tournaments should be finished", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(operator.errors, ShouldBeEmpty)
+			})
+		})
+		Convey("Given results of R1 and R2 are read", func() {
+			w1 := operator.call("GET", "/v2/tournaments/R1/results", "")
+			w2 := operator.call("GET", "/v2/tournaments/R2/results", "")
+			Convey("Positions and prizes of every stake should be stored", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(w1.Body.String(), ShouldEqual, `{"results":[`+
+					`{"playerId":"B1","position":1,"cost":5.00,"prize":6.25},`+
+					`{"playerId":"B3","backedPlayerId":"B1","position":1,"cost":5.00,"prize":6.25},`+
+					`{"playerId":"B2","position":2,"cost":10.00,"prize":7.50}]}`+"\n")
+				So(w2.Body.String(), ShouldEqual, `{"results":[`+
+					`{"playerId":"B2","position":1,"cost":10.00,"prize":20.00},`+
+					`{"playerId":"B1","cost":10.00,"prize":0.00}]}`+"\n")
+				So(operator.errors, ShouldBeEmpty)
+			})
+		})
+		Convey("Given player and backer leaderboards are requested", func() {
+			w1 := player.call("GET", "/v2/leaderboards/players", "")
+			w2 := player.call("GET", "/v2/leaderboards/players?sort=roi&limit=1", "")
+			w3 := player.call("GET", "/v2/leaderboards/backers", "")
+			w4 := player.call("GET", "/v2/leaderboards/players?to="+url.QueryEscape(time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)), "")
+			w5 := player.call("GET", "/v2/leaderboards/players?currency=USD", "")
+			w6 := player.call("GET", "/v2/leaderboards/players?sort=luck", "")
+			Convey("Players should be ranked by their own results and backers by their returns", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(w1.Body.String(), ShouldEqual, `{"currency":"EUR","sort":"winnings","players":[`+
+					`{"playerId":"B2","tournaments":2,"inTheMoney":2,"itm":100,"cost":20.00,"winnings":27.50,"profit":7.50,"roi":37.5},`+
+					`{"playerId":"B1","tournaments":2,"inTheMoney":1,"itm":50,"cost":15.00,"winnings":6.25,"profit":-8.75,"roi":-58.33}]}`+"\n")
+				So(w2.Body.String(), ShouldEqual, `{"currency":"EUR","sort":"roi","players":[`+
+					`{"playerId":"B2","tournaments":2,"inTheMoney":2,"itm":100,"cost":20.00,"winnings":27.50,"profit":7.50,"roi":37.5}]}`+"\n")
+				So(w3.Body.String(), ShouldEqual, `{"currency":"EUR","sort":"winnings","players":[`+
+					`{"playerId":"B3","tournaments":1,"inTheMoney":1,"itm":100,"cost":5.00,"winnings":6.25,"profit":1.25,"roi":25}]}`+"\n")
+				So(decodeMap(w4)["players"], ShouldBeEmpty)
+				So(decodeMap(w5)["players"], ShouldBeEmpty)
+				So(w6.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorResponse(w6).Field, ShouldEqual, "sort")
+				So(player.errors, ShouldBeEmpty)
+			})
+		})
+		Convey("Given B1 reads its own statistics and statistics of B3", func() {
+			w1 := player.call("GET", "/v2/players/B1/statistics", "")
+			w2 := player.call("GET", "/v2/players/B3/statistics", "")
+			w3 := operator.call("GET", "/v2/players/B3/statistics", "")
+			Convey("It should only see its own results as player and backer", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(w1.Body.String(), ShouldEqual, `{"playerId":"B1","currency":"EUR",`+
+					`"player":{"playerId":"B1","tournaments":2,"inTheMoney":1,"itm":50,"cost":15.00,"winnings":6.25,"profit":-8.75,"roi":-58.33},`+
+					`"backer":{"playerId":"B1","tournaments":0,"inTheMoney":0,"itm":0,"cost":0.00,"winnings":0.00,"profit":0.00}}`+"\n")
+				So(w2.Code, ShouldEqual, http.StatusForbidden)
+				So(decodeMap(w3)["backer"], ShouldResemble, map[string]interface{}{
+					"playerId": "B3", "tournaments": 1.0, "inTheMoney": 1.0, "itm": 100.0, "cost": 5.0, "winnings": 6.25, "profit": 1.25, "roi": 25.0,
+				})
+				So(player.errors, ShouldBeEmpty)
+			})
+		})
+	})
+}
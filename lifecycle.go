@@ -0,0 +1,29 @@
+package main
+
+//Tournament states, tournament is created as announced and ends either finished or cancelled
+const (
+	StateAnnounced          = "announced"
+	StateRegistrationOpen   = "registration_open"
+	StateRegistrationClosed = "registration_closed"
+	StateRunning            = "running"
+	StateFinished           = "finished"
+	StateCancelled          = "cancelled"
+)
+
+//transitions lists states tournament can move to from each state, finished and cancelled are final
+var transitions = map[string][]string{
+	StateAnnounced:          {StateRegistrationOpen, StateCancelled},
+	StateRegistrationOpen:   {StateRegistrationClosed, StateCancelled},
+	StateRegistrationClosed: {StateRegistrationOpen, StateRunning, StateCancelled},
+	StateRunning:            {StateFinished, StateCancelled},
+}
+
+//canTransition tells if tournament in state from is allowed to move to state to
+func canTransition(from string, to string) bool {
+	for _, v := range transitions[from] {
+		if v == to {
+			return true
+		}
+	}
+	return false
+}
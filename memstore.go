@@ -0,0 +1,961 @@
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+//MemoryStore is in-memory Datastore implementation with same semantics as DB, used for tests and local development
+type MemoryStore struct {
+	mu          sync.Mutex
+	players     map[string]*Player
+	tournaments map[string]*Tournament
+	entries     []memoryEntry
+	waitlist    []memoryEntry
+	results     []TournamentResult
+	ledger      []LedgerEntry
+	idempotency map[string]*IdempotentResponse
+	apiKeys     map[string]APIKey
+	gameServers map[string]GameServer
+	nonces      map[string]time.Time
+	webhooks    map[string]Webhook
+	deliveries  []WebhookDelivery
+	deliveryID  int
+}
+
+//memoryEntry represents tournament_entries row, backingID is empty for player own entry and amount is what user was charged
+type memoryEntry struct {
+	tournamentID string
+	userID       string
+	backingID    string
+	amount       int
+	fee          int
+}
+
+//NewMemoryStore creates new empty in-memory datastore
+func NewMemoryStore() *MemoryStore {
+	m := &MemoryStore{apiKeys: make(map[string]APIKey), gameServers: make(map[string]GameServer)}
+	m.ResetDatabase()
+	return m
+}
+
+//FindPlayer returns player by its id with balances in every currency it holds
+func (m *MemoryStore) FindPlayer(playerID string) (*Player, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	player, ok := m.players[playerID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyPlayer(player), nil
+}
+
+//copyPlayer returns copy of stored player which can be changed by caller
+func copyPlayer(player *Player) *Player {
+	found := &Player{ID: player.ID, Balances: make(map[string]int, len(player.Balances))}
+	for currency, v := range player.Balances {
+		found.Balances[currency] = v
+	}
+	return found
+}
+
+//FindOrCreatePlayer returns player by its id or creates new one if not found
+func (m *MemoryStore) FindOrCreatePlayer(playerID string) (*Player, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	player, ok := m.players[playerID]
+	if !ok {
+		player = &Player{ID: playerID, Balances: make(map[string]int)}
+		m.players[playerID] = player
+	}
+	return copyPlayer(player), nil
+}
+
+//TakeFunds takes player and deducts given points away from its balance in currency, queueing funds taken event with the change
+func (m *MemoryStore) TakeFunds(player *Player, currency string, points int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.applyBalanceChanges([]balanceChange{{player.ID, -points, ReasonTake}}, currency, ""); err != nil {
+		return err
+	}
+	return m.enqueueFundsEvent(EventFundsTaken, player.ID, currency, points)
+}
+
+//AddFunds takes player and adds given points to its balance in currency, queueing funds added event with the change
+func (m *MemoryStore) AddFunds(player *Player, currency string, points int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.applyBalanceChanges([]balanceChange{{player.ID, points, ReasonFund}}, currency, ""); err != nil {
+		return err
+	}
+	return m.enqueueFundsEvent(EventFundsAdded, player.ID, currency, points)
+}
+
+//enqueueFundsEvent queues funds event of points added to or taken from player with its balance in currency after the change
+func (m *MemoryStore) enqueueFundsEvent(eventType string, playerID string, currency string, points int) error {
+	balance := m.players[playerID].Balances[currency]
+	return m.enqueueEvent(eventType, &FundsResponse{PlayerID: playerID, Currency: currency, Amount: Money(points), Balance: Money(balance)})
+}
+
+//CreateTournament creates new tournament entry with it's currency, deposit, entrant limits, payout structure and schedule, queueing tournament announced event with it
+func (m *MemoryStore) CreateTournament(tournament *Tournament) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tournaments[tournament.ID]; ok {
+		return ErrAlreadyExists
+	}
+	if tournament.Payout == "" {
+		tournament.Payout = PayoutWinnerTakesAll
+	}
+	tournament.State = StateAnnounced
+	tournament.PrizePool = 0
+	tournament.Entrants = 0
+	tournament.CreatedAt = time.Now()
+	stored := *tournament
+	m.tournaments[tournament.ID] = &stored
+	return m.enqueueEvent(EventTournamentAnnounced, tournament)
+}
+
+//FindTournament returns tournament in any state or error
+func (m *MemoryStore) FindTournament(tournamentID string) (*Tournament, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tournament, ok := m.tournaments[tournamentID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	found := m.copyTournament(tournament)
+	return &found, nil
+}
+
+//copyTournament returns copy of stored tournament with its entrants counted
+func (m *MemoryStore) copyTournament(tournament *Tournament) Tournament {
+	found := *tournament
+	found.Entrants = m.countEntrants(tournament.ID)
+	return found
+}
+
+//ListTournaments returns page of tournaments matching filter ordered by listing time and id
+func (m *MemoryStore) ListTournaments(filter TournamentFilter) ([]Tournament, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var tournaments []Tournament
+	for _, v := range m.tournaments {
+		at := v.listedAt()
+		if filter.State != "" && v.State != filter.State {
+			continue
+		}
+		if (filter.From != nil && at.Before(*filter.From)) || (filter.To != nil && !at.Before(*filter.To)) {
+			continue
+		}
+		if filter.After != nil && (at.Before(filter.After.At) || (at.Equal(filter.After.At) && v.ID <= filter.After.ID)) {
+			continue
+		}
+		tournaments = append(tournaments, m.copyTournament(v))
+	}
+	sort.Slice(tournaments, func(i, j int) bool {
+		a, b := tournaments[i].listedAt(), tournaments[j].listedAt()
+		if a.Equal(b) {
+			return tournaments[i].ID < tournaments[j].ID
+		}
+		return a.Before(b)
+	})
+	if len(tournaments) > filter.Limit {
+		tournaments = tournaments[:filter.Limit]
+	}
+	return tournaments, nil
+}
+
+//TournamentEntries returns entries of tournament with stakes of player and its backers, followed by waitlisted ones, in order they joined
+func (m *MemoryStore) TournamentEntries(tournamentID string) ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var rows []entryRow
+	for _, v := range m.entries {
+		if v.tournamentID == tournamentID {
+			rows = append(rows, entryRow{v.userID, v.backingID, v.amount, false})
+		}
+	}
+	for _, v := range m.waitlist {
+		if v.tournamentID == tournamentID {
+			rows = append(rows, entryRow{v.userID, v.backingID, v.amount, true})
+		}
+	}
+	return groupEntries(tournamentID, rows), nil
+}
+
+//ScheduledTournaments returns tournaments whose registration deadline or start time has passed and scheduler has to act on them
+func (m *MemoryStore) ScheduledTournaments(now time.Time) ([]Tournament, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var tournaments []Tournament
+	for _, v := range m.tournaments {
+		if v.scheduledAt(now) {
+			tournaments = append(tournaments, m.copyTournament(v))
+		}
+	}
+	sort.Slice(tournaments, func(i, j int) bool {
+		return tournaments[i].ID < tournaments[j].ID
+	})
+	return tournaments, nil
+}
+
+//TransitionTournament moves tournament to given state if it is legal transition from its current state
+func (m *MemoryStore) TransitionTournament(tournament *Tournament, state string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.tournaments[tournament.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if stored.State != tournament.State || !canTransition(stored.State, state) {
+		return ErrInvalidTransition
+	}
+	if state == StateRunning && m.countEntrants(tournament.ID) < stored.MinEntrants {
+		return ErrNotEnoughEntrants
+	}
+	stored.State = state
+	tournament.State = state
+	return nil
+}
+
+//lockedTournamentInState returns stored tournament if it is in given state
+func (m *MemoryStore) lockedTournamentInState(tournamentID string, state string) (*Tournament, error) {
+	stored, ok := m.tournaments[tournamentID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if stored.State != state {
+		return nil, ErrInvalidState
+	}
+	return stored, nil
+}
+
+//TournamentJoinPlayers takes tournament and takes each stake amount from balances of player and its backers in tournament currency and adds them to tournament entries,
+//when tournament is full they are put on waitlist without being charged and true is returned, player already entered results in ErrAlreadyExists,
+//entry accepted event is queued with accepted entry
+func (m *MemoryStore) TournamentJoinPlayers(tournament *Tournament, player Stake, backers []Stake) (bool, error) {
+	if err := validateStakes(tournament.entryCost(), player, backers); err != nil {
+		return false, err
+	}
+	if tournament.registrationPassed(time.Now()) {
+		return false, ErrDeadlinePassed
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, err := m.lockedTournamentInState(tournament.ID, StateRegistrationOpen)
+	if err != nil {
+		return false, err
+	}
+	if m.findEntrants(tournament.ID)[player.PlayerID] {
+		return false, ErrAlreadyExists
+	}
+	if stored.MaxEntrants > 0 && m.countEntrants(tournament.ID) >= stored.MaxEntrants {
+		for _, v := range m.waitlist {
+			if v.tournamentID == tournament.ID && v.userID == player.PlayerID && v.backingID == "" {
+				return false, ErrAlreadyExists
+			}
+		}
+		for _, v := range append([]Stake{player}, backers...) {
+			if _, ok := m.players[v.PlayerID]; !ok {
+				return false, ErrPlayerNotFound
+			}
+		}
+		m.waitlist = append(m.waitlist, stakeEntries(tournament.ID, player, backers)...)
+		return true, nil
+	}
+	if err := m.addEntries(tournament.ID, player, backers); err != nil {
+		return false, err
+	}
+	return false, m.enqueueEvent(EventEntryAccepted, newEntry(tournament.ID, player, backers, false))
+}
+
+//addEntries charges player and backers their stakes in tournament currency, adds them to tournament entries, credits entry fee to house and adds the rest to prize pool
+func (m *MemoryStore) addEntries(tournamentID string, player Stake, backers []Stake) error {
+	stored := m.tournaments[tournamentID]
+	changes := []balanceChange{{player.PlayerID, -player.Amount, ReasonEntry}}
+	collected := player.Amount
+	for _, v := range backers {
+		changes = append(changes, balanceChange{v.PlayerID, -v.Amount, ReasonBacking})
+		collected += v.Amount
+	}
+	changes = append(changes, m.houseChanges(stored.Fee)...)
+	if err := m.applyBalanceChanges(changes, stored.Currency, tournamentID); err != nil {
+		return err
+	}
+	entries := stakeEntries(tournamentID, player, backers)
+	entries[0].fee = stored.Fee
+	m.entries = append(m.entries, entries...)
+	stored.PrizePool += collected - stored.Fee
+	return nil
+}
+
+//houseChanges returns balance change crediting signed entry fee amount to house account, creating the account when it does not exist yet
+func (m *MemoryStore) houseChanges(amount int) []balanceChange {
+	if amount == 0 {
+		return nil
+	}
+	if _, ok := m.players[HouseAccountID]; !ok {
+		m.players[HouseAccountID] = &Player{ID: HouseAccountID, Balances: make(map[string]int)}
+	}
+	return []balanceChange{{HouseAccountID, amount, ReasonFee}}
+}
+
+//takeBackFees returns balance change taking entry fees recorded on refunded entries back from house account,
+//house which has paid them out already results in ErrHouseBalance
+func (m *MemoryStore) takeBackFees(fees int, currency string) ([]balanceChange, error) {
+	if house, ok := m.players[HouseAccountID]; fees > 0 && (!ok || house.Balances[currency] < fees) {
+		return nil, ErrHouseBalance
+	}
+	return m.houseChanges(-fees), nil
+}
+
+//promoteFromWaitlist gives free seats to waitlisted players in order they joined, charging them and their backers at that point,
+//waitlisted players who can not pay anymore are dropped from waitlist, entry accepted event is queued for every promoted player
+func (m *MemoryStore) promoteFromWaitlist(tournament *Tournament) error {
+	if tournament.MaxEntrants == 0 {
+		return nil
+	}
+	for m.countEntrants(tournament.ID) < tournament.MaxEntrants {
+		next := ""
+		for _, v := range m.waitlist {
+			if v.tournamentID == tournament.ID && v.backingID == "" {
+				next = v.userID
+				break
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		var stakes []Stake
+		m.waitlist, stakes = removeEntries(m.waitlist, tournament.ID, next)
+		player, backers := splitPlayerStake(next, stakes)
+		if err := m.addEntries(tournament.ID, player, backers); err != nil {
+			continue
+		}
+		if err := m.enqueueEvent(EventEntryAccepted, newEntry(tournament.ID, player, backers, false)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//countEntrants returns number of players entered in tournament, backers are not counted
+func (m *MemoryStore) countEntrants(tournamentID string) int {
+	entrants := 0
+	for _, v := range m.entries {
+		if v.tournamentID == tournamentID && v.backingID == "" {
+			entrants++
+		}
+	}
+	return entrants
+}
+
+//findEntrants returns set of players entered in tournament, backers are not included
+func (m *MemoryStore) findEntrants(tournamentID string) map[string]bool {
+	entrants := make(map[string]bool)
+	for _, v := range m.entries {
+		if v.tournamentID == tournamentID && v.backingID == "" {
+			entrants[v.userID] = true
+		}
+	}
+	return entrants
+}
+
+//stakeEntries builds entry rows for player and its backers
+func stakeEntries(tournamentID string, player Stake, backers []Stake) []memoryEntry {
+	entries := []memoryEntry{{tournamentID: tournamentID, userID: player.PlayerID, amount: player.Amount}}
+	for _, v := range backers {
+		entries = append(entries, memoryEntry{tournamentID: tournamentID, userID: v.PlayerID, backingID: player.PlayerID, amount: v.Amount})
+	}
+	return entries
+}
+
+//removeEntries removes rows of player and its backers in tournament and returns remaining rows with stakes of removed ones
+func removeEntries(entries []memoryEntry, tournamentID string, playerID string) ([]memoryEntry, []Stake) {
+	var kept []memoryEntry
+	var removed []Stake
+	for _, v := range entries {
+		if v.tournamentID == tournamentID && ((v.userID == playerID && v.backingID == "") || v.backingID == playerID) {
+			removed = append(removed, Stake{PlayerID: v.userID, Amount: v.amount})
+			continue
+		}
+		kept = append(kept, v)
+	}
+	return kept, removed
+}
+
+//CancelTournament refunds every player and backer exactly what they were charged for their entries, takes entry fees back from house and marks tournament cancelled
+func (m *MemoryStore) CancelTournament(tournament *Tournament) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.tournaments[tournament.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if !canTransition(stored.State, StateCancelled) {
+		return ErrInvalidTransition
+	}
+
+	var changes []balanceChange
+	fees := 0
+	for _, v := range m.entries {
+		if v.tournamentID != tournament.ID {
+			continue
+		}
+		fees += v.fee
+		if v.amount != 0 {
+			changes = append(changes, balanceChange{v.userID, v.amount, ReasonRefund})
+		}
+	}
+	house, err := m.takeBackFees(fees, stored.Currency)
+	if err != nil {
+		return err
+	}
+	changes = append(changes, house...)
+	if err := m.applyBalanceChanges(changes, stored.Currency, tournament.ID); err != nil {
+		return err
+	}
+	var waitlist []memoryEntry
+	for _, v := range m.waitlist {
+		if v.tournamentID != tournament.ID {
+			waitlist = append(waitlist, v)
+		}
+	}
+	m.waitlist = waitlist
+	stored.PrizePool = 0
+	stored.State = StateCancelled
+	tournament.State = StateCancelled
+	return nil
+}
+
+//FinishTournament takes tournament and its ranked placings or winners with prizes, and correspondingly gives out prize pool to winning entries and their backers in tournament currency,
+//tournament finished and prize credited events are queued with the payout
+func (m *MemoryStore) FinishTournament(tournament *Tournament, placings []string, winners []Winner) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, err := m.lockedTournamentInState(tournament.ID, StateRunning)
+	if err != nil {
+		return err
+	}
+	prizes, err := tournamentPrizes(stored, stored.PrizePool, m.findEntrants(tournament.ID), placings, winners)
+	if err != nil {
+		return err
+	}
+
+	var changes []balanceChange
+	paid := make(map[stakePrize]int)
+	for _, prize := range prizes {
+		stakes := m.findPlayersWithBackers(tournament.ID, prize.PlayerID)
+		if len(stakes) == 0 {
+			return ErrPlayerNotEntered
+		}
+		rewards := splitByStakes(prize.Amount, stakes)
+		for i, v := range stakes {
+			changes = append(changes, balanceChange{v.PlayerID, rewards[i], ReasonPrize})
+			paid[stakePrize{v.PlayerID, prize.PlayerID}] += rewards[i]
+		}
+	}
+	if err := m.applyBalanceChanges(changes, stored.Currency, tournament.ID); err != nil {
+		return err
+	}
+	var rows []entryRow
+	for _, v := range m.entries {
+		if v.tournamentID == tournament.ID {
+			rows = append(rows, entryRow{v.userID, v.backingID, v.amount, false})
+		}
+	}
+	results := tournamentResults(tournament.ID, rows, finishingPositions(placings, winners), paid)
+	for _, v := range results {
+		v.FinishedAt = time.Now()
+		m.results = append(m.results, v)
+	}
+	stored.State = StateFinished
+	finished := m.copyTournament(stored)
+	if err := m.enqueueEvent(EventTournamentFinished, &finished); err != nil {
+		return err
+	}
+	for _, v := range prizeCredits(&finished, results) {
+		if err := m.enqueueEvent(EventPrizeCredited, &v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//LeaveTournament removes player entry and entries of its backers while registration is open, refunding what each of them was charged
+//including entry fee, freed seat goes to next player on waitlist, waitlisted player is just removed from waitlist
+func (m *MemoryStore) LeaveTournament(tournament *Tournament, playerID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, err := m.lockedTournamentInState(tournament.ID, StateRegistrationOpen)
+	if err != nil {
+		return err
+	}
+
+	kept, removed := removeEntries(m.entries, tournament.ID, playerID)
+	if len(removed) == 0 {
+		waitlist, waiting := removeEntries(m.waitlist, tournament.ID, playerID)
+		if len(waiting) == 0 {
+			return sql.ErrNoRows
+		}
+		m.waitlist = waitlist
+		return nil
+	}
+	fee := 0
+	for _, v := range m.entries {
+		if v.tournamentID == tournament.ID && v.userID == playerID && v.backingID == "" {
+			fee = v.fee
+		}
+	}
+	changes, err := m.takeBackFees(fee, stored.Currency)
+	if err != nil {
+		return err
+	}
+	refunded := 0
+	for _, v := range removed {
+		refunded += v.Amount
+		if v.Amount != 0 {
+			changes = append(changes, balanceChange{v.PlayerID, v.Amount, ReasonRefund})
+		}
+	}
+	if err := m.applyBalanceChanges(changes, stored.Currency, tournament.ID); err != nil {
+		return err
+	}
+	m.entries = kept
+	stored.PrizePool -= refunded - fee
+	return m.promoteFromWaitlist(stored)
+}
+
+//findPlayersWithBackers returns stakes of player entry and entries of its backers
+func (m *MemoryStore) findPlayersWithBackers(tournamentID string, playerID string) []Stake {
+	var stakes []Stake
+	for _, v := range m.entries {
+		if v.tournamentID != tournamentID {
+			continue
+		}
+		if (v.userID == playerID && v.backingID == "") || v.backingID == playerID {
+			stakes = append(stakes, Stake{PlayerID: v.userID, Amount: v.amount})
+		}
+	}
+	return stakes
+}
+
+//Revenue returns entry fees in currency collected by house per tournament, optionally only for one tournament and for fees booked in time range [from, to)
+func (m *MemoryStore) Revenue(tournamentID string, currency string, from *time.Time, to *time.Time) ([]TournamentRevenue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	amounts := make(map[string]int)
+	for _, v := range m.ledger {
+		if v.PlayerID != HouseAccountID || v.Reason != ReasonFee || v.Currency != currency || v.TournamentID == nil {
+			continue
+		}
+		if (tournamentID != "" && *v.TournamentID != tournamentID) || (from != nil && v.CreatedAt.Before(*from)) || (to != nil && !v.CreatedAt.Before(*to)) {
+			continue
+		}
+		amounts[*v.TournamentID] += v.Amount
+	}
+	var revenue []TournamentRevenue
+	for id, amount := range amounts {
+		revenue = append(revenue, TournamentRevenue{TournamentID: id, Amount: amount})
+	}
+	sort.Slice(revenue, func(i, j int) bool {
+		return revenue[i].TournamentID < revenue[j].TournamentID
+	})
+	return revenue, nil
+}
+
+//PlayerHistory returns page of player ledger entries matching filter, newest first
+func (m *MemoryStore) PlayerHistory(filter HistoryFilter) ([]LedgerEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reasons := make(map[string]bool)
+	for _, v := range filter.Reasons {
+		reasons[v] = true
+	}
+	var history []LedgerEntry
+	for i := len(m.ledger) - 1; i >= 0 && len(history) < filter.Limit; i-- {
+		v := m.ledger[i]
+		if v.PlayerID != filter.PlayerID || (filter.Currency != "" && v.Currency != filter.Currency) || (len(reasons) > 0 && !reasons[v.Reason]) {
+			continue
+		}
+		if (filter.From != nil && v.CreatedAt.Before(*filter.From)) || (filter.To != nil && !v.CreatedAt.Before(*filter.To)) || (filter.Before != 0 && v.ID >= filter.Before) {
+			continue
+		}
+		history = append(history, v)
+	}
+	return history, nil
+}
+
+//TournamentResults returns results of every stake in finished tournament ordered by position, stakes of players who were not placed come last
+func (m *MemoryStore) TournamentResults(tournamentID string) ([]TournamentResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var results []TournamentResult
+	for _, v := range m.results {
+		if v.TournamentID == tournamentID {
+			results = append(results, v)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i].Position, results[j].Position
+		return a != nil && (b == nil || *a < *b)
+	})
+	return results, nil
+}
+
+//Leaderboard returns statistics of players ranked by filter sort, computed from results of tournaments matching filter
+func (m *MemoryStore) Leaderboard(filter LeaderboardFilter) ([]PlayerStatistics, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	totals := make(map[string]*PlayerStatistics)
+	inTheMoney := make(map[string]map[string]bool)
+	for _, v := range m.results {
+		if (v.BackingID != "") != filter.Backers || m.tournaments[v.TournamentID].Currency != filter.Currency || (filter.PlayerID != "" && v.UserID != filter.PlayerID) {
+			continue
+		}
+		if (filter.From != nil && v.FinishedAt.Before(*filter.From)) || (filter.To != nil && !v.FinishedAt.Before(*filter.To)) {
+			continue
+		}
+		if _, ok := totals[v.UserID]; !ok {
+			totals[v.UserID] = &PlayerStatistics{PlayerID: v.UserID}
+			inTheMoney[v.UserID] = make(map[string]bool)
+		}
+		totals[v.UserID].Cost += v.Cost
+		totals[v.UserID].Winnings += v.Prize
+		inTheMoney[v.UserID][v.TournamentID] = inTheMoney[v.UserID][v.TournamentID] || v.Prize > 0
+	}
+	var statistics []PlayerStatistics
+	for playerID, v := range totals {
+		v.Tournaments = len(inTheMoney[playerID])
+		for _, paid := range inTheMoney[playerID] {
+			if paid {
+				v.InTheMoney++
+			}
+		}
+		statistics = append(statistics, *v)
+	}
+	sort.Slice(statistics, func(i, j int) bool {
+		return rankedBefore(&statistics[i], &statistics[j], filter.Sort)
+	})
+	if len(statistics) > filter.Limit {
+		statistics = statistics[:filter.Limit]
+	}
+	return statistics, nil
+}
+
+//ReserveIdempotencyKey stores new key for endpoint and api key hash with hash of the request and returns nil,
+//or returns previously stored response if key was already used by the same api key
+func (m *MemoryStore) ReserveIdempotencyKey(keyHash string, key string, endpoint string, requestHash string) (*IdempotentResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if response, ok := m.idempotency[keyHash+"\x00"+endpoint+"\x00"+key]; ok {
+		found := *response
+		return &found, nil
+	}
+	m.idempotency[keyHash+"\x00"+endpoint+"\x00"+key] = &IdempotentResponse{KeyHash: keyHash, Key: key, Endpoint: endpoint, RequestHash: requestHash}
+	return nil, nil
+}
+
+//CompleteIdempotencyKey stores outcome of the request made with reserved key
+func (m *MemoryStore) CompleteIdempotencyKey(keyHash string, key string, endpoint string, status int, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if response, ok := m.idempotency[keyHash+"\x00"+endpoint+"\x00"+key]; ok {
+		response.Status = status
+		response.Body = body
+	}
+	return nil
+}
+
+//ReleaseIdempotencyKey removes reserved key so request can be retried
+func (m *MemoryStore) ReleaseIdempotencyKey(keyHash string, key string, endpoint string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.idempotency, keyHash+"\x00"+endpoint+"\x00"+key)
+	return nil
+}
+
+//CreateAPIKey stores hashed api key
+func (m *MemoryStore) CreateAPIKey(key *APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.apiKeys[key.Hash]; ok {
+		return ErrAlreadyExists
+	}
+	m.apiKeys[key.Hash] = *key
+	return nil
+}
+
+//FindAPIKey returns api key by hash of the key
+func (m *MemoryStore) FindAPIKey(hash string) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.apiKeys[hash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &key, nil
+}
+
+//CreateGameServer stores game server with its shared secret
+func (m *MemoryStore) CreateGameServer(server *GameServer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.gameServers[server.ID]; ok {
+		return ErrAlreadyExists
+	}
+	m.gameServers[server.ID] = *server
+	return nil
+}
+
+//FindGameServer returns game server with its shared secret by id
+func (m *MemoryStore) FindGameServer(gameServerID string) (*GameServer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	server, ok := m.gameServers[gameServerID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &server, nil
+}
+
+//UseResultNonce records nonce of signed result submission, nonce already used by game server results in ErrAlreadyExists
+func (m *MemoryStore) UseResultNonce(gameServerID string, nonce string, expireBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, v := range m.nonces {
+		if v.Before(expireBefore) {
+			delete(m.nonces, k)
+		}
+	}
+	key := gameServerID + "\x00" + nonce
+	if _, ok := m.nonces[key]; ok {
+		return ErrAlreadyExists
+	}
+	m.nonces[key] = time.Now()
+	return nil
+}
+
+//CreateWebhook stores webhook endpoint with its signing secret and sets its creation time
+func (m *MemoryStore) CreateWebhook(webhook *Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.webhooks[webhook.ID]; ok {
+		return ErrAlreadyExists
+	}
+	webhook.CreatedAt = time.Now()
+	stored := *webhook
+	stored.Events = append(pq.StringArray{}, webhook.Events...)
+	m.webhooks[webhook.ID] = stored
+	return nil
+}
+
+//FindWebhook returns webhook with its signing secret by id
+func (m *MemoryStore) FindWebhook(webhookID string) (*Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	webhook, ok := m.webhooks[webhookID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &webhook, nil
+}
+
+//ListWebhooks returns every webhook in order they were registered
+func (m *MemoryStore) ListWebhooks() ([]Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sortedWebhooks(), nil
+}
+
+//sortedWebhooks returns every webhook ordered by creation time and id
+func (m *MemoryStore) sortedWebhooks() []Webhook {
+	var webhooks []Webhook
+	for _, v := range m.webhooks {
+		webhooks = append(webhooks, v)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		if !webhooks[i].CreatedAt.Equal(webhooks[j].CreatedAt) {
+			return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+		}
+		return webhooks[i].ID < webhooks[j].ID
+	})
+	return webhooks
+}
+
+//DeleteWebhook removes webhook together with its delivery log and deliveries still pending
+func (m *MemoryStore) DeleteWebhook(webhookID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.webhooks[webhookID]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.webhooks, webhookID)
+	var kept []WebhookDelivery
+	for _, v := range m.deliveries {
+		if v.WebhookID != webhookID {
+			kept = append(kept, v)
+		}
+	}
+	m.deliveries = kept
+	return nil
+}
+
+//enqueueEvent queues delivery of event to every webhook subscribed to its type under the lock held for the change which caused it,
+//webhooks without events receive all of them
+func (m *MemoryStore) enqueueEvent(eventType string, data interface{}) error {
+	eventID, payload, err := encodeEvent(eventType, data)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, webhook := range m.sortedWebhooks() {
+		subscribed := len(webhook.Events) == 0
+		for _, v := range webhook.Events {
+			subscribed = subscribed || v == eventType
+		}
+		if !subscribed {
+			continue
+		}
+		m.deliveryID++
+		m.deliveries = append(m.deliveries, WebhookDelivery{
+			ID:            m.deliveryID,
+			WebhookID:     webhook.ID,
+			EventID:       eventID,
+			EventType:     eventType,
+			Payload:       payload,
+			Status:        DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+	return nil
+}
+
+//DueDeliveries claims pending deliveries whose next attempt is due at now by moving their next attempt to leaseUntil,
+//so other dispatchers skip them while they are sent, deliveries are returned oldest first with url and secret of their webhook
+func (m *MemoryStore) DueDeliveries(now time.Time, leaseUntil time.Time, limit int) ([]WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var due []int
+	for i, v := range m.deliveries {
+		if v.Status == DeliveryPending && !v.NextAttemptAt.After(now) {
+			due = append(due, i)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return m.deliveries[due[i]].NextAttemptAt.Before(m.deliveries[due[j]].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	sort.Ints(due)
+	var deliveries []WebhookDelivery
+	for _, i := range due {
+		m.deliveries[i].NextAttemptAt = leaseUntil
+		delivery := m.deliveries[i]
+		webhook := m.webhooks[delivery.WebhookID]
+		delivery.URL, delivery.Secret = webhook.URL, webhook.Secret
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+//RecordDeliveryAttempt stores outcome of delivery attempt: its status, attempts made, next attempt time and last response
+func (m *MemoryStore) RecordDeliveryAttempt(delivery *WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, v := range m.deliveries {
+		if v.ID == delivery.ID {
+			v.Status, v.Attempts, v.NextAttemptAt = delivery.Status, delivery.Attempts, delivery.NextAttemptAt
+			v.LastStatusCode, v.LastError, v.DeliveredAt = delivery.LastStatusCode, delivery.LastError, delivery.DeliveredAt
+			m.deliveries[i] = v
+		}
+	}
+	return nil
+}
+
+//WebhookDeliveries returns page of webhook delivery log matching filter, newest first
+func (m *MemoryStore) WebhookDeliveries(filter DeliveryFilter) ([]WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deliveries []WebhookDelivery
+	for i := len(m.deliveries) - 1; i >= 0 && len(deliveries) < filter.Limit; i-- {
+		v := m.deliveries[i]
+		if v.WebhookID != filter.WebhookID || (filter.Status != "" && v.Status != filter.Status) || (filter.Before != 0 && v.ID >= filter.Before) {
+			continue
+		}
+		deliveries = append(deliveries, v)
+	}
+	return deliveries, nil
+}
+
+//ResetDatabase drops all stored data except api keys and game servers
+func (m *MemoryStore) ResetDatabase() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.players = make(map[string]*Player)
+	m.tournaments = make(map[string]*Tournament)
+	m.entries = nil
+	m.waitlist = nil
+	m.results = nil
+	m.ledger = nil
+	m.idempotency = make(map[string]*IdempotentResponse)
+	m.nonces = make(map[string]time.Time)
+	m.webhooks = make(map[string]Webhook)
+	m.deliveries = nil
+	m.deliveryID = 0
+}
+
+//balanceChange is single signed balance update for a player
+type balanceChange struct {
+	playerID string
+	amount   int
+	reason   string
+}
+
+//applyBalanceChanges applies all changes to balances in currency and records them in ledger, or none of them if any player is missing or balance would go below zero
+func (m *MemoryStore) applyBalanceChanges(changes []balanceChange, currency string, tournamentID string) error {
+	balances := make(map[string]int)
+	for _, v := range changes {
+		player, ok := m.players[v.playerID]
+		if !ok {
+			return ErrPlayerNotFound
+		}
+		if _, ok := balances[v.playerID]; !ok {
+			balances[v.playerID] = player.Balances[currency]
+		}
+		balances[v.playerID] += v.amount
+		if balances[v.playerID] < 0 {
+			return ErrInsufficientBalance
+		}
+		if balances[v.playerID] > int(MaxMoney) {
+			return ErrAmountOutOfRange
+		}
+	}
+
+	var reference *string
+	if tournamentID != "" {
+		reference = &tournamentID
+	}
+	for _, v := range changes {
+		player := m.players[v.playerID]
+		player.Balances[currency] += v.amount
+		m.ledger = append(m.ledger, LedgerEntry{
+			ID:           len(m.ledger) + 1,
+			PlayerID:     v.playerID,
+			Currency:     currency,
+			Amount:       v.amount,
+			Balance:      player.Balances[currency],
+			Reason:       v.reason,
+			TournamentID: reference,
+			CreatedAt:    time.Now(),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+//migrationLockID is postgres advisory lock key held while migration is applied
+const migrationLockID = 7245390
+
+//migration is single versioned schema change with its up and down steps
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+//migrations holds all schema changes in order, version of the last one is schema version this binary expects
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create players and tournaments",
+		up: `
+			create table if not exists player (
+				id varchar(64) not null primary key,
+				balance integer not null default 0 check (balance >= 0)
+			);
+
+			create table if not exists tournament (
+				id varchar(64) not null primary key,
+				deposit integer not null,
+				finished boolean not null default false
+			);
+
+			create table if not exists tournament_entries (
+				id serial not null primary key,
+				tournament_id varchar(64) not null references tournament (id),
+				user_id varchar(64) not null references player (id),
+				backing_id varchar(64) references player (id)
+			);
+		`,
+		down: `
+			drop table tournament_entries;
+			drop table tournament;
+			drop table player;
+		`,
+	},
+	{
+		version: 2,
+		name:    "create ledger",
+		up: `
+			create table if not exists ledger (
+				id serial not null primary key,
+				player_id varchar(64) not null references player (id),
+				amount integer not null,
+				balance integer not null,
+				reason varchar(16) not null,
+				tournament_id varchar(64) references tournament (id),
+				created_at timestamp with time zone not null default now()
+			);
+		`,
+		down: `
+			drop table ledger;
+		`,
+	},
+	{
+		version: 3,
+		name:    "create idempotency keys",
+		up: `
+			create table if not exists idempotency_keys (
+				key varchar(128) not null,
+				endpoint varchar(64) not null,
+				request_hash char(64) not null default '',
+				status integer,
+				body text not null default '',
+				created_at timestamp with time zone not null default now(),
+				primary key (key, endpoint)
+			);
+		`,
+		down: `
+			drop table idempotency_keys;
+		`,
+	},
+	{
+		version: 4,
+		name:    "replace tournament finished flag with state",
+		up: `
+			alter table tournament add column state varchar(32) not null default 'announced'
+				check (state in ('announced', 'registration_open', 'registration_closed', 'running', 'finished', 'cancelled'));
+			update tournament set state = 'registration_open' where finished = false;
+			update tournament set state = 'finished' where finished = true;
+			alter table tournament drop column finished;
+		`,
+		down: `
+			alter table tournament add column finished boolean not null default false;
+			update tournament set finished = (state in ('finished', 'cancelled'));
+			alter table tournament drop column state;
+		`,
+	},
+	{
+		version: 5,
+		name:    "store charged amount on tournament entries",
+		up: `
+			alter table tournament_entries add column amount integer not null default 0 check (amount >= 0);
+			update tournament_entries e set amount = charged.amount from (
+				select en.id, t.deposit / count(*) over w
+					+ case when row_number() over (w order by en.id) <= t.deposit % count(*) over w then 1 else 0 end as amount
+				from tournament_entries en
+				join tournament t on t.id = en.tournament_id
+				window w as (partition by en.tournament_id, coalesce(en.backing_id, en.user_id))
+			) charged where charged.id = e.id;
+		`,
+		down: `
+			alter table tournament_entries drop column amount;
+		`,
+	},
+	//player holds one seat, duplicate entries made before unique index are merged into the earliest one keeping what was charged for them
+	{
+		version: 6,
+		name:    "add tournament entrant limits and waitlist",
+		up: `
+			alter table tournament add column min_entrants integer not null default 0 check (min_entrants >= 0);
+			alter table tournament add column max_entrants integer not null default 0 check (max_entrants >= 0);
+
+			create table tournament_waitlist (
+				id serial not null primary key,
+				tournament_id varchar(64) not null references tournament (id),
+				user_id varchar(64) not null references player (id),
+				backing_id varchar(64) references player (id),
+				amount integer not null check (amount >= 0)
+			);
+
+			update tournament_entries e set amount = merged.amount from (
+				select min(id) as id, sum(amount) as amount from tournament_entries
+				where backing_id is null group by tournament_id, user_id having count(*) > 1
+			) merged where merged.id = e.id;
+			delete from tournament_entries a using tournament_entries b
+				where a.backing_id is null and b.backing_id is null
+				and a.tournament_id = b.tournament_id and a.user_id = b.user_id and a.id > b.id;
+			create unique index tournament_entries_player_idx on tournament_entries (tournament_id, user_id) where backing_id is null;
+		`,
+		down: `
+			drop index tournament_entries_player_idx;
+			drop table tournament_waitlist;
+			alter table tournament drop column max_entrants;
+			alter table tournament drop column min_entrants;
+		`,
+	},
+	{
+		version: 7,
+		name:    "add tournament schedule",
+		up: `
+			alter table tournament add column starts_at timestamp with time zone;
+			alter table tournament add column registration_deadline timestamp with time zone;
+			alter table tournament add column auto_cancel boolean not null default false;
+			create index tournament_schedule_idx on tournament (state, starts_at);
+		`,
+		down: `
+			drop index tournament_schedule_idx;
+			alter table tournament drop column auto_cancel;
+			alter table tournament drop column registration_deadline;
+			alter table tournament drop column starts_at;
+		`,
+	},
+	{
+		version: 8,
+		name:    "add tournament prize pool and payout structure",
+		up: `
+			alter table tournament add column prize_pool integer not null default 0 check (prize_pool >= 0);
+			alter table tournament add column payout varchar(32) not null default 'winner_takes_all'
+				check (payout in ('winner_takes_all', 'top3', 'top15'));
+			update tournament t set prize_pool = collected.amount from (
+				select tournament_id, sum(amount) as amount from tournament_entries group by tournament_id
+			) collected where collected.tournament_id = t.id and t.state <> 'cancelled';
+		`,
+		down: `
+			alter table tournament drop column payout;
+			alter table tournament drop column prize_pool;
+		`,
+	},
+	{
+		version: 9,
+		name:    "add tournament entry fee",
+		up: `
+			alter table tournament add column fee integer not null default 0 check (fee >= 0);
+			alter table tournament add column fee_included boolean not null default false;
+			alter table tournament add constraint tournament_fee_included_check check (not fee_included or fee < deposit);
+			alter table tournament_entries add column fee integer not null default 0 check (fee >= 0);
+		`,
+		down: `
+			alter table tournament_entries drop column fee;
+			alter table tournament drop column fee_included;
+			alter table tournament drop column fee;
+		`,
+	},
+	{
+		version: 10,
+		name:    "create api keys and scope idempotency keys by them",
+		up: `
+			create table if not exists api_keys (
+				key_hash char(64) not null primary key,
+				scope varchar(16) not null check (scope in ('operator', 'player')),
+				player_id varchar(64),
+				created_at timestamp with time zone not null default now(),
+				check ((scope = 'player') = (player_id is not null))
+			);
+
+			alter table idempotency_keys add column key_hash char(64) not null default '';
+			alter table idempotency_keys drop constraint idempotency_keys_pkey;
+			alter table idempotency_keys add primary key (key_hash, key, endpoint);
+		`,
+		down: `
+			delete from idempotency_keys a using idempotency_keys b
+				where a.key = b.key and a.endpoint = b.endpoint and a.key_hash > b.key_hash;
+			alter table idempotency_keys drop constraint idempotency_keys_pkey;
+			alter table idempotency_keys drop column key_hash;
+			alter table idempotency_keys add primary key (key, endpoint);
+
+			drop table api_keys;
+		`,
+	},
+	{
+		version: 11,
+		name:    "create game servers and result nonces",
+		up: `
+			create table if not exists game_servers (
+				id varchar(64) not null primary key,
+				secret varchar(128) not null,
+				created_at timestamp with time zone not null default now()
+			);
+			alter table tournament add column game_server_id varchar(64) references game_servers (id);
+			create table if not exists result_nonces (
+				game_server_id varchar(64) not null references game_servers (id),
+				nonce varchar(64) not null,
+				created_at timestamp with time zone not null default now(),
+				primary key (game_server_id, nonce)
+			);
+			create index result_nonces_created_at_idx on result_nonces (created_at);
+		`,
+		down: `
+			drop table result_nonces;
+			alter table tournament drop column game_server_id;
+			drop table game_servers;
+		`,
+	},
+	//balances, ledger and tournaments from before currencies were introduced are all EUR, the only currency legacy deployments had,
+	//EUR is kept among configured currencies by default so they stay usable
+	{
+		version: 12,
+		name:    "move player balances to per-currency player_balance table",
+		up: `
+			create table player_balance (
+				player_id varchar(64) not null references player (id),
+				currency varchar(8) not null,
+				balance integer not null default 0,
+				constraint player_balance_check check (balance >= 0),
+				primary key (player_id, currency)
+			);
+			insert into player_balance (player_id, currency, balance) select id, 'EUR', balance from player;
+			alter table player drop column balance;
+
+			alter table ledger add column currency varchar(8) not null default 'EUR';
+			alter table ledger alter column currency drop default;
+			alter table tournament add column currency varchar(8) not null default 'EUR';
+			alter table tournament alter column currency drop default;
+		`,
+		down: `
+			alter table tournament drop column currency;
+			alter table ledger drop column currency;
+
+			alter table player add column balance integer not null default 0 check (balance >= 0);
+			update player p set balance = b.balance from player_balance b where b.player_id = p.id and b.currency = 'EUR';
+			drop table player_balance;
+		`,
+	},
+	{
+		version: 13,
+		name:    "add tournament created_at for listing tournaments",
+		up: `
+			alter table tournament add column created_at timestamp with time zone not null default now();
+			create index tournament_listed_at_idx on tournament ((coalesce(starts_at, created_at)), id);
+		`,
+		down: `
+			drop index tournament_listed_at_idx;
+			alter table tournament drop column created_at;
+		`,
+	},
+	{
+		version: 14,
+		name:    "add ledger index for player transaction history",
+		up: `
+			create index ledger_player_history_idx on ledger (player_id, id);
+		`,
+		down: `
+			drop index ledger_player_history_idx;
+		`,
+	},
+	{
+		version: 15,
+		name:    "add tournament_results table for leaderboards",
+		up: `
+			create table tournament_results (
+				id serial not null primary key,
+				tournament_id varchar(64) not null references tournament (id),
+				user_id varchar(64) not null references player (id),
+				backing_id varchar(64) references player (id),
+				position integer check (position > 0),
+				cost integer not null check (cost >= 0),
+				prize integer not null check (prize >= 0),
+				finished_at timestamp with time zone not null default now()
+			);
+			create index tournament_results_finished_at_idx on tournament_results (finished_at);
+		`,
+		down: `
+			drop table tournament_results;
+		`,
+	},
+	{
+		version: 16,
+		name:    "add webhooks and webhook_deliveries tables for event notifications",
+		up: `
+			create table webhooks (
+				id varchar(64) not null primary key,
+				url text not null,
+				secret varchar(64) not null,
+				events text[] not null default '{}',
+				created_at timestamp with time zone not null default now()
+			);
+			create table webhook_deliveries (
+				id serial not null primary key,
+				webhook_id varchar(64) not null references webhooks (id) on delete cascade,
+				event_id varchar(64) not null,
+				event_type varchar(32) not null,
+				payload text not null,
+				status varchar(16) not null default 'pending',
+				attempts integer not null default 0,
+				next_attempt_at timestamp with time zone not null default now(),
+				last_status_code integer not null default 0,
+				last_error text not null default '',
+				created_at timestamp with time zone not null default now(),
+				delivered_at timestamp with time zone
+			);
+			create index webhook_deliveries_due_idx on webhook_deliveries (next_attempt_at) where status = 'pending';
+			create index webhook_deliveries_log_idx on webhook_deliveries (webhook_id, id);
+		`,
+		down: `
+			drop table webhook_deliveries;
+			drop table webhooks;
+		`,
+	},
+}
+
+//MigrationStatus describes whether migration is applied to database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+//latestSchemaVersion returns schema version this binary expects
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+//createSchemaVersionTable makes sure schema_version table exists
+func (db *DB) createSchemaVersionTable() error {
+	_, err := db.Exec(`
+		create table if not exists schema_version (
+			version integer not null primary key,
+			name varchar(128) not null,
+			applied_at timestamp with time zone not null default now()
+		);
+	`)
+	return err
+}
+
+//SchemaVersion returns version of the last applied migration, 0 if none is applied
+func (db *DB) SchemaVersion() (int, error) {
+	if err := db.createSchemaVersionTable(); err != nil {
+		return 0, err
+	}
+	var version int
+	if err := db.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_version;"); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+//CheckSchemaVersion returns error if database schema is older or newer than this binary expects
+func (db *DB) CheckSchemaVersion() error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version < latestSchemaVersion() {
+		return fmt.Errorf("database schema version %d is older than expected %d, run migrate up", version, latestSchemaVersion())
+	}
+	if version > latestSchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than expected %d, upgrade application or run migrate down with newer binary", version, latestSchemaVersion())
+	}
+	return nil
+}
+
+//MigrateUp applies all pending migrations, each in its own transaction, and returns applied ones
+func (db *DB) MigrateUp() ([]int, error) {
+	if err := db.createSchemaVersionTable(); err != nil {
+		return nil, err
+	}
+	var applied []int
+	for _, m := range migrations {
+		done, err := db.applyMigration(m, true)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %v", m.version, m.name, err)
+		}
+		if done {
+			applied = append(applied, m.version)
+		}
+	}
+	return applied, nil
+}
+
+//MigrateDown reverts last applied migration and returns its version, 0 if there was nothing to revert
+func (db *DB) MigrateDown() (int, error) {
+	version, err := db.SchemaVersion()
+	if err != nil || version == 0 {
+		return 0, err
+	}
+	for _, m := range migrations {
+		if m.version != version {
+			continue
+		}
+		if _, err := db.applyMigration(m, false); err != nil {
+			return 0, fmt.Errorf("migration %d (%s): %v", m.version, m.name, err)
+		}
+		return version, nil
+	}
+	return 0, fmt.Errorf("database schema version %d is unknown to this binary", version)
+}
+
+//applyMigration runs up or down step of migration under advisory lock, returns false if database already was in desired state
+func (db *DB) applyMigration(m migration, up bool) (bool, error) {
+	tx := db.MustBegin()
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1);", migrationLockID); err != nil {
+		return false, err
+	}
+	var version int
+	if err := tx.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_version;"); err != nil {
+		return false, err
+	}
+
+	if up {
+		if version >= m.version {
+			return false, nil
+		}
+		if _, err := tx.Exec(m.up); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec("INSERT INTO schema_version (version, name) VALUES ($1, $2);", m.version, m.name); err != nil {
+			return false, err
+		}
+	} else {
+		if version != m.version {
+			return false, nil
+		}
+		if _, err := tx.Exec(m.down); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec("DELETE FROM schema_version WHERE version = $1;", m.version); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+//MigrationStatuses returns every known migration with time it was applied at
+func (db *DB) MigrationStatuses() ([]MigrationStatus, error) {
+	if err := db.createSchemaVersionTable(); err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Version   int       `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}
+	if err := db.Select(&rows, "SELECT version, applied_at FROM schema_version ORDER BY version;"); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]time.Time)
+	for _, v := range rows {
+		applied[v.Version] = v.AppliedAt
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.version, Name: m.name}
+		if at, ok := applied[m.version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMigrations(t *testing.T) {
+	Convey("Given list of migrations", t, func() {
+		Convey("Versions should start at 1 and increase by one with both up and down steps", func() {
+			for i, m := range migrations {
+				So(m.version, ShouldEqual, i+1)
+				So(m.name, ShouldNotBeEmpty)
+				So(m.up, ShouldNotBeEmpty)
+				So(m.down, ShouldNotBeEmpty)
+			}
+			So(latestSchemaVersion(), ShouldEqual, len(migrations))
+		})
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//Money is amount of points in cents (points * 100), it is parsed from and formatted to decimal strings exactly without going through floats
+type Money int
+
+//MaxMoney is the largest amount which fits into integer columns amounts are stored in
+const MaxMoney = Money(math.MaxInt32)
+
+//Errors returned when parsing money
+var (
+	ErrInvalidMoney     = errors.New("amount must be decimal number with at most two decimal places")
+	ErrAmountOutOfRange = errors.New("amount is out of range")
+)
+
+//ParseMoney parses decimal string such as "12", "-0.5" or "0.29" into exact amount of cents,
+//more than two decimal places, exponents and amounts which do not fit into MaxMoney are rejected
+func ParseMoney(input string) (Money, error) {
+	negative := strings.HasPrefix(input, "-")
+	whole := strings.TrimPrefix(input, "-")
+	fraction := ""
+	if i := strings.IndexByte(whole, '.'); i >= 0 {
+		whole, fraction = whole[:i], whole[i+1:]
+		if fraction == "" {
+			return 0, ErrInvalidMoney
+		}
+	}
+	if whole == "" || len(fraction) > 2 || !digits(whole) || !digits(fraction) {
+		return 0, ErrInvalidMoney
+	}
+
+	points, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || points > int64(MaxMoney)/100 {
+		return 0, ErrAmountOutOfRange
+	}
+	cents := int64(0)
+	if fraction != "" {
+		cents, _ = strconv.ParseInt(fraction+strings.Repeat("0", 2-len(fraction)), 10, 64)
+	}
+	amount := points*100 + cents
+	if amount > int64(MaxMoney) {
+		return 0, ErrAmountOutOfRange
+	}
+	if negative {
+		amount = -amount
+	}
+	return Money(amount), nil
+}
+
+//digits tells if string consists only of ascii digits
+func digits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+//String formats amount as decimal with two decimal places, 5 cents is "0.05"
+func (m Money) String() string {
+	sign := ""
+	cents := int64(m)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	fraction := strconv.FormatInt(cents%100, 10)
+	if len(fraction) == 1 {
+		fraction = "0" + fraction
+	}
+	return sign + strconv.FormatInt(cents/100, 10) + "." + fraction
+}
+
+//Set parses amount from decimal string, so money can be used as flag value
+func (m *Money) Set(value string) error {
+	parsed, err := ParseMoney(value)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+//MarshalJSON writes amount as exact json number
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+//UnmarshalJSON reads amount given either as json number or decimal string
+func (m *Money) UnmarshalJSON(data []byte) error {
+	return m.Set(strings.Trim(string(data), `"`))
+}
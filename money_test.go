@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMoney(t *testing.T) {
+	Convey("Given decimal amounts are parsed", t, func() {
+		Convey("They should be converted to cents exactly", func() {
+			for input, expected := range map[string]Money{
+				"0": 0, "12": 1200, "0.29": 29, "0.05": 5, "0.5": 50, "1.10": 110, "-3.07": -307, "007.01": 701,
+				"21474836.47": MaxMoney,
+			} {
+				amount, err := ParseMoney(input)
+				So(err, ShouldBeNil)
+				So(amount, ShouldEqual, expected)
+			}
+		})
+		Convey("Malformed amounts or amounts with more than two decimal places should be rejected", func() {
+			for _, input := range []string{"", "-", ".5", "5.", "0.291", "1e2", "1,5", " 1", "+1", "0x10", "NaN", "1.-5"} {
+				_, err := ParseMoney(input)
+				So(err, ShouldEqual, ErrInvalidMoney)
+			}
+		})
+		Convey("Amounts which do not fit into database should overflow", func() {
+			for _, input := range []string{"21474836.48", "99999999999999999999", "-21474836.48"} {
+				_, err := ParseMoney(input)
+				So(err, ShouldEqual, ErrAmountOutOfRange)
+			}
+		})
+	})
+
+	Convey("Given amounts are formatted", t, func() {
+		Convey("They should have exactly two decimal places", func() {
+			So(Money(5).String(), ShouldEqual, "0.05")
+			So(Money(50).String(), ShouldEqual, "0.50")
+			So(Money(-307).String(), ShouldEqual, "-3.07")
+			So(Money(123456).String(), ShouldEqual, "1234.56")
+			data, _ := json.Marshal(&Player{ID: "P1", Balances: map[string]int{"EUR": 5, "USD": 120}})
+			So(string(data), ShouldEqual, `{"playerId":"P1","balances":{"EUR":0.05,"USD":1.20}}`)
+		})
+		Convey("Json numbers and strings should be read exactly", func() {
+			var winners []Winner
+			err := json.Unmarshal([]byte(`[{"playerId": "P1", "prize": 0.29}, {"playerId": "P2", "prize": "10"}]`), &winners)
+			So(err, ShouldBeNil)
+			So(winners[0].Prize, ShouldEqual, 29)
+			So(winners[1].Prize, ShouldEqual, 1000)
+			So(json.Unmarshal([]byte(`[{"playerId": "P1", "prize": 0.001}]`), &winners), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,917 @@
+package main
+
+import "net/http"
+
+/**
+* GET /openapi.json
+**/
+func (h *Handlers) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(openAPISpec))
+}
+
+//openAPISpec is OpenAPI 3 description of every route registered by newRouter, amounts are in points (2 decimal places),
+//openapi_test.go checks it against registered routes and real requests and responses so keep it in sync with handlers
+const openAPISpec = `{
+  "openapi": "3.0.0",
+  "info": {
+    "title": "Tournament service",
+    "version": "2.0.0",
+    "description": "Players, tournaments, entries with backers and prize payouts. All amounts are points with up to 2 decimal places in currency of player balance or tournament. Legacy GET routes are kept while clients migrate to /v2."
+  },
+  "security": [{"apiKey": []}, {"bearer": []}],
+  "paths": {
+    "/take": {
+      "get": {
+        "summary": "Take points from player balance",
+        "parameters": [
+          {"$ref": "#/components/parameters/PlayerIdQuery"},
+          {"$ref": "#/components/parameters/PointsQuery"},
+          {"$ref": "#/components/parameters/CurrencyQuery"},
+          {"$ref": "#/components/parameters/IdempotencyKeyQuery"},
+          {"$ref": "#/components/parameters/IdempotencyKeyHeader"}
+        ],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Points taken"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/fund": {
+      "get": {
+        "summary": "Add points to player balance, player is created when it does not exist",
+        "parameters": [
+          {"$ref": "#/components/parameters/PlayerIdQuery"},
+          {"$ref": "#/components/parameters/PointsQuery"},
+          {"$ref": "#/components/parameters/CurrencyQuery"},
+          {"$ref": "#/components/parameters/IdempotencyKeyQuery"},
+          {"$ref": "#/components/parameters/IdempotencyKeyHeader"}
+        ],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Points added"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/announceTournament": {
+      "get": {
+        "summary": "Announce new tournament",
+        "parameters": [
+          {"$ref": "#/components/parameters/TournamentIdQuery"},
+          {"name": "deposit", "in": "query", "required": true, "schema": {"type": "number"}},
+          {"name": "fee", "in": "query", "schema": {"type": "number"}},
+          {"name": "feeIncluded", "in": "query", "schema": {"type": "boolean"}},
+          {"name": "minEntrants", "in": "query", "schema": {"type": "integer"}},
+          {"name": "maxEntrants", "in": "query", "schema": {"type": "integer"}},
+          {"name": "payout", "in": "query", "schema": {"$ref": "#/components/schemas/Payout"}},
+          {"name": "startsAt", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "registrationDeadline", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "autoCancel", "in": "query", "schema": {"type": "boolean"}},
+          {"name": "gameServerId", "in": "query", "description": "game server which has to sign tournament results", "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/CurrencyQuery"}
+        ],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Tournament announced"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/joinTournament": {
+      "get": {
+        "summary": "Enter player into tournament, optionally backed by other players",
+        "parameters": [
+          {"$ref": "#/components/parameters/TournamentIdQuery"},
+          {"$ref": "#/components/parameters/PlayerIdQuery"},
+          {"name": "backerId", "in": "query", "schema": {"type": "array", "items": {"type": "string"}}},
+          {"name": "backerShare", "in": "query", "description": "percentage of entry cost per backerId, in same order", "schema": {"type": "array", "items": {"type": "number"}}},
+          {"name": "backerAmount", "in": "query", "description": "points of entry cost per backerId, in same order", "schema": {"type": "array", "items": {"type": "number"}}},
+          {"$ref": "#/components/parameters/IdempotencyKeyQuery"},
+          {"$ref": "#/components/parameters/IdempotencyKeyHeader"}
+        ],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Player entered"},
+          "202": {"description": "Tournament is full, player was put on waitlist without being charged"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/leaveTournament": {
+      "get": {
+        "summary": "Remove player entry and refund player and its backers",
+        "parameters": [
+          {"$ref": "#/components/parameters/TournamentIdQuery"},
+          {"$ref": "#/components/parameters/PlayerIdQuery"}
+        ],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Entry removed"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/openRegistration": {
+      "get": {
+        "summary": "Open tournament registration",
+        "parameters": [{"$ref": "#/components/parameters/TournamentIdQuery"}],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Tournament moved to next state"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/closeRegistration": {
+      "get": {
+        "summary": "Close tournament registration",
+        "parameters": [{"$ref": "#/components/parameters/TournamentIdQuery"}],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Tournament moved to next state"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/startTournament": {
+      "get": {
+        "summary": "Start tournament",
+        "parameters": [{"$ref": "#/components/parameters/TournamentIdQuery"}],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Tournament moved to next state"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/cancelTournament": {
+      "get": {
+        "summary": "Cancel tournament and refund its entries",
+        "parameters": [{"$ref": "#/components/parameters/TournamentIdQuery"}],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Tournament moved to next state"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/resultTournament": {
+      "post": {
+        "summary": "Finish running tournament and pay out prizes, results have to be signed by game server tournament is tied to",
+        "security": [{"resultSignature": []}],
+        "parameters": [
+          {"$ref": "#/components/parameters/TimestampHeader"},
+          {"$ref": "#/components/parameters/NonceHeader"}
+        ],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ResultsRequest"}}}},
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Prizes paid out"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/balance": {
+      "get": {
+        "summary": "Player balance in single currency",
+        "parameters": [{"$ref": "#/components/parameters/PlayerIdQuery"}, {"$ref": "#/components/parameters/CurrencyQuery"}],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Wallet", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Wallet"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/revenue": {
+      "get": {
+        "summary": "Net entry fees collected by house per tournament in single currency",
+        "parameters": [
+          {"name": "tournamentId", "in": "query", "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/CurrencyQuery"},
+          {"name": "from", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "to", "in": "query", "description": "exclusive", "schema": {"type": "string", "format": "date-time"}}
+        ],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Revenue report", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RevenueReport"}}}},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/reset": {
+      "get": {
+        "summary": "Reset database, only registered when admin endpoints are enabled",
+        "responses": {"200": {"description": "Database reset"}, "401": {"$ref": "#/components/responses/Error"}, "403": {"$ref": "#/components/responses/Error"}}
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "summary": "This document",
+        "security": [],
+        "responses": {"200": {"description": "OpenAPI document", "content": {"application/json": {"schema": {"type": "object"}}}}}
+      }
+    },
+    "/v2/players/{playerId}": {
+      "get": {
+        "summary": "Player balance",
+        "parameters": [{"$ref": "#/components/parameters/PlayerIdPath"}],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Player", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Player"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/players/{playerId}/transactions": {
+      "get": {
+        "summary": "Player balance changes newest first, with tournament they belong to and balance after each change, page after page",
+        "parameters": [
+          {"$ref": "#/components/parameters/PlayerIdPath"},
+          {"name": "type", "in": "query", "description": "repeat to list several types", "schema": {"type": "array", "items": {"$ref": "#/components/schemas/TransactionType"}}, "explode": true},
+          {"name": "currency", "in": "query", "description": "all currencies when not given", "schema": {"$ref": "#/components/schemas/Currency"}},
+          {"name": "from", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "to", "in": "query", "description": "exclusive", "schema": {"type": "string", "format": "date-time"}},
+          {"$ref": "#/components/parameters/CursorQuery"},
+          {"$ref": "#/components/parameters/LimitQuery"}
+        ],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Page of transactions", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/TransactionList"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/players/{playerId}/statistics": {
+      "get": {
+        "summary": "Results of player own entries and of its backing stakes in finished tournaments of single currency",
+        "parameters": [
+          {"$ref": "#/components/parameters/PlayerIdPath"},
+          {"$ref": "#/components/parameters/CurrencyQuery"},
+          {"$ref": "#/components/parameters/FinishedFromQuery"},
+          {"$ref": "#/components/parameters/FinishedToQuery"}
+        ],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Player statistics", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PlayerResults"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/leaderboards/players": {
+      "get": {
+        "summary": "Players ranked by results of their own entries in finished tournaments of single currency",
+        "parameters": [
+          {"$ref": "#/components/parameters/CurrencyQuery"},
+          {"$ref": "#/components/parameters/FinishedFromQuery"},
+          {"$ref": "#/components/parameters/FinishedToQuery"},
+          {"$ref": "#/components/parameters/LeaderboardSortQuery"},
+          {"$ref": "#/components/parameters/LimitQuery"}
+        ],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Leaderboard", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Leaderboard"}}}},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/leaderboards/backers": {
+      "get": {
+        "summary": "Players ranked by returns of their backing stakes in finished tournaments of single currency",
+        "parameters": [
+          {"$ref": "#/components/parameters/CurrencyQuery"},
+          {"$ref": "#/components/parameters/FinishedFromQuery"},
+          {"$ref": "#/components/parameters/FinishedToQuery"},
+          {"$ref": "#/components/parameters/LeaderboardSortQuery"},
+          {"$ref": "#/components/parameters/LimitQuery"}
+        ],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Leaderboard", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Leaderboard"}}}},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/players/{playerId}/deposits": {
+      "post": {
+        "summary": "Add points to player balance, player is created when it does not exist",
+        "parameters": [{"$ref": "#/components/parameters/PlayerIdPath"}, {"$ref": "#/components/parameters/IdempotencyKeyHeader"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/FundsRequest"}}}},
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "201": {"description": "Deposit made", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Funds"}}}},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/players/{playerId}/withdrawals": {
+      "post": {
+        "summary": "Take points from player balance",
+        "parameters": [{"$ref": "#/components/parameters/PlayerIdPath"}, {"$ref": "#/components/parameters/IdempotencyKeyHeader"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/FundsRequest"}}}},
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "201": {"description": "Withdrawal made", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Funds"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/tournaments": {
+      "get": {
+        "summary": "Tournaments ordered by start time, or announcement time when tournament has no start time, page after page",
+        "parameters": [
+          {"name": "state", "in": "query", "schema": {"$ref": "#/components/schemas/State"}},
+          {"name": "from", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "to", "in": "query", "description": "exclusive", "schema": {"type": "string", "format": "date-time"}},
+          {"$ref": "#/components/parameters/CursorQuery"},
+          {"$ref": "#/components/parameters/LimitQuery"}
+        ],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Page of tournaments", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/TournamentList"}}}},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Announce new tournament",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/TournamentRequest"}}}},
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "201": {"description": "Tournament announced", "headers": {"Location": {"schema": {"type": "string"}}}, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Tournament"}}}},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/tournaments/{tournamentId}": {
+      "get": {
+        "summary": "Tournament",
+        "parameters": [{"$ref": "#/components/parameters/TournamentIdPath"}],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Tournament", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Tournament"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/tournaments/{tournamentId}/state": {
+      "put": {
+        "summary": "Move tournament to another state, tournament is finished by posting its results",
+        "parameters": [{"$ref": "#/components/parameters/TournamentIdPath"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/StateRequest"}}}},
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Updated tournament", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Tournament"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/tournaments/{tournamentId}/entries": {
+      "get": {
+        "summary": "Entries of tournament with stakes of players and their backers, waitlisted entries follow seated ones",
+        "parameters": [{"$ref": "#/components/parameters/TournamentIdPath"}],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Tournament entries", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/EntryList"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Enter player into tournament, optionally backed by other players",
+        "parameters": [{"$ref": "#/components/parameters/TournamentIdPath"}, {"$ref": "#/components/parameters/IdempotencyKeyHeader"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/EntryRequest"}}}},
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "201": {"description": "Player entered", "headers": {"Location": {"schema": {"type": "string"}}}, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Entry"}}}},
+          "202": {"description": "Tournament is full, player was put on waitlist without being charged", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Entry"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/tournaments/{tournamentId}/entries/{playerId}": {
+      "delete": {
+        "summary": "Remove player entry and refund player and its backers",
+        "parameters": [{"$ref": "#/components/parameters/TournamentIdPath"}, {"$ref": "#/components/parameters/PlayerIdPath"}],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "204": {"description": "Entry removed"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/tournaments/{tournamentId}/results": {
+      "get": {
+        "summary": "Finishing position, cost and prize of every stake in finished tournament, unplaced players come last",
+        "parameters": [{"$ref": "#/components/parameters/TournamentIdPath"}],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Tournament results, empty until tournament is finished", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ResultList"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Finish running tournament and pay out prizes, results have to be signed by game server tournament is tied to",
+        "security": [{"resultSignature": []}],
+        "parameters": [
+          {"$ref": "#/components/parameters/TournamentIdPath"},
+          {"$ref": "#/components/parameters/TimestampHeader"},
+          {"$ref": "#/components/parameters/NonceHeader"}
+        ],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/TournamentResults"}}}},
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Finished tournament", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Tournament"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/game-servers": {
+      "post": {
+        "summary": "Register game server, its shared secret for signing tournament results is returned only once",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GameServerRequest"}}}},
+        "responses": {
+          "201": {"description": "Game server registered", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GameServer"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/webhooks": {
+      "get": {
+        "summary": "Registered webhooks in order they were registered, without their secrets",
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Webhooks", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/WebhookList"}}}}
+        }
+      },
+      "post": {
+        "summary": "Register webhook endpoint for events of given types, or of every type when none are given, its signing secret is returned only once",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/WebhookRequest"}}}},
+        "responses": {
+          "201": {"description": "Webhook registered", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Webhook"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/webhooks/{webhookId}": {
+      "delete": {
+        "summary": "Remove webhook together with its delivery log, pending deliveries are dropped",
+        "parameters": [{"$ref": "#/components/parameters/WebhookIdPath"}],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "204": {"description": "Webhook removed"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/webhooks/{webhookId}/deliveries": {
+      "get": {
+        "summary": "Webhook delivery log newest first, with attempts made and last response of each delivery, page after page",
+        "parameters": [
+          {"$ref": "#/components/parameters/WebhookIdPath"},
+          {"name": "status", "in": "query", "schema": {"$ref": "#/components/schemas/DeliveryStatus"}},
+          {"$ref": "#/components/parameters/CursorQuery"},
+          {"$ref": "#/components/parameters/LimitQuery"}
+        ],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Page of deliveries", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/DeliveryList"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/revenue": {
+      "get": {
+        "summary": "Net entry fees collected by house per tournament in single currency",
+        "parameters": [
+          {"name": "tournamentId", "in": "query", "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/CurrencyQuery"},
+          {"name": "from", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "to", "in": "query", "description": "exclusive", "schema": {"type": "string", "format": "date-time"}}
+        ],
+        "responses": {
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "200": {"description": "Revenue report", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RevenueReport"}}}},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v2/reset": {
+      "post": {
+        "summary": "Reset database, only registered when admin endpoints are enabled",
+        "responses": {"204": {"description": "Database reset"}, "401": {"$ref": "#/components/responses/Error"}, "403": {"$ref": "#/components/responses/Error"}}
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "apiKey": {"type": "apiKey", "in": "header", "name": "X-API-Key", "description": "operator keys can call every route, player keys can only see their own balance, read tournaments and join them as themselves without backers"},
+      "bearer": {"type": "http", "scheme": "bearer", "description": "same api key given as bearer token"},
+      "resultSignature": {"type": "apiKey", "in": "header", "name": "X-Signature", "description": "hex encoded HMAC-SHA256 keyed with game server secret of X-Timestamp, X-Nonce, method, path and raw body separated by new lines"}
+    },
+    "parameters": {
+      "PlayerIdQuery": {"name": "playerId", "in": "query", "required": true, "schema": {"type": "string"}},
+      "TournamentIdQuery": {"name": "tournamentId", "in": "query", "required": true, "schema": {"type": "string"}},
+      "PointsQuery": {"name": "points", "in": "query", "required": true, "schema": {"type": "number"}},
+      "CurrencyQuery": {"name": "currency", "in": "query", "description": "defaults to first configured currency", "schema": {"$ref": "#/components/schemas/Currency"}},
+      "IdempotencyKeyQuery": {"name": "idempotencyKey", "in": "query", "schema": {"type": "string", "maxLength": 128}},
+      "CursorQuery": {"name": "cursor", "in": "query", "description": "nextCursor of previous page", "schema": {"type": "string"}},
+      "FinishedFromQuery": {"name": "from", "in": "query", "description": "tournaments finished at or after", "schema": {"type": "string", "format": "date-time"}},
+      "FinishedToQuery": {"name": "to", "in": "query", "description": "tournaments finished before", "schema": {"type": "string", "format": "date-time"}},
+      "LeaderboardSortQuery": {"name": "sort", "in": "query", "schema": {"type": "string", "enum": ["winnings", "profit", "roi", "itm", "tournaments"], "default": "winnings"}},
+      "LimitQuery": {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100, "default": 20}},
+      "IdempotencyKeyHeader": {"name": "Idempotency-Key", "in": "header", "description": "repeated request with the same key replays first response", "schema": {"type": "string", "maxLength": 128}},
+      "TimestampHeader": {"name": "X-Timestamp", "in": "header", "description": "unix time in seconds, required with X-Signature, at most 5 minutes from server time", "schema": {"type": "string"}},
+      "NonceHeader": {"name": "X-Nonce", "in": "header", "description": "unique per game server, required with X-Signature", "schema": {"type": "string", "maxLength": 64}},
+      "PlayerIdPath": {"name": "playerId", "in": "path", "required": true, "schema": {"type": "string"}},
+      "TournamentIdPath": {"name": "tournamentId", "in": "path", "required": true, "schema": {"type": "string"}},
+      "WebhookIdPath": {"name": "webhookId", "in": "path", "required": true, "schema": {"type": "string"}}
+    },
+    "responses": {
+      "401": {"$ref": "#/components/responses/Error"},
+      "403": {"$ref": "#/components/responses/Error"},
+      "Error": {"description": "Error", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    },
+    "schemas": {
+      "Error": {
+        "type": "object", "required": ["error"], "additionalProperties": false,
+        "properties": {
+          "error": {
+            "type": "object", "required": ["code", "message"], "additionalProperties": false,
+            "properties": {
+              "code": {"type": "string", "enum": ["invalid_parameter", "player_not_found", "tournament_not_found", "entry_not_found", "not_found",
+                "insufficient_balance", "insufficient_house_balance", "invalid_state", "invalid_transition", "not_enough_entrants", "already_exists", "registration_deadline_passed",
+                "invalid_stakes", "invalid_placings", "prize_exceeds_pool", "player_not_entered", "constraint_violation", "request_in_progress", "idempotency_key_mismatch", "unauthorized", "forbidden", "invalid_signature", "nonce_reused", "game_server_required", "amount_out_of_range", "invalid_winners", "webhook_not_found", "internal_error"]},
+              "message": {"type": "string"},
+              "field": {"type": "string"},
+              "problems": {
+                "type": "array", "description": "problems of each winner or placing, given with invalid_winners and invalid_placings",
+                "items": {
+                  "type": "object", "required": ["index", "playerId", "code", "message"], "additionalProperties": false,
+                  "properties": {
+                    "index": {"type": "integer", "description": "position in winners or placings"},
+                    "playerId": {"type": "string"},
+                    "code": {"type": "string", "enum": ["player_not_entered", "duplicate_player", "negative_prize"]},
+                    "message": {"type": "string"}
+                  }
+                }
+              }
+            }
+          }
+        }
+      },
+      "Currency": {"type": "string", "maxLength": 8, "description": "one of configured currency codes, by default EUR, USD and CHIPS"},
+      "Player": {
+        "type": "object", "required": ["playerId", "balances"], "additionalProperties": false,
+        "properties": {
+          "playerId": {"type": "string"},
+          "balances": {"type": "object", "description": "balance per currency player has held", "additionalProperties": {"type": "number"}}
+        }
+      },
+      "Wallet": {
+        "type": "object", "required": ["playerId", "currency", "balance"], "additionalProperties": false,
+        "properties": {"playerId": {"type": "string"}, "currency": {"$ref": "#/components/schemas/Currency"}, "balance": {"type": "number"}}
+      },
+      "TransactionType": {"type": "string", "enum": ["fund", "take", "entry", "backing", "prize", "refund", "fee"]},
+      "Transaction": {
+        "type": "object", "required": ["id", "type", "currency", "amount", "balance", "createdAt"], "additionalProperties": false,
+        "properties": {
+          "id": {"type": "integer"},
+          "type": {"$ref": "#/components/schemas/TransactionType"},
+          "currency": {"$ref": "#/components/schemas/Currency"},
+          "amount": {"type": "number", "description": "negative when points were taken from balance"},
+          "balance": {"type": "number", "description": "balance in currency after the change"},
+          "tournamentId": {"type": "string"},
+          "createdAt": {"type": "string", "format": "date-time"}
+        }
+      },
+      "TransactionList": {
+        "type": "object", "required": ["transactions"], "additionalProperties": false,
+        "properties": {
+          "transactions": {"type": "array", "items": {"$ref": "#/components/schemas/Transaction"}},
+          "nextCursor": {"type": "string", "description": "only given when there are more transactions"}
+        }
+      },
+      "ResultList": {
+        "type": "object", "required": ["results"], "additionalProperties": false,
+        "properties": {
+          "results": {
+            "type": "array",
+            "items": {
+              "type": "object", "required": ["playerId", "cost", "prize"], "additionalProperties": false,
+              "properties": {
+                "playerId": {"type": "string"},
+                "backedPlayerId": {"type": "string", "description": "player whose entry was backed, not given for player own stake"},
+                "position": {"type": "integer", "description": "finishing position of entry, not given when player was not placed"},
+                "cost": {"type": "number"},
+                "prize": {"type": "number"}
+              }
+            }
+          }
+        }
+      },
+      "PlayerStatistics": {
+        "type": "object", "required": ["playerId", "tournaments", "inTheMoney", "itm", "cost", "winnings", "profit"], "additionalProperties": false,
+        "properties": {
+          "playerId": {"type": "string"},
+          "tournaments": {"type": "integer", "description": "finished tournaments played or backed in"},
+          "inTheMoney": {"type": "integer", "description": "tournaments in which any prize was won"},
+          "itm": {"type": "number", "description": "percentage of tournaments in the money"},
+          "cost": {"type": "number", "description": "deposits and fees charged"},
+          "winnings": {"type": "number", "description": "prizes won"},
+          "profit": {"type": "number"},
+          "roi": {"type": "number", "description": "profit in percent of cost, not given when nothing was charged"}
+        }
+      },
+      "Leaderboard": {
+        "type": "object", "required": ["currency", "sort", "players"], "additionalProperties": false,
+        "properties": {
+          "currency": {"$ref": "#/components/schemas/Currency"},
+          "sort": {"type": "string"},
+          "players": {"type": "array", "items": {"$ref": "#/components/schemas/PlayerStatistics"}}
+        }
+      },
+      "PlayerResults": {
+        "type": "object", "required": ["playerId", "currency", "player", "backer"], "additionalProperties": false,
+        "properties": {
+          "playerId": {"type": "string"},
+          "currency": {"$ref": "#/components/schemas/Currency"},
+          "player": {"$ref": "#/components/schemas/PlayerStatistics"},
+          "backer": {"$ref": "#/components/schemas/PlayerStatistics"}
+        }
+      },
+      "FundsRequest": {
+        "type": "object", "required": ["amount"],
+        "properties": {"amount": {"type": "number", "minimum": 0}, "currency": {"$ref": "#/components/schemas/Currency"}}
+      },
+      "Funds": {
+        "type": "object", "required": ["playerId", "currency", "amount", "balance"], "additionalProperties": false,
+        "properties": {"playerId": {"type": "string"}, "currency": {"$ref": "#/components/schemas/Currency"}, "amount": {"type": "number"}, "balance": {"type": "number"}}
+      },
+      "State": {"type": "string", "enum": ["announced", "registration_open", "registration_closed", "running", "finished", "cancelled"]},
+      "Payout": {"type": "string", "enum": ["winner_takes_all", "top3", "top15"]},
+      "TournamentRequest": {
+        "type": "object", "required": ["id", "deposit"],
+        "properties": {
+          "id": {"type": "string"},
+          "currency": {"$ref": "#/components/schemas/Currency"},
+          "deposit": {"type": "number"},
+          "fee": {"type": "number", "description": "entry fee credited to house account, paid on top of deposit unless feeIncluded"},
+          "feeIncluded": {"type": "boolean"},
+          "minEntrants": {"type": "integer"},
+          "maxEntrants": {"type": "integer", "description": "0 is unlimited"},
+          "payout": {"$ref": "#/components/schemas/Payout"},
+          "startsAt": {"type": "string", "format": "date-time"},
+          "registrationDeadline": {"type": "string", "format": "date-time"},
+          "autoCancel": {"type": "boolean"},
+          "gameServerId": {"type": "string", "description": "game server which has to sign tournament results"}
+        }
+      },
+      "Tournament": {
+        "type": "object", "additionalProperties": false,
+        "required": ["id", "state", "currency", "deposit", "fee", "feeIncluded", "prizePool", "payout", "minEntrants", "maxEntrants", "autoCancel", "entrants", "createdAt"],
+        "properties": {
+          "id": {"type": "string"},
+          "state": {"$ref": "#/components/schemas/State"},
+          "currency": {"$ref": "#/components/schemas/Currency"},
+          "deposit": {"type": "number"},
+          "fee": {"type": "number"},
+          "feeIncluded": {"type": "boolean"},
+          "prizePool": {"type": "number"},
+          "payout": {"$ref": "#/components/schemas/Payout"},
+          "minEntrants": {"type": "integer"},
+          "maxEntrants": {"type": "integer"},
+          "startsAt": {"type": "string", "format": "date-time"},
+          "registrationDeadline": {"type": "string", "format": "date-time"},
+          "autoCancel": {"type": "boolean"},
+          "gameServerId": {"type": "string"},
+          "entrants": {"type": "integer", "description": "seated players, backers are not counted"},
+          "createdAt": {"type": "string", "format": "date-time"}
+        }
+      },
+      "TournamentList": {
+        "type": "object", "required": ["tournaments"], "additionalProperties": false,
+        "properties": {
+          "tournaments": {"type": "array", "items": {"$ref": "#/components/schemas/Tournament"}},
+          "nextCursor": {"type": "string", "description": "only given when there are more tournaments"}
+        }
+      },
+      "StateRequest": {
+        "type": "object", "required": ["state"],
+        "properties": {"state": {"type": "string", "enum": ["registration_open", "registration_closed", "running", "cancelled"]}}
+      },
+      "EntryRequest": {
+        "type": "object", "required": ["playerId"],
+        "properties": {
+          "playerId": {"type": "string"},
+          "backers": {
+            "type": "array",
+            "items": {
+              "type": "object", "required": ["playerId"],
+              "properties": {
+                "playerId": {"type": "string"},
+                "share": {"type": "number", "description": "percentage of entry cost"},
+                "amount": {"type": "number", "description": "points of entry cost"}
+              }
+            }
+          }
+        }
+      },
+      "Entry": {
+        "type": "object", "required": ["tournamentId", "playerId", "waitlisted", "stakes"], "additionalProperties": false,
+        "properties": {
+          "tournamentId": {"type": "string"},
+          "playerId": {"type": "string"},
+          "waitlisted": {"type": "boolean"},
+          "stakes": {
+            "type": "array",
+            "items": {
+              "type": "object", "required": ["playerId", "amount", "share"], "additionalProperties": false,
+              "properties": {"playerId": {"type": "string"}, "amount": {"type": "number"}, "share": {"type": "number", "description": "percentage of entry"}}
+            }
+          }
+        }
+      },
+      "EntryList": {
+        "type": "object", "required": ["entries"], "additionalProperties": false,
+        "properties": {"entries": {"type": "array", "items": {"$ref": "#/components/schemas/Entry"}}}
+      },
+      "Placings": {"type": "array", "description": "ranked finishing order of entrants, prize pool is paid out by tournament payout structure", "items": {"type": "string"}},
+      "Winners": {
+        "type": "array", "description": "winners with prizes in points, alternative to placings",
+        "items": {
+          "type": "object", "required": ["playerId", "prize"],
+          "properties": {"playerId": {"type": "string"}, "prize": {"type": "number", "description": "at most 2 decimal places"}}
+        }
+      },
+      "ResultsRequest": {
+        "type": "object", "required": ["tournamentId"],
+        "properties": {
+          "tournamentId": {"type": "string"},
+          "placings": {"$ref": "#/components/schemas/Placings"},
+          "winners": {"$ref": "#/components/schemas/Winners"}
+        }
+      },
+      "TournamentResults": {
+        "type": "object",
+        "properties": {
+          "placings": {"$ref": "#/components/schemas/Placings"},
+          "winners": {"$ref": "#/components/schemas/Winners"}
+        }
+      },
+      "GameServerRequest": {
+        "type": "object", "required": ["id"],
+        "properties": {"id": {"type": "string", "maxLength": 64}}
+      },
+      "GameServer": {
+        "type": "object", "required": ["id", "secret"], "additionalProperties": false,
+        "properties": {"id": {"type": "string"}, "secret": {"type": "string"}}
+      },
+      "WebhookEventType": {"type": "string", "enum": ["tournament.announced", "entry.accepted", "tournament.finished", "prize.credited", "funds.added", "funds.taken"]},
+      "WebhookRequest": {
+        "type": "object", "required": ["id", "url"],
+        "properties": {
+          "id": {"type": "string", "maxLength": 64},
+          "url": {"type": "string", "format": "uri", "description": "absolute http or https url events are posted to"},
+          "events": {"type": "array", "items": {"$ref": "#/components/schemas/WebhookEventType"}, "description": "every event type when not given"}
+        }
+      },
+      "Webhook": {
+        "type": "object", "required": ["id", "url", "events", "createdAt"], "additionalProperties": false,
+        "properties": {
+          "id": {"type": "string"},
+          "url": {"type": "string"},
+          "secret": {
+            "type": "string",
+            "description": "only given on registration, keys hex encoded HMAC-SHA256 X-Signature of X-Timestamp and raw event body separated by new line"
+          },
+          "events": {"type": "array", "items": {"$ref": "#/components/schemas/WebhookEventType"}},
+          "createdAt": {"type": "string", "format": "date-time"}
+        }
+      },
+      "WebhookList": {
+        "type": "object", "required": ["webhooks"], "additionalProperties": false,
+        "properties": {"webhooks": {"type": "array", "items": {"$ref": "#/components/schemas/Webhook"}}}
+      },
+      "WebhookEvent": {
+        "type": "object", "required": ["id", "type", "createdAt", "data"], "additionalProperties": false,
+        "properties": {
+          "id": {"type": "string", "description": "same for every webhook event is delivered to"},
+          "type": {"$ref": "#/components/schemas/WebhookEventType"},
+          "createdAt": {"type": "string", "format": "date-time"},
+          "data": {"type": "object", "description": "tournament, entry, prize credited to player or player balance change, depending on type"}
+        }
+      },
+      "DeliveryStatus": {"type": "string", "enum": ["pending", "delivered", "failed"]},
+      "Delivery": {
+        "type": "object", "required": ["id", "webhookId", "eventId", "eventType", "status", "attempts", "createdAt", "event"], "additionalProperties": false,
+        "properties": {
+          "id": {"type": "integer"},
+          "webhookId": {"type": "string"},
+          "eventId": {"type": "string"},
+          "eventType": {"$ref": "#/components/schemas/WebhookEventType"},
+          "status": {"$ref": "#/components/schemas/DeliveryStatus"},
+          "attempts": {"type": "integer"},
+          "nextAttemptAt": {"type": "string", "format": "date-time", "description": "only given for pending delivery"},
+          "lastStatusCode": {"type": "integer", "description": "http status of last response, not given when endpoint did not respond"},
+          "lastError": {"type": "string", "description": "why last attempt failed"},
+          "createdAt": {"type": "string", "format": "date-time"},
+          "deliveredAt": {"type": "string", "format": "date-time"},
+          "event": {"$ref": "#/components/schemas/WebhookEvent"}
+        }
+      },
+      "DeliveryList": {
+        "type": "object", "required": ["deliveries"], "additionalProperties": false,
+        "properties": {
+          "deliveries": {"type": "array", "items": {"$ref": "#/components/schemas/Delivery"}},
+          "nextCursor": {"type": "string", "description": "only given when there are more deliveries"}
+        }
+      },
+      "RevenueReport": {
+        "type": "object", "required": ["currency", "total", "tournaments"], "additionalProperties": false,
+        "properties": {
+          "currency": {"$ref": "#/components/schemas/Currency"},
+          "total": {"type": "number"},
+          "tournaments": {
+            "type": "array",
+            "items": {
+              "type": "object", "required": ["tournamentId", "revenue"], "additionalProperties": false,
+              "properties": {"tournamentId": {"type": "string"}, "revenue": {"type": "number"}}
+            }
+          }
+        }
+      }
+    }
+  }
+}
+`
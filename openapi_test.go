@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenAPI(t *testing.T) {
+	db := newTestStore(t)
+	db.ResetDatabase()
+	defer db.ResetDatabase()
+	config := defaultConfig()
+	config.AdminEnabled = true
+	router := newRouter(&Handlers{repo: db, config: config})
+	createTestAPIKey(db, testOperatorKey, ScopeOperator, "")
+	createTestGameServer(db)
+
+	checker := loadSpecChecker(t, router, testOperatorKey)
+
+	Convey("Given openapi specification and router with every route registered", t, func() {
+		Convey("Every registered route should be described and every described operation registered", func() {
+			registered := make(map[string]bool)
+			chi.Walk(router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+				registered[method+" "+strings.TrimSuffix(route, "/")] = true
+				return nil
+			})
+			var undescribed, unregistered []string
+			for v := range registered {
+				if !checker.has(v) {
+					undescribed = append(undescribed, v)
+				}
+			}
+			for _, v := range checker.operations() {
+				if !registered[v] {
+					unregistered = append(unregistered, v)
+				}
+			}
+			So(undescribed, ShouldBeEmpty)
+			So(unregistered, ShouldBeEmpty)
+		})
+		Convey("Given spec is requested", func() {
+			w := checker.call("GET", "/openapi.json", "")
+			Convey("It should be served as is", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldEqual, openAPISpec)
+			})
+		})
+		Convey("Given legacy routes are called through whole tournament", func() {
+			checker.errors = nil
+			checker.call("GET", "/fund?playerId=O1&points=100", "")
+			checker.call("GET", "/fund?playerId=O2&points=100&idempotencyKey=openapi-fund", "")
+			checker.call("GET", "/take?playerId=O1&points=1000", "")
+			checker.call("GET", "/take?playerId=O1&points=1", "")
+			checker.call("GET", "/announceTournament?tournamentId=O1&deposit=10&fee=1&payout=top3&maxEntrants=1&gameServerId=test-server&startsAt="+url.QueryEscape(time.Now().Add(time.Hour).UTC().Format(time.RFC3339)), "")
+			checker.call("GET", "/announceTournament?tournamentId=O1&deposit=10", "")
+			checker.call("GET", "/openRegistration?tournamentId=O1", "")
+			checker.call("GET", "/joinTournament?tournamentId=O1&playerId=O1", "")
+			checker.call("GET", "/joinTournament?tournamentId=O1&playerId=O2&backerId=O1&backerShare=50", "")
+			checker.call("GET", "/leaveTournament?tournamentId=O1&playerId=O1", "")
+			checker.call("GET", "/closeRegistration?tournamentId=O1", "")
+			checker.call("GET", "/startTournament?tournamentId=O1", "")
+			for _, body := range []string{`{"tournamentId": "O1", "placings": ["O2", "O2"]}`, `{"tournamentId": "O1", "winners": [{"playerId": "O9", "prize": -1}]}`, `{"tournamentId": "O1", "placings": ["O2"]}`} {
+				checker.callWithHeaders("POST", "/resultTournament", body, testResultHeaders("/resultTournament", body))
+			}
+			checker.call("POST", "/resultTournament", `{"tournamentId": "O1", "placings": ["O2"]}`)
+			checker.call("GET", "/announceTournament?tournamentId=O2&deposit=10", "")
+			checker.call("GET", "/cancelTournament?tournamentId=O2", "")
+			checker.call("GET", "/fund?playerId=O1&points=5&currency=CHIPS", "")
+			checker.call("GET", "/balance?playerId=O1", "")
+			checker.call("GET", "/balance?playerId=O1&currency=CHIPS", "")
+			checker.call("GET", "/balance?playerId=O1&currency=GBP", "")
+			checker.call("GET", "/balance?playerId=O3", "")
+			checker.call("GET", "/revenue?tournamentId=O1&currency=EUR", "")
+			Convey("Requests and responses should match specification", func() {
+				So(checker.errors, ShouldBeEmpty)
+			})
+		})
+		Convey("Given v2 routes are called through whole tournament", func() {
+			checker.errors = nil
+			checker.call("POST", "/v2/webhooks", `{"id": "O1", "url": "http://localhost/events"}`)
+			checker.call("POST", "/v2/webhooks", `{"id": "O2", "url": "https://localhost/events", "events": ["tournament.finished", "prize.credited"]}`)
+			checker.call("POST", "/v2/webhooks", `{"id": "O3", "url": "localhost"}`)
+			checker.call("POST", "/v2/players/O1/deposits", `{"amount": 50}`)
+			checker.call("POST", "/v2/game-servers", `{"id": "O1"}`)
+			checker.call("POST", "/v2/players/O1/withdrawals", `{"amount": 10.5}`)
+			checker.call("POST", "/v2/players/O1/deposits", `{"amount": 20, "currency": "USD"}`)
+			checker.call("POST", "/v2/players/O3/withdrawals", `{"amount": 1}`)
+			checker.call("GET", "/v2/players/O1", "")
+			w := checker.call("GET", "/v2/players/O1/transactions?limit=1", "")
+			checker.call("GET", "/v2/players/O1/transactions?type=fund&type=take&currency=EUR&cursor="+decodeMap(w)["nextCursor"].(string), "")
+			checker.call("GET", "/v2/players/O1/transactions?type=bonus", "")
+			checker.call("GET", "/v2/players/O3/transactions", "")
+			checker.call("POST", "/v2/tournaments", `{"id": "O3", "deposit": 10, "fee": 1, "feeIncluded": true, "maxEntrants": 1, "payout": "top15", "gameServerId": "test-server"}`)
+			checker.call("POST", "/v2/tournaments", `{"id": "O4", "deposit": -10}`)
+			checker.call("POST", "/v2/tournaments", `{"id": "O6", "deposit": 10, "currency": "USD"}`)
+			checker.call("GET", "/v2/tournaments/O3", "")
+			checker.call("GET", "/v2/tournaments/O5", "")
+			checker.call("PUT", "/v2/tournaments/O3/state", `{"state": "registration_open"}`)
+			checker.call("POST", "/v2/tournaments/O3/entries", `{"playerId": "O1"}`)
+			checker.call("POST", "/v2/tournaments/O3/entries", `{"playerId": "O2", "backers": [{"playerId": "O1", "amount": 2.5}]}`)
+			checker.call("GET", "/v2/tournaments/O3/entries", "")
+			checker.call("GET", "/v2/tournaments/O5/entries", "")
+			checker.call("DELETE", "/v2/tournaments/O3/entries/O1", "")
+			checker.call("DELETE", "/v2/tournaments/O3/entries/O1", "")
+			checker.call("PUT", "/v2/tournaments/O3/state", `{"state": "running"}`)
+			checker.call("PUT", "/v2/tournaments/O3/state", `{"state": "registration_closed"}`)
+			checker.call("PUT", "/v2/tournaments/O3/state", `{"state": "running"}`)
+			results := `{"winners": [{"playerId": "O2", "prize": 9}]}`
+			checker.callWithHeaders("POST", "/v2/tournaments/O3/results", results, testResultHeaders("/v2/tournaments/O3/results", results))
+			w = checker.call("GET", "/v2/tournaments?limit=1", "")
+			checker.call("GET", "/v2/tournaments?state=finished&cursor="+decodeMap(w)["nextCursor"].(string), "")
+			checker.call("GET", "/v2/tournaments?limit=1000", "")
+			checker.call("GET", "/v2/revenue?from="+url.QueryEscape(time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)), "")
+			checker.call("GET", "/v2/revenue?from=yesterday", "")
+			checker.call("GET", "/v2/tournaments/O3/results", "")
+			checker.call("GET", "/v2/tournaments/O5/results", "")
+			checker.call("GET", "/v2/leaderboards/players", "")
+			checker.call("GET", "/v2/leaderboards/backers?sort=roi&currency=EUR&limit=5", "")
+			checker.call("GET", "/v2/leaderboards/players?sort=luck", "")
+			checker.call("GET", "/v2/players/O2/statistics", "")
+			checker.call("GET", "/v2/players/O9/statistics", "")
+			checker.call("GET", "/v2/players/O2/statistics?from=yesterday", "")
+			checker.call("GET", "/v2/webhooks", "")
+			w = checker.call("GET", "/v2/webhooks/O1/deliveries?limit=1", "")
+			checker.call("GET", "/v2/webhooks/O1/deliveries?status=pending&cursor="+decodeMap(w)["nextCursor"].(string), "")
+			checker.call("GET", "/v2/webhooks/O1/deliveries?status=lost", "")
+			checker.call("GET", "/v2/webhooks/O9/deliveries", "")
+			checker.call("DELETE", "/v2/webhooks/O2", "")
+			checker.call("DELETE", "/v2/webhooks/O2", "")
+			Convey("Requests and responses should match specification", func() {
+				So(checker.errors, ShouldBeEmpty)
+			})
+		})
+		Convey("Given database is reset through both admin routes", func() {
+			checker.errors = nil
+			checker.call("POST", "/v2/reset", "")
+			checker.call("GET", "/reset", "")
+			Convey("Every described operation should have been called", func() {
+				So(checker.errors, ShouldBeEmpty)
+				var uncalled []string
+				for _, v := range checker.operations() {
+					if !checker.called[v] {
+						uncalled = append(uncalled, v)
+					}
+				}
+				So(uncalled, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+//specChecker calls handler with api key and checks requests and responses against openapi specification, mismatches are collected in errors
+type specChecker struct {
+	spec    map[string]interface{}
+	handler http.Handler
+	key     string
+	called  map[string]bool
+	errors  []string
+}
+
+//loadSpecChecker returns checker of openapi specification calling handler with api key
+func loadSpecChecker(t *testing.T, handler http.Handler, key string) *specChecker {
+	var spec map[string]interface{}
+	if err := json.Unmarshal([]byte(openAPISpec), &spec); err != nil {
+		t.Fatal(err)
+	}
+	return &specChecker{spec: spec, handler: handler, key: key, called: make(map[string]bool)}
+}
+
+//operations returns "METHOD /path" of every operation in specification
+func (c *specChecker) operations() []string {
+	var operations []string
+	for path, item := range c.spec["paths"].(map[string]interface{}) {
+		for method := range item.(map[string]interface{}) {
+			operations = append(operations, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+func (c *specChecker) has(operation string) bool {
+	for _, v := range c.operations() {
+		if v == operation {
+			return true
+		}
+	}
+	return false
+}
+
+//call sends request to handler, request is checked fully only when it succeeds, failed requests are only checked for undescribed parameters
+func (c *specChecker) call(method string, target string, body string) *httptest.ResponseRecorder {
+	return c.callWithHeaders(method, target, body, nil)
+}
+
+//callWithHeaders sends request with additional headers same as call
+func (c *specChecker) callWithHeaders(method string, target string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, target, bytes.NewBufferString(body))
+	if c.key != "" {
+		req.Header.Set(apiKeyHeader, c.key)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	c.handler.ServeHTTP(w, req)
+
+	name := method + " " + req.URL.Path
+	path, item := c.findPath(req.URL.Path)
+	if item == nil {
+		c.fail(name, "path is not described")
+		return w
+	}
+	operation, ok := item[strings.ToLower(method)].(map[string]interface{})
+	if !ok {
+		c.fail(name, "method is not described")
+		return w
+	}
+	c.called[method+" "+path] = true
+	success := w.Code < 300
+
+	parameters := make(map[string]map[string]interface{})
+	for _, v := range list(operation["parameters"]) {
+		parameter := c.resolve(v)
+		if parameter["in"] == "query" {
+			parameters[parameter["name"].(string)] = parameter
+		}
+	}
+	query := req.URL.Query()
+	for key, values := range query {
+		parameter, ok := parameters[key]
+		if !ok {
+			c.fail(name, "query parameter "+key+" is not described")
+			continue
+		}
+		if !success {
+			continue
+		}
+		schema := c.resolve(parameter["schema"])
+		if schema["type"] == "array" {
+			schema = c.resolve(schema["items"])
+		}
+		for _, v := range values {
+			c.check(name+" query "+key, schema, queryValue(schema, v))
+		}
+	}
+	for key, parameter := range parameters {
+		if success && parameter["required"] == true && query.Get(key) == "" {
+			c.fail(name, "required query parameter "+key+" is missing")
+		}
+	}
+	if success && body != "" {
+		if schema := c.contentSchema(operation["requestBody"]); schema == nil {
+			c.fail(name, "request body is not described")
+		} else {
+			c.check(name+" request", schema, decode(body))
+		}
+	}
+
+	responses := operation["responses"].(map[string]interface{})
+	response, ok := responses[strconv.Itoa(w.Code)]
+	if !ok {
+		c.fail(name, fmt.Sprintf("response status %d is not described", w.Code))
+		return w
+	}
+	if schema := c.contentSchema(response); schema != nil {
+		c.check(fmt.Sprintf("%s response %d", name, w.Code), schema, decode(w.Body.String()))
+	} else if w.Body.Len() > 0 {
+		c.fail(name, fmt.Sprintf("response %d body is not described", w.Code))
+	}
+	return w
+}
+
+//findPath finds path item matching request path, {param} segments match any segment
+func (c *specChecker) findPath(requestPath string) (string, map[string]interface{}) {
+	segments := strings.Split(requestPath, "/")
+	for path, item := range c.spec["paths"].(map[string]interface{}) {
+		templates := strings.Split(path, "/")
+		if len(templates) != len(segments) {
+			continue
+		}
+		matches := true
+		for i, v := range templates {
+			if v != segments[i] && !strings.HasPrefix(v, "{") {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return path, item.(map[string]interface{})
+		}
+	}
+	return "", nil
+}
+
+//contentSchema returns json schema of request body or response, nil when it has no content
+func (c *specChecker) contentSchema(node interface{}) map[string]interface{} {
+	if node == nil {
+		return nil
+	}
+	content, ok := c.resolve(node)["content"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	media, ok := content["application/json"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return c.resolve(media["schema"])
+}
+
+//resolve follows $ref to components of specification
+func (c *specChecker) resolve(node interface{}) map[string]interface{} {
+	object, _ := node.(map[string]interface{})
+	ref, ok := object["$ref"].(string)
+	if !ok {
+		return object
+	}
+	var target interface{} = c.spec
+	for _, v := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		target = target.(map[string]interface{})[v]
+	}
+	return c.resolve(target)
+}
+
+//check validates value against schema, supporting keywords used in specification
+func (c *specChecker) check(name string, schema map[string]interface{}, value interface{}) {
+	schema = c.resolve(schema)
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, v := range enum {
+			if v == value {
+				found = true
+			}
+		}
+		if !found {
+			c.fail(name, fmt.Sprintf("%v is not one of %v", value, enum))
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			c.fail(name, fmt.Sprintf("%v is not object", value))
+			return
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for _, v := range list(schema["required"]) {
+			if _, ok := object[v.(string)]; !ok {
+				c.fail(name, "required property "+v.(string)+" is missing")
+			}
+		}
+		for key, v := range object {
+			property, ok := properties[key]
+			if !ok {
+				if schema["additionalProperties"] == false {
+					c.fail(name, "property "+key+" is not described")
+				} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+					c.check(name+"."+key, additional, v)
+				}
+				continue
+			}
+			c.check(name+"."+key, c.resolve(property), v)
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			c.fail(name, fmt.Sprintf("%v is not array", value))
+			return
+		}
+		for i, v := range array {
+			c.check(fmt.Sprintf("%s[%d]", name, i), c.resolve(schema["items"]), v)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			c.fail(name, fmt.Sprintf("%v is not string", value))
+			return
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				c.fail(name, s+" is not date-time")
+			}
+		}
+		if maxLength, ok := schema["maxLength"].(float64); ok && len(s) > int(maxLength) {
+			c.fail(name, s+" is too long")
+		}
+	case "number", "integer":
+		n, ok := value.(float64)
+		if !ok {
+			c.fail(name, fmt.Sprintf("%v is not number", value))
+			return
+		}
+		if schema["type"] == "integer" && n != math.Trunc(n) {
+			c.fail(name, fmt.Sprintf("%v is not integer", value))
+		}
+		if minimum, ok := schema["minimum"].(float64); ok && n < minimum {
+			c.fail(name, fmt.Sprintf("%v is below minimum %v", value, minimum))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			c.fail(name, fmt.Sprintf("%v is not boolean", value))
+		}
+	}
+}
+
+func (c *specChecker) fail(name string, message string) {
+	c.errors = append(c.errors, name+": "+message)
+}
+
+//queryValue converts query string value to json value of schema type so it can be checked same as bodies
+func queryValue(schema map[string]interface{}, value string) interface{} {
+	switch schema["type"] {
+	case "number", "integer":
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+func decode(body string) interface{} {
+	var value interface{}
+	json.Unmarshal([]byte(body), &value)
+	return value
+}
+
+func list(node interface{}) []interface{} {
+	l, _ := node.([]interface{})
+	return l
+}
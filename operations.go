@@ -0,0 +1,390 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//Entry is result of joining tournament with stakes player and its backers were charged, or will be charged when waitlisted player gets a seat
+type Entry struct {
+	TournamentID string
+	PlayerID     string
+	Waitlisted   bool
+	Stakes       []Stake
+}
+
+//MarshalJSON is custom json marshaler to present stakes in points with their share of entry in percent
+func (e *Entry) MarshalJSON() ([]byte, error) {
+	type stake struct {
+		PlayerID string  `json:"playerId"`
+		Amount   Money   `json:"amount"`
+		Share    float64 `json:"share"`
+	}
+	total := 0
+	for _, v := range e.Stakes {
+		total += v.Amount
+	}
+	stakes := make([]stake, len(e.Stakes))
+	for i, v := range e.Stakes {
+		stakes[i] = stake{PlayerID: v.PlayerID, Amount: Money(v.Amount)}
+		if total > 0 {
+			stakes[i].Share = float64((v.Amount*10000+total/2)/total) / 100
+		}
+	}
+	return json.Marshal(&struct {
+		TournamentID string  `json:"tournamentId"`
+		PlayerID     string  `json:"playerId"`
+		Waitlisted   bool    `json:"waitlisted"`
+		Stakes       []stake `json:"stakes"`
+	}{
+		TournamentID: e.TournamentID,
+		PlayerID:     e.PlayerID,
+		Waitlisted:   e.Waitlisted,
+		Stakes:       stakes,
+	})
+}
+
+//newEntry returns entry of player with stakes of player followed by stakes of its backers
+func newEntry(tournamentID string, player Stake, backers []Stake, waitlisted bool) *Entry {
+	return &Entry{TournamentID: tournamentID, PlayerID: player.PlayerID, Waitlisted: waitlisted, Stakes: append([]Stake{player}, backers...)}
+}
+
+//fund adds points to player balance in currency, or default currency when none is given, creating player if it does not exist yet, and returns updated balance
+func (h *Handlers) fund(playerID string, currency string, points int) (*Wallet, *APIError) {
+	if playerID == "" {
+		return nil, invalidParameter("playerId", "playerId is required")
+	}
+	if points < 0 {
+		return nil, invalidParameter("points", "points must be non-negative number")
+	}
+	currency, apiErr := h.currency(currency)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	player, err := h.repo.FindOrCreatePlayer(playerID)
+	if err != nil {
+		return nil, errorFor(err)
+	}
+	if err := h.repo.AddFunds(player, currency, points); err != nil {
+		return nil, errorFor(err)
+	}
+	return h.wallet(playerID, currency)
+}
+
+//take deducts points from existing player balance in currency, or default currency when none is given, and returns updated balance
+func (h *Handlers) take(playerID string, currency string, points int) (*Wallet, *APIError) {
+	if playerID == "" {
+		return nil, invalidParameter("playerId", "playerId is required")
+	}
+	if points < 0 {
+		return nil, invalidParameter("points", "points must be non-negative number")
+	}
+	currency, apiErr := h.currency(currency)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	player, apiErr := h.player(playerID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if err := h.repo.TakeFunds(player, currency, points); err != nil {
+		return nil, errorFor(err)
+	}
+	return h.wallet(playerID, currency)
+}
+
+//player returns player by its id
+func (h *Handlers) player(playerID string) (*Player, *APIError) {
+	player, err := h.repo.FindPlayer(playerID)
+	if err != nil {
+		return nil, notFound(err, CodePlayerNotFound, "playerId")
+	}
+	return player, nil
+}
+
+//currency returns given currency code or default currency when none is given, error is returned for currency which is not configured
+func (h *Handlers) currency(currency string) (string, *APIError) {
+	if currency == "" {
+		return h.config.Currencies[0], nil
+	}
+	if !h.config.Currencies.contains(currency) {
+		return "", invalidParameter("currency", "currency must be one of "+strings.Join(h.config.Currencies, ", "))
+	}
+	return currency, nil
+}
+
+//wallet returns player balance in currency, or default currency when none is given
+func (h *Handlers) wallet(playerID string, currency string) (*Wallet, *APIError) {
+	currency, apiErr := h.currency(currency)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	player, apiErr := h.player(playerID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return player.wallet(currency), nil
+}
+
+//tournament returns tournament by its id
+func (h *Handlers) tournament(tournamentID string) (*Tournament, *APIError) {
+	if tournamentID == "" {
+		return nil, invalidParameter("tournamentId", "tournamentId is required")
+	}
+	tournament, err := h.repo.FindTournament(tournamentID)
+	if err != nil {
+		return nil, notFound(err, CodeTournamentNotFound, "tournamentId")
+	}
+	return tournament, nil
+}
+
+//announce validates new tournament against configuration and creates it, tournament without currency is run in default currency
+func (h *Handlers) announce(tournament *Tournament) *APIError {
+	if tournament.ID == "" {
+		return invalidParameter("tournamentId", "tournamentId is required")
+	}
+	currency, apiErr := h.currency(tournament.Currency)
+	if apiErr != nil {
+		return apiErr
+	}
+	tournament.Currency = currency
+	if tournament.Deposit <= 0 {
+		return invalidParameter("deposit", "deposit must be positive number")
+	}
+	if tournament.Deposit < int(h.config.MinDeposit) || (h.config.MaxDeposit > 0 && tournament.Deposit > int(h.config.MaxDeposit)) {
+		return invalidParameter("deposit", "deposit is outside of allowed range")
+	}
+	if tournament.Fee < 0 || (tournament.FeeIncluded && tournament.Fee >= tournament.Deposit) {
+		return invalidParameter("fee", "fee must be non-negative and less than deposit when included in it")
+	}
+	if tournament.MinEntrants < 0 || (tournament.MaxEntrants > 0 && tournament.MinEntrants > tournament.MaxEntrants) {
+		return invalidParameter("minEntrants", "minEntrants must be non-negative whole number not above maxEntrants")
+	}
+	if tournament.MaxEntrants < 0 {
+		return invalidParameter("maxEntrants", "maxEntrants must be non-negative whole number")
+	}
+	if tournament.AutoCancel && tournament.StartsAt == nil {
+		return invalidParameter("startsAt", "startsAt must be RFC3339 time and is required for autoCancel")
+	}
+	if tournament.RegistrationDeadline != nil && tournament.StartsAt != nil && tournament.RegistrationDeadline.After(*tournament.StartsAt) {
+		return invalidParameter("registrationDeadline", "registrationDeadline must be RFC3339 time not after startsAt")
+	}
+	if tournament.Payout == "" {
+		tournament.Payout = PayoutWinnerTakesAll
+	}
+	if !validPayout(tournament.Payout) {
+		return invalidParameter("payout", "payout must be one of winner_takes_all, top3, top15")
+	}
+	if tournament.GameServerID != "" {
+		if _, err := h.repo.FindGameServer(tournament.GameServerID); err == sql.ErrNoRows {
+			return invalidParameter("gameServerId", "gameServerId must be registered game server")
+		} else if err != nil {
+			return errorFor(err)
+		}
+	}
+	if err := h.repo.CreateTournament(tournament); err != nil {
+		return errorFor(err)
+	}
+	return nil
+}
+
+//join builds stakes of player and its backers and enters them into tournament, or puts them on waitlist when tournament is full
+func (h *Handlers) join(tournamentID string, playerID string, backerIDs []string, shares []string, amounts []string) (*Entry, *APIError) {
+	if tournamentID == "" {
+		return nil, invalidParameter("tournamentId", "tournamentId is required")
+	}
+	if playerID == "" {
+		return nil, invalidParameter("playerId", "playerId is required")
+	}
+	tournament, apiErr := h.tournament(tournamentID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if tournament.State != StateRegistrationOpen {
+		return nil, errorFor(ErrInvalidState)
+	}
+	if tournament.registrationPassed(time.Now()) {
+		return nil, errorFor(ErrDeadlinePassed)
+	}
+	player, backers, err := buildStakes(tournament.entryCost(), playerID, backerIDs, shares, amounts)
+	if err != nil {
+		return nil, &APIError{Status: http.StatusUnprocessableEntity, Code: CodeInvalidStakes, Message: err.Error(), Field: "backerId"}
+	}
+	waitlisted, err := h.repo.TournamentJoinPlayers(tournament, player, backers)
+	if err != nil {
+		return nil, errorFor(err)
+	}
+	return newEntry(tournamentID, player, backers, waitlisted), nil
+}
+
+//leave removes player entry or waitlist place from tournament while registration is open
+func (h *Handlers) leave(tournamentID string, playerID string) *APIError {
+	if tournamentID == "" {
+		return invalidParameter("tournamentId", "tournamentId is required")
+	}
+	if playerID == "" {
+		return invalidParameter("playerId", "playerId is required")
+	}
+	tournament, apiErr := h.tournament(tournamentID)
+	if apiErr != nil {
+		return apiErr
+	}
+	if tournament.State != StateRegistrationOpen {
+		return errorFor(ErrInvalidState)
+	}
+	if err := h.repo.LeaveTournament(tournament, playerID); err != nil {
+		return notFound(err, CodeEntryNotFound, "playerId")
+	}
+	return nil
+}
+
+//changeState moves tournament to given state, moving it to cancelled state refunds its entries, and returns updated tournament
+func (h *Handlers) changeState(tournamentID string, state string) (*Tournament, *APIError) {
+	tournament, apiErr := h.tournament(tournamentID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	var err error
+	if state == StateCancelled {
+		err = h.repo.CancelTournament(tournament)
+	} else {
+		err = h.repo.TransitionTournament(tournament, state)
+	}
+	if err != nil {
+		return nil, errorFor(err)
+	}
+	return h.tournament(tournamentID)
+}
+
+//finish pays out prizes of running tournament by placings or winners and returns finished tournament
+func (h *Handlers) finish(results ResultsRequest) (*Tournament, *APIError) {
+	if len(results.Placings) > 0 && len(results.Winners) > 0 {
+		return nil, invalidParameter("placings", "either placings or winners can be given")
+	}
+	tournament, apiErr := h.tournament(results.TournamentID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if tournament.State != StateRunning {
+		return nil, errorFor(ErrInvalidState)
+	}
+	if err := h.repo.FinishTournament(tournament, results.Placings, results.Winners); err != nil {
+		return nil, errorFor(err)
+	}
+	return h.tournament(results.TournamentID)
+}
+
+//revenue builds report of entry fees in currency collected by house
+func (h *Handlers) revenue(tournamentID string, currency string, from *time.Time, to *time.Time) (*RevenueReport, *APIError) {
+	revenue, err := h.repo.Revenue(tournamentID, currency, from, to)
+	if err != nil {
+		return nil, errorFor(err)
+	}
+	report := &RevenueReport{Currency: currency, Tournaments: make([]TournamentRevenueReport, 0, len(revenue))}
+	total := 0
+	for _, v := range revenue {
+		report.Tournaments = append(report.Tournaments, TournamentRevenueReport{TournamentID: v.TournamentID, Revenue: Money(v.Amount)})
+		total += v.Amount
+	}
+	report.Total = Money(total)
+	return report, nil
+}
+
+//tournaments lists page of tournaments matching filter, next page cursor is returned when there are more tournaments after the page
+func (h *Handlers) tournaments(filter TournamentFilter) (*TournamentList, *APIError) {
+	limit := filter.Limit
+	filter.Limit++
+	tournaments, err := h.repo.ListTournaments(filter)
+	if err != nil {
+		return nil, errorFor(err)
+	}
+	list := &TournamentList{Tournaments: make([]Tournament, 0, len(tournaments))}
+	if len(tournaments) > limit {
+		tournaments = tournaments[:limit]
+		last := tournaments[limit-1]
+		list.NextCursor = encodeCursor(TournamentCursor{At: last.listedAt(), ID: last.ID})
+	}
+	list.Tournaments = append(list.Tournaments, tournaments...)
+	return list, nil
+}
+
+//entries returns entries of tournament with stakes of each player and its backers
+func (h *Handlers) entries(tournamentID string) ([]Entry, *APIError) {
+	if _, e := h.tournament(tournamentID); e != nil {
+		return nil, e
+	}
+	entries, err := h.repo.TournamentEntries(tournamentID)
+	if err != nil {
+		return nil, errorFor(err)
+	}
+	return entries, nil
+}
+
+//history lists page of player transactions matching filter, next page cursor is returned when there are more transactions after the page
+func (h *Handlers) history(filter HistoryFilter) (*TransactionList, *APIError) {
+	if _, e := h.player(filter.PlayerID); e != nil {
+		return nil, e
+	}
+	limit := filter.Limit
+	filter.Limit++
+	history, err := h.repo.PlayerHistory(filter)
+	if err != nil {
+		return nil, errorFor(err)
+	}
+	list := &TransactionList{Transactions: make([]LedgerEntry, 0, len(history))}
+	if len(history) > limit {
+		history = history[:limit]
+		list.NextCursor = encodeIDCursor(history[limit-1].ID)
+	}
+	list.Transactions = append(list.Transactions, history...)
+	return list, nil
+}
+
+//results returns results of every stake in tournament, which are only known once tournament is finished
+func (h *Handlers) results(tournamentID string) ([]TournamentResult, *APIError) {
+	if _, e := h.tournament(tournamentID); e != nil {
+		return nil, e
+	}
+	results, err := h.repo.TournamentResults(tournamentID)
+	if err != nil {
+		return nil, errorFor(err)
+	}
+	return results, nil
+}
+
+//leaderboard ranks players by results of their own entries, or of their backing stakes, in tournaments of single currency
+func (h *Handlers) leaderboard(filter LeaderboardFilter) (*Leaderboard, *APIError) {
+	statistics, err := h.repo.Leaderboard(filter)
+	if err != nil {
+		return nil, errorFor(err)
+	}
+	board := &Leaderboard{Currency: filter.Currency, Sort: filter.Sort, Players: make([]PlayerStatistics, 0, len(statistics))}
+	board.Players = append(board.Players, statistics...)
+	return board, nil
+}
+
+//statistics sums results of player own entries and of its backing stakes in tournaments of currency finished in time range [from, to)
+func (h *Handlers) statistics(playerID string, currency string, from *time.Time, to *time.Time) (*PlayerResults, *APIError) {
+	if _, e := h.player(playerID); e != nil {
+		return nil, e
+	}
+	results := &PlayerResults{PlayerID: playerID, Currency: currency, Player: PlayerStatistics{PlayerID: playerID}, Backer: PlayerStatistics{PlayerID: playerID}}
+	for _, backers := range []bool{false, true} {
+		statistics, err := h.repo.Leaderboard(LeaderboardFilter{Backers: backers, Currency: currency, PlayerID: playerID, From: from, To: to, Limit: 1})
+		if err != nil {
+			return nil, errorFor(err)
+		}
+		if len(statistics) == 0 {
+			continue
+		}
+		if backers {
+			results.Backer = statistics[0]
+		} else {
+			results.Player = statistics[0]
+		}
+	}
+	return results, nil
+}
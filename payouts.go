@@ -0,0 +1,202 @@
+package main
+
+//Payout structures decide how tournament prize pool is split between finishing places
+const (
+	PayoutWinnerTakesAll = "winner_takes_all"
+	PayoutTop3           = "top3"
+	PayoutTop15Percent   = "top15"
+)
+
+//top3Payout is percentage of prize pool for first, second and third place
+var top3Payout = []int{50, 30, 20}
+
+//slidingPayout holds percentages of prize pool by number of paid places for top 15% structure, each row adds up to 100
+var slidingPayout = [][]int{
+	{100},
+	{65, 35},
+	{50, 30, 20},
+	{40, 27, 19, 14},
+	{36, 24, 17, 13, 10},
+	{33, 22, 16, 12, 9, 8},
+	{31, 20, 15, 11, 9, 7, 7},
+	{29, 19, 14, 11, 8, 7, 6, 6},
+	{28, 18, 13, 10, 8, 7, 6, 5, 5},
+	{27, 17, 12, 9, 8, 7, 6, 5, 5, 4},
+}
+
+//Prize is amount of points won by player, it is split between player and its backers by their stakes
+type Prize struct {
+	PlayerID string
+	Amount   int
+}
+
+//validPayout tells if payout structure is known
+func validPayout(payout string) bool {
+	return payout == PayoutWinnerTakesAll || payout == PayoutTop3 || payout == PayoutTop15Percent
+}
+
+//payoutPercentages returns percentages of prize pool for each paid place, there are never more paid places than entrants
+func payoutPercentages(payout string, entrants int) []int {
+	var percentages []int
+	switch payout {
+	case PayoutTop3:
+		percentages = top3Payout
+	case PayoutTop15Percent:
+		paid := (entrants*15 + 99) / 100
+		if paid > len(slidingPayout) {
+			paid = len(slidingPayout)
+		}
+		if paid == 0 {
+			return nil
+		}
+		percentages = slidingPayout[paid-1]
+	default:
+		percentages = []int{100}
+	}
+	if len(percentages) > entrants {
+		percentages = percentages[:entrants]
+	}
+	return percentages
+}
+
+//payoutPrizes turns ranked finishing order into prizes using payout structure, whole prize pool is paid out,
+//when there are fewer entrants than paid places their percentages are scaled up
+func payoutPrizes(payout string, pool int, entrants int, placings []string) ([]Prize, error) {
+	seen := make(map[string]bool)
+	for _, v := range placings {
+		if v == "" || seen[v] {
+			return nil, ErrInvalidPlacings
+		}
+		seen[v] = true
+	}
+	percentages := payoutPercentages(payout, entrants)
+	if len(placings) < len(percentages) {
+		return nil, ErrInvalidPlacings
+	}
+
+	weights := make([]Stake, len(percentages))
+	for i, v := range percentages {
+		weights[i] = Stake{PlayerID: placings[i], Amount: v}
+	}
+	amounts := splitByStakes(pool, weights)
+	prizes := make([]Prize, len(weights))
+	for i, v := range weights {
+		prizes[i] = Prize{PlayerID: v.PlayerID, Amount: amounts[i]}
+	}
+	return prizes, nil
+}
+
+//tournamentPrizes returns prizes for tournament result, computed from placings by tournament payout structure or taken from winners,
+//in which case they can not add up to more than prize pool, results are validated against entrants before any prize is computed
+func tournamentPrizes(tournament *Tournament, pool int, entrants map[string]bool, placings []string, winners []Winner) ([]Prize, error) {
+	if len(placings) > 0 {
+		if err := validatePlacings(entrants, placings); err != nil {
+			return nil, err
+		}
+		return payoutPrizes(tournament.Payout, pool, len(entrants), placings)
+	}
+	if err := validateWinners(entrants, winners); err != nil {
+		return nil, err
+	}
+	total := 0
+	prizes := make([]Prize, len(winners))
+	for i, v := range winners {
+		prizes[i] = Prize{PlayerID: v.PlayerID, Amount: int(v.Prize)}
+		total += prizes[i].Amount
+	}
+	if total > pool {
+		return nil, ErrPrizeExceedsPool
+	}
+	return prizes, nil
+}
+
+//ResultProblem is single problem of winner or placing at index of tournament result
+type ResultProblem struct {
+	Index    int    `json:"index"`
+	PlayerID string `json:"playerId"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+//ResultsError is returned when winners or placings of tournament result are invalid, it holds problems of every offending winner or placing
+type ResultsError struct {
+	Field    string
+	Problems []ResultProblem
+}
+
+func (e *ResultsError) Error() string {
+	return e.Field + " do not match tournament entries"
+}
+
+//validateWinners checks that every winner has its own entry in tournament, not only backing one, appears only once and has non-negative prize
+func validateWinners(entrants map[string]bool, winners []Winner) error {
+	var problems []ResultProblem
+	seen := make(map[string]bool)
+	for i, v := range winners {
+		if !entrants[v.PlayerID] {
+			problems = append(problems, ResultProblem{i, v.PlayerID, CodePlayerNotEntered, "winner has no entry in tournament"})
+		}
+		if seen[v.PlayerID] {
+			problems = append(problems, ResultProblem{i, v.PlayerID, CodeDuplicatePlayer, "winner is given more than once"})
+		}
+		if v.Prize < 0 {
+			problems = append(problems, ResultProblem{i, v.PlayerID, CodeNegativePrize, "prize can not be negative"})
+		}
+		seen[v.PlayerID] = true
+	}
+	if len(problems) > 0 {
+		return &ResultsError{Field: "winners", Problems: problems}
+	}
+	return nil
+}
+
+//validatePlacings checks that every placed player has its own entry in tournament, distinct and complete placings are checked by payoutPrizes
+func validatePlacings(entrants map[string]bool, placings []string) error {
+	var problems []ResultProblem
+	for i, v := range placings {
+		if v != "" && !entrants[v] {
+			problems = append(problems, ResultProblem{i, v, CodePlayerNotEntered, "placed player has no entry in tournament"})
+		}
+	}
+	if len(problems) > 0 {
+		return &ResultsError{Field: "placings", Problems: problems}
+	}
+	return nil
+}
+
+//finishingPositions returns position of each placed player counted from 1, players are ranked by placings or by order winners are given in
+func finishingPositions(placings []string, winners []Winner) map[string]int {
+	positions := make(map[string]int)
+	if len(placings) > 0 {
+		for i, v := range placings {
+			positions[v] = i + 1
+		}
+		return positions
+	}
+	for i, v := range winners {
+		positions[v.PlayerID] = i + 1
+	}
+	return positions
+}
+
+//stakePrize identifies prize paid for stake of user in entry of entrant
+type stakePrize struct {
+	userID    string
+	entrantID string
+}
+
+//tournamentResults pairs every stake of tournament entries with position of its entrant and prize paid for it
+func tournamentResults(tournamentID string, rows []entryRow, positions map[string]int, paid map[stakePrize]int) []TournamentResult {
+	results := make([]TournamentResult, len(rows))
+	for i, v := range rows {
+		entrantID := v.UserID
+		if v.BackingID != "" {
+			entrantID = v.BackingID
+		}
+		results[i] = TournamentResult{TournamentID: tournamentID, UserID: v.UserID, BackingID: v.BackingID, Cost: v.Amount, Prize: paid[stakePrize{v.UserID, entrantID}]}
+		if position, ok := positions[entrantID]; ok {
+			results[i].Position = &position
+		}
+	}
+	return results
+}
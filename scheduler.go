@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+//Scheduler periodically closes registration, starts tournaments or cancels and refunds them when their scheduled times pass
+type Scheduler struct {
+	repo     Datastore
+	interval time.Duration
+	//waiting holds tournaments with closed registration which can not start for too few entrants, they are left to operator
+	waiting map[string]bool
+}
+
+//NewScheduler creates scheduler which checks tournaments every interval
+func NewScheduler(repo Datastore, interval time.Duration) *Scheduler {
+	return &Scheduler{repo: repo, interval: interval}
+}
+
+//Run checks scheduled tournaments every interval until stop channel is closed
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if err := s.Tick(now); err != nil {
+				log.Println("Scheduler:", err)
+			}
+		}
+	}
+}
+
+//Tick moves every tournament whose registration deadline or start time is before now to its next state
+func (s *Scheduler) Tick(now time.Time) error {
+	tournaments, err := s.repo.ScheduledTournaments(now)
+	if err != nil {
+		return err
+	}
+	waiting := map[string]bool{}
+	for i := range tournaments {
+		tournament := &tournaments[i]
+		if s.waiting[tournament.ID] && tournament.State == StateRegistrationClosed {
+			waiting[tournament.ID] = true
+			continue
+		}
+		err := s.advance(tournament, now)
+		if err == ErrNotEnoughEntrants {
+			log.Printf("Scheduler: tournament %s has too few entrants to start, it is left for operator to cancel", tournament.ID)
+			waiting[tournament.ID] = true
+		} else if err != nil {
+			log.Printf("Scheduler: tournament %s: %v", tournament.ID, err)
+		}
+	}
+	s.waiting = waiting
+	return nil
+}
+
+//advance closes registration after deadline, and at start time starts tournament or cancels it when it has too few entrants and auto cancel is set,
+//without auto cancel ErrNotEnoughEntrants is returned
+func (s *Scheduler) advance(tournament *Tournament, now time.Time) error {
+	if tournament.State == StateRegistrationOpen && tournament.registrationPassed(now) {
+		if err := s.repo.TransitionTournament(tournament, StateRegistrationClosed); err != nil {
+			return err
+		}
+	}
+	if tournament.StartsAt == nil || tournament.StartsAt.After(now) {
+		return nil
+	}
+
+	if tournament.State == StateRegistrationClosed {
+		err := s.repo.TransitionTournament(tournament, StateRunning)
+		if err != ErrNotEnoughEntrants || !tournament.AutoCancel {
+			return err
+		}
+	}
+	if tournament.AutoCancel && tournament.State != StateRunning {
+		return s.repo.CancelTournament(tournament)
+	}
+	return nil
+}
+
+//registrationPassed tells if tournament does not accept entries anymore at given time, registration ends at deadline or at start if there is no deadline
+func (t *Tournament) registrationPassed(now time.Time) bool {
+	deadline := t.RegistrationDeadline
+	if deadline == nil {
+		deadline = t.StartsAt
+	}
+	return deadline != nil && !now.Before(*deadline)
+}
+
+//scheduledAt tells if scheduler has to act on tournament at given time
+func (t *Tournament) scheduledAt(now time.Time) bool {
+	started := t.StartsAt != nil && !now.Before(*t.StartsAt)
+	switch t.State {
+	case StateRegistrationOpen:
+		return t.registrationPassed(now)
+	case StateRegistrationClosed:
+		return started
+	case StateAnnounced:
+		return t.AutoCancel && started
+	}
+	return false
+}
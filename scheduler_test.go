@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScheduler(t *testing.T) {
+	db := newTestStore(t)
+	db.ResetDatabase()
+	defer db.ResetDatabase()
+	createTestAPIKey(db, testOperatorKey, ScopeOperator, "")
+
+	now := time.Now()
+	at := func(d time.Duration) string {
+		return now.Add(d).Format(time.RFC3339)
+	}
+	scheduler := NewScheduler(db, time.Minute)
+
+	Convey("Given scheduled tournaments", t, func() {
+		Convey("Given tournament S1 requiring 2 entrants with auto cancel and tournament S2 without limits", func() {
+			call(db, "GET", fmt.Sprintf("/announceTournament?tournamentId=S1&deposit=10&minEntrants=2&autoCancel=true&startsAt=%v&registrationDeadline=%v", at(2*time.Hour), at(time.Hour)), "")
+			call(db, "GET", fmt.Sprintf("/announceTournament?tournamentId=S2&deposit=10&startsAt=%v", at(2*time.Hour)), "")
+			call(db, "GET", "/openRegistration?tournamentId=S1", "")
+			call(db, "GET", "/openRegistration?tournamentId=S2", "")
+			fundPlayer("P26", 20, db)
+			w1 := joinTournament("S1", "P26", nil, db)
+			w2 := joinTournament("S2", "P26", nil, db)
+			Convey("Player should be able to join both before deadline", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(w2.Code, ShouldEqual, http.StatusOK)
+			})
+		})
+		Convey("Given registration deadline of S1 passes", func() {
+			err := scheduler.Tick(now.Add(90 * time.Minute))
+			Convey("S1 registration should be closed and S2 registration still open", func() {
+				So(err, ShouldBeNil)
+				tournament1, _ := db.FindTournament("S1")
+				tournament2, _ := db.FindTournament("S2")
+				So(tournament1.State, ShouldEqual, StateRegistrationClosed)
+				So(tournament2.State, ShouldEqual, StateRegistrationOpen)
+			})
+		})
+		Convey("Given start time of both tournaments passes", func() {
+			err := scheduler.Tick(now.Add(2 * time.Hour))
+			Convey("S1 should be cancelled with refund because of too few entrants and S2 should be running", func() {
+				So(err, ShouldBeNil)
+				tournament1, _ := db.FindTournament("S1")
+				tournament2, _ := db.FindTournament("S2")
+				So(tournament1.State, ShouldEqual, StateCancelled)
+				So(tournament2.State, ShouldEqual, StateRunning)
+				player, _ := db.FindPlayer("P26")
+				So(player.Balances["EUR"], ShouldEqual, 1000)
+			})
+		})
+		Convey("Given announced tournament S3 with auto cancel whose start time passes", func() {
+			call(db, "GET", fmt.Sprintf("/announceTournament?tournamentId=S3&deposit=10&autoCancel=true&startsAt=%v", at(time.Hour)), "")
+			err := scheduler.Tick(now.Add(time.Hour))
+			Convey("It should be cancelled", func() {
+				So(err, ShouldBeNil)
+				tournament, _ := db.FindTournament("S3")
+				So(tournament.State, ShouldEqual, StateCancelled)
+			})
+		})
+		Convey("Given tournament S4 whose registration deadline has passed but scheduler did not run yet", func() {
+			call(db, "GET", fmt.Sprintf("/announceTournament?tournamentId=S4&deposit=10&registrationDeadline=%v", at(-time.Minute)), "")
+			call(db, "GET", "/openRegistration?tournamentId=S4", "")
+			w := joinTournament("S4", "P26", nil, db)
+			Convey("It should not accept entries", func() {
+				So(w.Code, ShouldEqual, http.StatusConflict)
+				player, _ := db.FindPlayer("P26")
+				So(player.Balances["EUR"], ShouldEqual, 1000)
+			})
+		})
+		Convey("Given tournament S7 requiring 2 entrants without auto cancel whose start time passes several times", func() {
+			call(db, "GET", fmt.Sprintf("/announceTournament?tournamentId=S7&deposit=10&minEntrants=2&startsAt=%v", at(time.Hour)), "")
+			call(db, "GET", "/openRegistration?tournamentId=S7", "")
+			store := &transitionCounter{Datastore: db, transitions: map[string]int{}}
+			scheduler := NewScheduler(store, time.Minute)
+			err1 := scheduler.Tick(now.Add(time.Hour))
+			err2 := scheduler.Tick(now.Add(time.Hour + time.Minute))
+			err3 := scheduler.Tick(now.Add(time.Hour + 2*time.Minute))
+			Convey("Registration should be closed and start attempted only once", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+				So(err3, ShouldBeNil)
+				tournament, _ := db.FindTournament("S7")
+				So(tournament.State, ShouldEqual, StateRegistrationClosed)
+				So(store.transitions["S7"], ShouldEqual, 2)
+			})
+		})
+		Convey("Given i announce tournament with deadline after start or auto cancel without start", func() {
+			w1 := call(db, "GET", fmt.Sprintf("/announceTournament?tournamentId=S5&deposit=10&startsAt=%v&registrationDeadline=%v", at(time.Hour), at(2*time.Hour)), "")
+			w2 := call(db, "GET", "/announceTournament?tournamentId=S6&deposit=10&autoCancel=true", "")
+			Convey("it should result in unprocessable entity", func() {
+				So(w1.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(w2.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			})
+		})
+	})
+}
+
+//transitionCounter counts tournament transitions attempted through datastore
+type transitionCounter struct {
+	Datastore
+	transitions map[string]int
+}
+
+func (c *transitionCounter) TransitionTournament(tournament *Tournament, state string) error {
+	c.transitions[tournament.ID]++
+	return c.Datastore.TransitionTournament(tournament, state)
+}
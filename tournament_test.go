@@ -6,17 +6,37 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+//newTestStore returns in-memory datastore, or postgres one when TEST_DSN environment variable is set
+func newTestStore(t *testing.T) Datastore {
+	testDSN := os.Getenv("TEST_DSN")
+	if testDSN == "" {
+		return NewMemoryStore()
+	}
+	db, err := NewDB(testDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
 func TestTournamentIntegration(t *testing.T) {
-	db, _ := NewDB(dsn)
+	db := newTestStore(t)
 	db.ResetDatabase()
 	defer func() {
 		db.ResetDatabase()
 	}()
+	createTestAPIKey(db, testOperatorKey, ScopeOperator, "")
+	createTestGameServer(db)
 
 	Convey("Given there is open database connection", t, func() {
 		Convey("Given I add 100 points to player P1", func() {
@@ -24,7 +44,7 @@ func TestTournamentIntegration(t *testing.T) {
 			Convey("It should create new player with id of P1 and balance of 100", func() {
 				user, err := db.FindPlayer("P1")
 				So(err, ShouldBeNil)
-				So(user.Balance, ShouldEqual, 10000)
+				So(user.Balances["EUR"], ShouldEqual, 10000)
 			})
 		})
 		Convey("Given I add 100 more points to a same player P1", func() {
@@ -32,7 +52,7 @@ func TestTournamentIntegration(t *testing.T) {
 			Convey("It should have now 200 points", func() {
 				user, err := db.FindPlayer("P1")
 				So(err, ShouldBeNil)
-				So(user.Balance, ShouldEqual, 20000)
+				So(user.Balances["EUR"], ShouldEqual, 20000)
 			})
 		})
 		Convey("Given I take 300 points from the same player P1", func() {
@@ -41,7 +61,7 @@ func TestTournamentIntegration(t *testing.T) {
 				user, err := db.FindPlayer("P1")
 				So(w.Code, ShouldEqual, http.StatusBadRequest)
 				So(err, ShouldBeNil)
-				So(user.Balance, ShouldEqual, 20000)
+				So(user.Balances["EUR"], ShouldEqual, 20000)
 			})
 		})
 		Convey("Given i try to take points from not existing user P2", func() {
@@ -58,6 +78,24 @@ func TestTournamentIntegration(t *testing.T) {
 				So(err, ShouldBeNil)
 				So(tournament.ID, ShouldEqual, "1")
 				So(tournament.Deposit, ShouldEqual, 5000)
+				So(tournament.State, ShouldEqual, StateAnnounced)
+			})
+		})
+		Convey("Given i try to join tournament before its registration is open", func() {
+			fundPlayer("P9", 100, db)
+			w := joinTournament("1", "P9", nil, db)
+			Convey("it should result in conflict and player balance should be unchanged", func() {
+				So(w.Code, ShouldEqual, http.StatusConflict)
+				player, _ := db.FindPlayer("P9")
+				So(player.Balances["EUR"], ShouldEqual, 10000)
+			})
+		})
+		Convey("Given i open registration for tournament 1", func() {
+			w := call(db, "GET", "/openRegistration?tournamentId=1", "")
+			Convey("it should be open for registration", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				tournament, _ := db.FindTournament("1")
+				So(tournament.State, ShouldEqual, StateRegistrationOpen)
 			})
 		})
 		Convey("Given i try to join tournament which doesnt exists", func() {
@@ -85,8 +123,8 @@ func TestTournamentIntegration(t *testing.T) {
 				So(w.Code, ShouldEqual, http.StatusBadRequest)
 				player, _ := db.FindPlayer("P1")
 				player2, _ := db.FindPlayer("P2")
-				So(player.Balance, ShouldEqual, 20000)
-				So(player2.Balance, ShouldEqual, 2000)
+				So(player.Balances["EUR"], ShouldEqual, 20000)
+				So(player2.Balances["EUR"], ShouldEqual, 2000)
 			})
 		})
 		Convey("Given i try to join tournament with user and 1 backer", func() {
@@ -96,8 +134,8 @@ func TestTournamentIntegration(t *testing.T) {
 				So(w.Code, ShouldEqual, http.StatusOK)
 				player, _ := db.FindPlayer("P1")
 				player2, _ := db.FindPlayer("P2")
-				So(player.Balance, ShouldEqual, 17500)
-				So(player2.Balance, ShouldEqual, 17500)
+				So(player.Balances["EUR"], ShouldEqual, 17500)
+				So(player2.Balances["EUR"], ShouldEqual, 17500)
 			})
 		})
 		Convey("Given i join 1 more player P3 with 2 backers P4 and P5", func() {
@@ -110,9 +148,9 @@ func TestTournamentIntegration(t *testing.T) {
 				player3, _ := db.FindPlayer("P3")
 				player4, _ := db.FindPlayer("P4")
 				player5, _ := db.FindPlayer("P5")
-				So(player3.Balance, ShouldEqual, 10000-1667)
-				So(player4.Balance, ShouldEqual, 10000-1667)
-				So(player5.Balance, ShouldEqual, 10000-1666)
+				So(player3.Balances["EUR"], ShouldEqual, 10000-1667)
+				So(player4.Balances["EUR"], ShouldEqual, 10000-1667)
+				So(player5.Balances["EUR"], ShouldEqual, 10000-1666)
 			})
 		})
 		Convey("Given i result tournament which doesnt exists", func() {
@@ -121,13 +159,40 @@ func TestTournamentIntegration(t *testing.T) {
 				So(w.Code, ShouldEqual, http.StatusNotFound)
 			})
 		})
-		Convey("Given i result tournament which exists but user doesnt exists", func() {
+		Convey("Given i result tournament which is not running yet", func() {
+			w := finishTournament("1", map[string]int{"P1": 100}, db)
+			Convey("it should result in conflict", func() {
+				So(w.Code, ShouldEqual, http.StatusConflict)
+			})
+		})
+		Convey("Given i try to start tournament while its registration is still open", func() {
+			w := call(db, "GET", "/startTournament?tournamentId=1", "")
+			Convey("it should result in conflict", func() {
+				So(w.Code, ShouldEqual, http.StatusConflict)
+			})
+		})
+		Convey("Given i close registration and start tournament 1", func() {
+			w1 := call(db, "GET", "/closeRegistration?tournamentId=1", "")
+			w2 := joinTournament("1", "P9", nil, db)
+			w3 := call(db, "GET", "/startTournament?tournamentId=1", "")
+			Convey("it should not accept joins after registration is closed and should be running", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(w2.Code, ShouldEqual, http.StatusConflict)
+				So(w3.Code, ShouldEqual, http.StatusOK)
+				tournament, _ := db.FindTournament("1")
+				So(tournament.State, ShouldEqual, StateRunning)
+			})
+		})
+		Convey("Given i result tournament which exists but user only backs an entry", func() {
 			w := finishTournament("1", map[string]int{"P2": 100}, db)
-			Convey("It should result in error bad request and tournament and p2 user values unchanged", func() {
-				So(w.Code, ShouldEqual, http.StatusBadRequest)
+			Convey("It should result in unprocessable entity reporting the winner and tournament and p2 user values unchanged", func() {
+				So(w.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				e := errorResponse(w)
+				So(e.Code, ShouldEqual, CodeInvalidWinners)
+				So(e.Problems, ShouldResemble, []ResultProblem{{Index: 0, PlayerID: "P2", Code: CodePlayerNotEntered, Message: "winner has no entry in tournament"}})
 				user, _ := db.FindPlayer("P2")
 				_, err := db.FindTournament("1")
-				So(user.Balance, ShouldEqual, 17500)
+				So(user.Balances["EUR"], ShouldEqual, 17500)
 				So(err, ShouldBeNil)
 			})
 		})
@@ -137,8 +202,20 @@ func TestTournamentIntegration(t *testing.T) {
 				So(w.Code, ShouldEqual, http.StatusOK)
 				player1, _ := db.FindPlayer("P1")
 				player2, _ := db.FindPlayer("P2")
-				So(player1.Balance, ShouldEqual, 22500)
-				So(player2.Balance, ShouldEqual, 22500)
+				So(player1.Balances["EUR"], ShouldEqual, 22500)
+				So(player2.Balances["EUR"], ShouldEqual, 22500)
+				tournament, _ := db.FindTournament("1")
+				So(tournament.State, ShouldEqual, StateFinished)
+			})
+		})
+		Convey("Given i try to join, reopen or result finished tournament", func() {
+			w1 := joinTournament("1", "P9", nil, db)
+			w2 := call(db, "GET", "/openRegistration?tournamentId=1", "")
+			w3 := finishTournament("1", map[string]int{"P1": 100}, db)
+			Convey("it should result in conflict", func() {
+				So(w1.Code, ShouldEqual, http.StatusConflict)
+				So(w2.Code, ShouldEqual, http.StatusConflict)
+				So(w3.Code, ShouldEqual, http.StatusConflict)
 			})
 		})
 
@@ -166,38 +243,552 @@ func TestTournamentIntegration(t *testing.T) {
 
 			})
 		})
+		Convey("Given i fund player P6 twice with the same idempotency key", func() {
+			w1 := call(db, "GET", "/fund?playerId=P6&points=10&idempotencyKey=fund-P6-1", "")
+			w2 := call(db, "GET", "/fund?playerId=P6&points=10&idempotencyKey=fund-P6-1", "")
+			Convey("It should add points only once and replay first response", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(w2.Code, ShouldEqual, http.StatusOK)
+				So(w2.Header().Get("Idempotent-Replayed"), ShouldEqual, "true")
+				player, _ := db.FindPlayer("P6")
+				So(player.Balances["EUR"], ShouldEqual, 1000)
+			})
+		})
+		Convey("Given i retry fund of player P6 with the same idempotency key but different points", func() {
+			w := call(db, "GET", "/fund?playerId=P6&points=20&idempotencyKey=fund-P6-1", "")
+			Convey("It should be rejected without adding points", func() {
+				So(w.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorResponse(w).Code, ShouldEqual, CodeKeyMismatch)
+				player, _ := db.FindPlayer("P6")
+				So(player.Balances["EUR"], ShouldEqual, 1000)
+			})
+		})
+		Convey("Given i take points from player P6 twice with the same idempotency key", func() {
+			call(db, "GET", "/take?playerId=P6&points=4&idempotencyKey=take-P6-1", "")
+			call(db, "GET", "/take?playerId=P6&points=4&idempotencyKey=take-P6-1", "")
+			Convey("It should take points only once", func() {
+				player, _ := db.FindPlayer("P6")
+				So(player.Balances["EUR"], ShouldEqual, 600)
+			})
+		})
+		Convey("Given i retry failed take with the same idempotency key after funding player", func() {
+			w1 := call(db, "GET", "/take?playerId=P6&points=10&idempotencyKey=take-P6-2", "")
+			fundPlayer("P6", 10, db)
+			w2 := call(db, "GET", "/take?playerId=P6&points=10&idempotencyKey=take-P6-2", "")
+			Convey("It should replay the first failure without taking points", func() {
+				So(w1.Code, ShouldEqual, http.StatusBadRequest)
+				So(w2.Code, ShouldEqual, http.StatusBadRequest)
+				player, _ := db.FindPlayer("P6")
+				So(player.Balances["EUR"], ShouldEqual, 1600)
+			})
+		})
+		Convey("Given i join tournament twice with player P6 backed by P7 using the same idempotency key", func() {
+			createTournament("3", 10, db)
+			call(db, "GET", "/openRegistration?tournamentId=3", "")
+			fundPlayer("P7", 10, db)
+			call(db, "GET", "/joinTournament?tournamentId=3&playerId=P6&backerId=P7&idempotencyKey=join-P6-1", "")
+			call(db, "GET", "/joinTournament?tournamentId=3&playerId=P6&backerId=P7&idempotencyKey=join-P6-1", "")
+			Convey("It should charge player and backer only once", func() {
+				player6, _ := db.FindPlayer("P6")
+				player7, _ := db.FindPlayer("P7")
+				So(player6.Balances["EUR"], ShouldEqual, 1100)
+				So(player7.Balances["EUR"], ShouldEqual, 500)
+			})
+		})
+		Convey("Given i cancel running tournament 4 where P10 joined with backers P11 and P12", func() {
+			createTournament("4", 10, db)
+			call(db, "GET", "/openRegistration?tournamentId=4", "")
+			fundPlayer("P10", 10, db)
+			fundPlayer("P11", 10, db)
+			fundPlayer("P12", 10, db)
+			fundPlayer("P13", 10, db)
+			joinTournament("4", "P10", []string{"P11", "P12"}, db)
+			joinTournament("4", "P13", nil, db)
+			call(db, "GET", "/closeRegistration?tournamentId=4", "")
+			call(db, "GET", "/startTournament?tournamentId=4", "")
+			w := call(db, "GET", "/cancelTournament?tournamentId=4", "")
+			Convey("Every player and backer should get back exactly what they paid including remainder cents", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				for _, id := range []string{"P10", "P11", "P12", "P13"} {
+					player, _ := db.FindPlayer(id)
+					So(player.Balances["EUR"], ShouldEqual, 1000)
+				}
+				tournament, _ := db.FindTournament("4")
+				So(tournament.State, ShouldEqual, StateCancelled)
+			})
+		})
+		Convey("Given i try to cancel, reopen or result cancelled tournament 4", func() {
+			w1 := call(db, "GET", "/cancelTournament?tournamentId=4", "")
+			w2 := call(db, "GET", "/openRegistration?tournamentId=4", "")
+			w3 := finishTournament("4", map[string]int{"P13": 10}, db)
+			Convey("it should result in conflict and balances should be unchanged", func() {
+				So(w1.Code, ShouldEqual, http.StatusConflict)
+				So(w2.Code, ShouldEqual, http.StatusConflict)
+				So(w3.Code, ShouldEqual, http.StatusConflict)
+				player, _ := db.FindPlayer("P13")
+				So(player.Balances["EUR"], ShouldEqual, 1000)
+			})
+		})
+		Convey("Given P14 backed by P15 joins tournament 5 and then leaves it", func() {
+			createTournament("5", 10, db)
+			call(db, "GET", "/openRegistration?tournamentId=5", "")
+			fundPlayer("P14", 10, db)
+			fundPlayer("P15", 10, db)
+			joinTournament("5", "P14", []string{"P15"}, db)
+			w := call(db, "GET", "/leaveTournament?tournamentId=5&playerId=P14", "")
+			Convey("Both should be refunded", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				player14, _ := db.FindPlayer("P14")
+				player15, _ := db.FindPlayer("P15")
+				So(player14.Balances["EUR"], ShouldEqual, 1000)
+				So(player15.Balances["EUR"], ShouldEqual, 1000)
+			})
+		})
+		Convey("Given i try to leave tournament 5 again with P14", func() {
+			w := call(db, "GET", "/leaveTournament?tournamentId=5&playerId=P14", "")
+			Convey("it should result in not found", func() {
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+		Convey("Given P14 joins tournament 5 again alone and registration closes", func() {
+			w1 := joinTournament("5", "P14", nil, db)
+			call(db, "GET", "/closeRegistration?tournamentId=5", "")
+			w2 := call(db, "GET", "/leaveTournament?tournamentId=5&playerId=P14", "")
+			Convey("Rejoin should succeed and leaving after registration closed should result in conflict", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(w2.Code, ShouldEqual, http.StatusConflict)
+				player14, _ := db.FindPlayer("P14")
+				So(player14.Balances["EUR"], ShouldEqual, 0)
+			})
+		})
+		Convey("Given P16 joins tournament 6 backed by P17 with 60% and P18 with 25%", func() {
+			createTournament("6", 100, db)
+			call(db, "GET", "/openRegistration?tournamentId=6", "")
+			fundPlayer("P16", 100, db)
+			fundPlayer("P17", 100, db)
+			fundPlayer("P18", 100, db)
+			w := call(db, "GET", "/joinTournament?tournamentId=6&playerId=P16&backerId=P17&backerShare=60&backerId=P18&backerShare=25", "")
+			Convey("Deposit should be taken by given shares with player staking the rest", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				player16, _ := db.FindPlayer("P16")
+				player17, _ := db.FindPlayer("P17")
+				player18, _ := db.FindPlayer("P18")
+				So(player16.Balances["EUR"], ShouldEqual, 10000-1500)
+				So(player17.Balances["EUR"], ShouldEqual, 10000-6000)
+				So(player18.Balances["EUR"], ShouldEqual, 10000-2500)
+			})
+		})
+		Convey("Given P19 joins tournament 6 backed by P16 with amount of 30", func() {
+			fundPlayer("P19", 100, db)
+			w := call(db, "GET", "/joinTournament?tournamentId=6&playerId=P19&backerId=P16&backerAmount=30", "")
+			Convey("Backer should pay given amount and player the rest", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				player16, _ := db.FindPlayer("P16")
+				player19, _ := db.FindPlayer("P19")
+				So(player16.Balances["EUR"], ShouldEqual, 8500-3000)
+				So(player19.Balances["EUR"], ShouldEqual, 10000-7000)
+			})
+		})
+		Convey("Given i try to join tournament 6 with stakes which exceed deposit or are mixed", func() {
+			w1 := call(db, "GET", "/joinTournament?tournamentId=6&playerId=P20&backerId=P17&backerShare=60&backerId=P18&backerShare=45", "")
+			w2 := call(db, "GET", "/joinTournament?tournamentId=6&playerId=P20&backerId=P17&backerAmount=101", "")
+			w3 := call(db, "GET", "/joinTournament?tournamentId=6&playerId=P20&backerId=P17&backerShare=10&backerId=P18&backerAmount=10", "")
+			w4 := call(db, "GET", "/joinTournament?tournamentId=6&playerId=P20&backerId=P17&backerId=P18&backerShare=10", "")
+			Convey("it should result in unprocessable entity", func() {
+				So(w1.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(w2.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(w3.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(w4.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			})
+		})
+		Convey("Given tournament 6 is won by P16 with prize of 200", func() {
+			call(db, "GET", "/closeRegistration?tournamentId=6", "")
+			call(db, "GET", "/startTournament?tournamentId=6", "")
+			w := finishTournament("6", map[string]int{"P16": 200}, db)
+			Convey("Prize should be split by stored stakes", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				player16, _ := db.FindPlayer("P16")
+				player17, _ := db.FindPlayer("P17")
+				player18, _ := db.FindPlayer("P18")
+				So(player16.Balances["EUR"], ShouldEqual, 5500+3000)
+				So(player17.Balances["EUR"], ShouldEqual, 4000+12000)
+				So(player18.Balances["EUR"], ShouldEqual, 7500+5000)
+			})
+		})
+		Convey("Given tournament 7 with 1 to 2 seats where 4 players try to join", func() {
+			call(db, "GET", "/announceTournament?tournamentId=7&deposit=10&minEntrants=1&maxEntrants=2", "")
+			call(db, "GET", "/openRegistration?tournamentId=7", "")
+			for _, id := range []string{"P21", "P22", "P23", "P24", "P25"} {
+				fundPlayer(id, 10, db)
+			}
+			w1 := joinTournament("7", "P21", nil, db)
+			w2 := joinTournament("7", "P22", nil, db)
+			w3 := joinTournament("7", "P23", []string{"P25"}, db)
+			w4 := joinTournament("7", "P24", nil, db)
+			w5 := joinTournament("7", "P24", nil, db)
+			Convey("Players beyond capacity should be waitlisted without being charged", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(w2.Code, ShouldEqual, http.StatusOK)
+				So(w3.Code, ShouldEqual, http.StatusAccepted)
+				So(w4.Code, ShouldEqual, http.StatusAccepted)
+				So(w5.Code, ShouldEqual, http.StatusConflict)
+				player21, _ := db.FindPlayer("P21")
+				player23, _ := db.FindPlayer("P23")
+				player25, _ := db.FindPlayer("P25")
+				So(player21.Balances["EUR"], ShouldEqual, 0)
+				So(player23.Balances["EUR"], ShouldEqual, 1000)
+				So(player25.Balances["EUR"], ShouldEqual, 1000)
+			})
+		})
+		Convey("Given P23 backer P25 spends its points and P21 leaves tournament 7", func() {
+			takeFundsFromPlayer("P25", 10, db)
+			w := call(db, "GET", "/leaveTournament?tournamentId=7&playerId=P21", "")
+			Convey("P23 should be dropped from waitlist and P24 should get the seat and be charged", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				player21, _ := db.FindPlayer("P21")
+				player23, _ := db.FindPlayer("P23")
+				player24, _ := db.FindPlayer("P24")
+				So(player21.Balances["EUR"], ShouldEqual, 1000)
+				So(player23.Balances["EUR"], ShouldEqual, 1000)
+				So(player24.Balances["EUR"], ShouldEqual, 0)
+				So(call(db, "GET", "/leaveTournament?tournamentId=7&playerId=P23", "").Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+		Convey("Given P21 joins full tournament 7 again and leaves waitlist", func() {
+			w1 := joinTournament("7", "P21", nil, db)
+			w2 := call(db, "GET", "/leaveTournament?tournamentId=7&playerId=P21", "")
+			Convey("It should be waitlisted and removed without any charges", func() {
+				So(w1.Code, ShouldEqual, http.StatusAccepted)
+				So(w2.Code, ShouldEqual, http.StatusOK)
+				player21, _ := db.FindPlayer("P21")
+				So(player21.Balances["EUR"], ShouldEqual, 1000)
+			})
+		})
+		Convey("Given tournament 15 with 2 seats where P41 joins twice, P42 joins and P41 tries once more", func() {
+			call(db, "GET", "/announceTournament?tournamentId=15&deposit=10&maxEntrants=2", "")
+			call(db, "GET", "/openRegistration?tournamentId=15", "")
+			fundPlayer("P41", 30, db)
+			fundPlayer("P42", 10, db)
+			w1 := joinTournament("15", "P41", nil, db)
+			w2 := joinTournament("15", "P41", nil, db)
+			w3 := joinTournament("15", "P42", nil, db)
+			w4 := joinTournament("15", "P41", nil, db)
+			Convey("P41 should be seated and charged only once and P42 should get the other seat", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(w2.Code, ShouldEqual, http.StatusConflict)
+				So(errorResponse(w2).Code, ShouldEqual, CodeAlreadyExists)
+				So(w3.Code, ShouldEqual, http.StatusOK)
+				So(w4.Code, ShouldEqual, http.StatusConflict)
+				player41, _ := db.FindPlayer("P41")
+				player42, _ := db.FindPlayer("P42")
+				So(player41.Balances["EUR"], ShouldEqual, 2000)
+				So(player42.Balances["EUR"], ShouldEqual, 0)
+				tournament, _ := db.FindTournament("15")
+				So(tournament.PrizePool, ShouldEqual, 2000)
+			})
+		})
+		Convey("Given tournament 8 requires 2 entrants but only 1 joins", func() {
+			call(db, "GET", "/announceTournament?tournamentId=8&deposit=10&minEntrants=2", "")
+			call(db, "GET", "/openRegistration?tournamentId=8", "")
+			joinTournament("8", "P21", nil, db)
+			call(db, "GET", "/closeRegistration?tournamentId=8", "")
+			w := call(db, "GET", "/startTournament?tournamentId=8", "")
+			Convey("It should not be allowed to start", func() {
+				So(w.Code, ShouldEqual, http.StatusConflict)
+				tournament, _ := db.FindTournament("8")
+				So(tournament.State, ShouldEqual, StateRegistrationClosed)
+			})
+		})
+		Convey("Given i announce tournament with minimum entrants above maximum", func() {
+			w := call(db, "GET", "/announceTournament?tournamentId=9&deposit=10&minEntrants=3&maxEntrants=2", "")
+			Convey("it should result in unprocessable entity", func() {
+				So(w.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			})
+		})
+		Convey("Given tournament 10 paying top 3 where P30, P31, P32 and P33 join and P32 leaves", func() {
+			call(db, "GET", "/announceTournament?tournamentId=10&deposit=10&payout=top3&gameServerId="+testGameServerID, "")
+			call(db, "GET", "/openRegistration?tournamentId=10", "")
+			for _, id := range []string{"P30", "P31", "P32", "P33"} {
+				fundPlayer(id, 10, db)
+				joinTournament("10", id, nil, db)
+			}
+			call(db, "GET", "/leaveTournament?tournamentId=10&playerId=P32", "")
+			Convey("Prize pool should hold deposits of remaining entrants", func() {
+				tournament, _ := db.FindTournament("10")
+				So(tournament.Payout, ShouldEqual, PayoutTop3)
+				So(tournament.PrizePool, ShouldEqual, 3000)
+			})
+		})
+		Convey("Given i result running tournament 10 with prizes above prize pool or invalid placings", func() {
+			joinTournament("10", "P32", nil, db)
+			call(db, "GET", "/closeRegistration?tournamentId=10", "")
+			call(db, "GET", "/startTournament?tournamentId=10", "")
+			w1 := finishTournament("10", map[string]int{"P30": 30, "P31": 11}, db)
+			w2 := postResults(db, "/resultTournament", `{"tournamentId": "10", "placings": ["P30", "P31", "P30"]}`)
+			w3 := postResults(db, "/resultTournament", `{"tournamentId": "10", "placings": ["P30", "P31"]}`)
+			w4 := postResults(db, "/resultTournament", `{"tournamentId": "10", "winners": [{"playerId": "P30", "prize": 10}, {"playerId": "P99", "prize": 5}, {"playerId": "P30", "prize": -1}]}`)
+			w5 := postResults(db, "/resultTournament", `{"tournamentId": "10", "placings": ["P30", "P99", "P31", "P32"]}`)
+			Convey("it should result in unprocessable entity and tournament should keep running", func() {
+				So(w1.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorResponse(w1).Code, ShouldEqual, CodePrizeExceedsPool)
+				So(w2.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(w3.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(w4.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				e4 := errorResponse(w4)
+				So(e4.Code, ShouldEqual, CodeInvalidWinners)
+				So(e4.Field, ShouldEqual, "winners")
+				So(len(e4.Problems), ShouldEqual, 3)
+				So(e4.Problems[0], ShouldResemble, ResultProblem{Index: 1, PlayerID: "P99", Code: CodePlayerNotEntered, Message: "winner has no entry in tournament"})
+				So(e4.Problems[1].Index, ShouldEqual, 2)
+				So(e4.Problems[1].Code, ShouldEqual, CodeDuplicatePlayer)
+				So(e4.Problems[2].Index, ShouldEqual, 2)
+				So(e4.Problems[2].Code, ShouldEqual, CodeNegativePrize)
+				So(w5.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				e5 := errorResponse(w5)
+				So(e5.Code, ShouldEqual, CodeInvalidPlacings)
+				So(e5.Problems, ShouldResemble, []ResultProblem{{Index: 1, PlayerID: "P99", Code: CodePlayerNotEntered, Message: "placed player has no entry in tournament"}})
+				tournament, _ := db.FindTournament("10")
+				So(tournament.State, ShouldEqual, StateRunning)
+				So(tournament.PrizePool, ShouldEqual, 4000)
+			})
+		})
+		Convey("Given i result tournament 10 with finishing order P33, P31, P30, P32", func() {
+			w := postResults(db, "/resultTournament", `{"tournamentId": "10", "placings": ["P33", "P31", "P30", "P32"]}`)
+			Convey("Prize pool should be paid out 50/30/20 to top 3", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				for id, balance := range map[string]int{"P33": 2000, "P31": 1200, "P30": 800, "P32": 0} {
+					player, _ := db.FindPlayer(id)
+					So(player.Balances["EUR"], ShouldEqual, balance)
+				}
+			})
+		})
+		Convey("Given tournament 11 with fee of 1 on top of deposit where P34 and P35 backed by P36 join", func() {
+			call(db, "GET", "/announceTournament?tournamentId=11&deposit=10&fee=1", "")
+			call(db, "GET", "/openRegistration?tournamentId=11", "")
+			fundPlayer("P34", 11, db)
+			fundPlayer("P35", 10, db)
+			fundPlayer("P36", 10, db)
+			w1 := joinTournament("11", "P34", nil, db)
+			w2 := joinTournament("11", "P35", []string{"P36"}, db)
+			Convey("Players should pay deposit with fee, fee should go to house and stay out of prize pool", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(w2.Code, ShouldEqual, http.StatusOK)
+				player34, _ := db.FindPlayer("P34")
+				player35, _ := db.FindPlayer("P35")
+				player36, _ := db.FindPlayer("P36")
+				house, _ := db.FindPlayer(HouseAccountID)
+				So(player34.Balances["EUR"], ShouldEqual, 0)
+				So(player35.Balances["EUR"], ShouldEqual, 1000-550)
+				So(player36.Balances["EUR"], ShouldEqual, 1000-550)
+				So(house.Balances["EUR"], ShouldEqual, 200)
+				tournament, _ := db.FindTournament("11")
+				So(tournament.PrizePool, ShouldEqual, 2000)
+			})
+		})
+		Convey("Given P34 leaves tournament 11", func() {
+			call(db, "GET", "/leaveTournament?tournamentId=11&playerId=P34", "")
+			Convey("It should get its fee back from house", func() {
+				player34, _ := db.FindPlayer("P34")
+				house, _ := db.FindPlayer(HouseAccountID)
+				So(player34.Balances["EUR"], ShouldEqual, 1100)
+				So(house.Balances["EUR"], ShouldEqual, 100)
+				tournament, _ := db.FindTournament("11")
+				So(tournament.PrizePool, ShouldEqual, 1000)
+			})
+		})
+		Convey("Given tournament 12 with fee of 2 included in deposit where P37 joins and tournament is cancelled", func() {
+			call(db, "GET", "/announceTournament?tournamentId=12&deposit=10&fee=2&feeIncluded=true", "")
+			call(db, "GET", "/openRegistration?tournamentId=12", "")
+			fundPlayer("P37", 10, db)
+			joinTournament("12", "P37", nil, db)
+			tournament, _ := db.FindTournament("12")
+			house, _ := db.FindPlayer(HouseAccountID)
+			w := call(db, "GET", "/cancelTournament?tournamentId=12", "")
+			Convey("Fee should be taken from deposit and returned on cancel", func() {
+				So(tournament.PrizePool, ShouldEqual, 800)
+				So(house.Balances["EUR"], ShouldEqual, 300)
+				So(w.Code, ShouldEqual, http.StatusOK)
+				player37, _ := db.FindPlayer("P37")
+				house, _ = db.FindPlayer(HouseAccountID)
+				So(player37.Balances["EUR"], ShouldEqual, 1000)
+				So(house.Balances["EUR"], ShouldEqual, 100)
+			})
+		})
+		Convey("Given i request revenue report for all time, tournament 11 and future", func() {
+			w1 := call(db, "GET", "/revenue", "")
+			w2 := call(db, "GET", "/revenue?tournamentId=11", "")
+			w3 := call(db, "GET", "/revenue?from="+time.Now().UTC().Add(time.Hour).Format(time.RFC3339), "")
+			Convey("It should show net fees per tournament", func() {
+				var report1, report2, report3 RevenueReport
+				json.NewDecoder(w1.Body).Decode(&report1)
+				json.NewDecoder(w2.Body).Decode(&report2)
+				json.NewDecoder(w3.Body).Decode(&report3)
+				So(report1.Total, ShouldEqual, 100)
+				So(report1.Tournaments, ShouldResemble, []TournamentRevenueReport{{"11", 100}, {"12", 0}})
+				So(report2.Tournaments, ShouldResemble, []TournamentRevenueReport{{"11", 100}})
+				So(report3.Total, ShouldEqual, 0)
+				So(report3.Tournaments, ShouldBeEmpty)
+			})
+		})
+		Convey("Given house fees are taken out and tournament 11 is cancelled before and after house is funded again", func() {
+			takeFundsFromPlayer(HouseAccountID, 1, db)
+			w1 := call(db, "GET", "/cancelTournament?tournamentId=11", "")
+			player35, _ := db.FindPlayer("P35")
+			fundPlayer(HouseAccountID, 1, db)
+			w2 := call(db, "GET", "/cancelTournament?tournamentId=11", "")
+			Convey("Cancel should wait for house to cover fees and then refund exactly what was charged", func() {
+				So(w1.Code, ShouldEqual, http.StatusConflict)
+				So(errorResponse(w1).Code, ShouldEqual, CodeHouseBalance)
+				So(player35.Balances["EUR"], ShouldEqual, 1000-550)
+				So(w2.Code, ShouldEqual, http.StatusOK)
+				for id, balance := range map[string]int{"P35": 1000, "P36": 1000, HouseAccountID: 0} {
+					player, _ := db.FindPlayer(id)
+					So(player.Balances["EUR"], ShouldEqual, balance)
+				}
+			})
+		})
+		Convey("Given i announce tournament with negative fee or included fee not below deposit", func() {
+			w1 := call(db, "GET", "/announceTournament?tournamentId=13&deposit=10&fee=-1", "")
+			w2 := call(db, "GET", "/announceTournament?tournamentId=13&deposit=10&fee=10&feeIncluded=true", "")
+			Convey("it should result in unprocessable entity", func() {
+				So(w1.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(w2.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			})
+		})
+		Convey("Given i take too many points, join unknown tournament and announce tournament without deposit", func() {
+			w1 := takeFundsFromPlayer("P1", 1000, db)
+			w2 := joinTournament("unknown", "P1", nil, db)
+			w3 := call(db, "GET", "/announceTournament?tournamentId=14&deposit=0&", "")
+			Convey("Error response should have code, message and field", func() {
+				e1, e2, e3 := errorResponse(w1), errorResponse(w2), errorResponse(w3)
+				So(w1.Code, ShouldEqual, http.StatusBadRequest)
+				So(e1.Code, ShouldEqual, CodeInsufficientBalance)
+				So(e1.Message, ShouldNotBeEmpty)
+				So(w2.Code, ShouldEqual, http.StatusNotFound)
+				So(e2.Code, ShouldEqual, CodeTournamentNotFound)
+				So(e2.Field, ShouldEqual, "tournamentId")
+				So(w3.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(e3.Code, ShouldEqual, CodeInvalidParameter)
+				So(e3.Field, ShouldEqual, "deposit")
+			})
+		})
+		Convey("Given i fund P40 with 0.29 points, 0.291 points, more points than can be stored and up to overflow", func() {
+			w1 := call(db, "GET", "/fund?playerId=P40&points=0.29", "")
+			w2 := call(db, "GET", "/fund?playerId=P40&points=0.291", "")
+			w3 := call(db, "GET", "/fund?playerId=P40&points=21474836.48", "")
+			w4 := call(db, "GET", "/fund?playerId=P40&points=21474836.47", "")
+			w5 := playerBalance("P40", db)
+			Convey("Amount should be exact and malformed or too large amounts rejected", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(w2.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorResponse(w2).Code, ShouldEqual, CodeInvalidParameter)
+				So(w3.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorResponse(w3).Code, ShouldEqual, CodeAmountOutOfRange)
+				So(w4.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorResponse(w4).Code, ShouldEqual, CodeAmountOutOfRange)
+				So(w5.Body.String(), ShouldEqual, `{"playerId":"P40","currency":"EUR","balance":0.29}`+"\n")
+			})
+		})
+		Convey("Given i try to cancel finished tournament 1", func() {
+			w := call(db, "GET", "/cancelTournament?tournamentId=1", "")
+			Convey("it should result in conflict", func() {
+				So(w.Code, ShouldEqual, http.StatusConflict)
+			})
+		})
 
 	})
 }
 
-func fundPlayer(id string, points int, db *DB) *httptest.ResponseRecorder {
-	req, _ := http.NewRequest("GET", fmt.Sprintf("/fund?playerId=%v&points=%d", id, points), nil)
-	w := httptest.NewRecorder()
-	h := Handlers{db}
-	handler := http.HandlerFunc(h.fundHandler)
-	handler.ServeHTTP(w, req)
-	return w
+func TestLedger(t *testing.T) {
+	db := newTestStore(t)
+	db.ResetDatabase()
+	defer db.ResetDatabase()
+	createTestAPIKey(db, testOperatorKey, ScopeOperator, "")
+	createTestGameServer(db)
+
+	Convey("Given balance changes are recorded in ledger", t, func() {
+		Convey("Given G1 is funded with 100 and G2 with 50, 30 is taken from G1 and G1 backed by G2 joins tournament G1 with deposit of 20", func() {
+			fundPlayer("G1", 100, db)
+			fundPlayer("G2", 50, db)
+			takeFundsFromPlayer("G1", 30, db)
+			createTournament("G1", 20, db)
+			call(db, "GET", "/openRegistration?tournamentId=G1", "")
+			w := joinTournament("G1", "G1", []string{"G2"}, db)
+			Convey("Every change should be recorded with its reason, amount, tournament and balance after it, adding up to player balance", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				ledger1, err := db.PlayerHistory(HistoryFilter{PlayerID: "G1", Limit: 10})
+				So(err, ShouldBeNil)
+				ledger2, err := db.PlayerHistory(HistoryFilter{PlayerID: "G2", Limit: 10})
+				So(err, ShouldBeNil)
+				type row struct {
+					reason       string
+					amount       int
+					balance      int
+					tournamentID string
+				}
+				rows := func(ledger []LedgerEntry) []row {
+					var rows []row
+					for i := len(ledger) - 1; i >= 0; i-- {
+						v := ledger[i]
+						r := row{reason: v.Reason, amount: v.Amount, balance: v.Balance}
+						if v.TournamentID != nil {
+							r.tournamentID = *v.TournamentID
+						}
+						So(v.Currency, ShouldEqual, "EUR")
+						rows = append(rows, r)
+					}
+					return rows
+				}
+				So(rows(ledger1), ShouldResemble, []row{{ReasonFund, 10000, 10000, ""}, {ReasonTake, -3000, 7000, ""}, {ReasonEntry, -1000, 6000, "G1"}})
+				So(rows(ledger2), ShouldResemble, []row{{ReasonFund, 5000, 5000, ""}, {ReasonBacking, -1000, 4000, "G1"}})
+
+				for id, ledger := range map[string][]LedgerEntry{"G1": ledger1, "G2": ledger2} {
+					total := 0
+					for i := len(ledger) - 1; i >= 0; i-- {
+						total += ledger[i].Amount
+						So(ledger[i].Balance, ShouldEqual, total)
+					}
+					player, _ := db.FindPlayer(id)
+					So(player.Balances["EUR"], ShouldEqual, total)
+				}
+			})
+		})
+	})
 }
 
-func takeFundsFromPlayer(id string, points int, db *DB) *httptest.ResponseRecorder {
-	req, _ := http.NewRequest("GET", fmt.Sprintf("/take?playerId=%v&points=%d", id, points), nil)
+//call serves request through api router as operator and returns recorded response
+func call(db Datastore, method string, url string, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Set(apiKeyHeader, testOperatorKey)
 	w := httptest.NewRecorder()
-	h := Handlers{db}
-	handler := http.HandlerFunc(h.takeHandler)
-	handler.ServeHTTP(w, req)
+	newRouter(&Handlers{repo: db, config: defaultConfig()}).ServeHTTP(w, req)
 	return w
 }
 
-func createTournament(id string, deposit int, db *DB) *httptest.ResponseRecorder {
-	req, _ := http.NewRequest("GET", fmt.Sprintf("/announceTournament?tournamentId=%v&deposit=%d", id, deposit), nil)
+//postResults posts tournament results signed by test game server through api router and returns recorded response
+func postResults(db Datastore, url string, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", url, bytes.NewBufferString(body))
+	for k, v := range testResultHeaders(url, body) {
+		req.Header.Set(k, v)
+	}
 	w := httptest.NewRecorder()
-	h := Handlers{db}
-	handler := http.HandlerFunc(h.announceHandler)
-	handler.ServeHTTP(w, req)
+	newRouter(&Handlers{repo: db, config: defaultConfig()}).ServeHTTP(w, req)
 	return w
 }
 
-func joinTournament(tournamentID string, playerID string, backers []string, db *DB) *httptest.ResponseRecorder {
+func fundPlayer(id string, points int, db Datastore) *httptest.ResponseRecorder {
+	return call(db, "GET", fmt.Sprintf("/fund?playerId=%v&points=%d", id, points), "")
+}
+
+func takeFundsFromPlayer(id string, points int, db Datastore) *httptest.ResponseRecorder {
+	return call(db, "GET", fmt.Sprintf("/take?playerId=%v&points=%d", id, points), "")
+}
+
+func createTournament(id string, deposit int, db Datastore) *httptest.ResponseRecorder {
+	return call(db, "GET", fmt.Sprintf("/announceTournament?tournamentId=%v&deposit=%d&gameServerId=%v", id, deposit, testGameServerID), "")
+}
+
+func joinTournament(tournamentID string, playerID string, backers []string, db Datastore) *httptest.ResponseRecorder {
 	backerURL := "&backerId=%v"
 	var backerConcatString string
 	for _, v := range backers {
@@ -207,41 +798,31 @@ func joinTournament(tournamentID string, playerID string, backers []string, db *
 	if len(backers) > 0 {
 		url += backerConcatString
 	}
-
-	req, _ := http.NewRequest("GET", fmt.Sprintf(url, tournamentID, playerID), nil)
-
-	w := httptest.NewRecorder()
-	h := Handlers{db}
-	handler := http.HandlerFunc(h.joinHandler)
-	handler.ServeHTTP(w, req)
-	return w
+	return call(db, "GET", fmt.Sprintf(url, tournamentID, playerID), "")
 }
 
-func finishTournament(tournamentID string, winners map[string]int, db *DB) *httptest.ResponseRecorder {
+func finishTournament(tournamentID string, winners map[string]int, db Datastore) *httptest.ResponseRecorder {
 	result := &ResultsRequest{
 		TournamentID: tournamentID,
 		Winners:      make([]Winner, 0),
 	}
 
 	for id, prize := range winners {
-		winner := &Winner{PlayerID: id, Prize: prize}
+		winner := &Winner{PlayerID: id, Prize: Money(prize * 100)}
 		result.Winners = append(result.Winners, *winner)
 	}
 	data, _ := json.Marshal(result)
-	b := bytes.NewBuffer(data)
-	req, _ := http.NewRequest("POST", "/resultTournament", b)
-	w := httptest.NewRecorder()
-	h := Handlers{db}
-	handler := http.HandlerFunc(h.resultHandler)
-	handler.ServeHTTP(w, req)
-	return w
+	return postResults(db, "/resultTournament", string(data))
 }
 
-func playerBalance(id string, db *DB) *httptest.ResponseRecorder {
-	req, _ := http.NewRequest("GET", fmt.Sprintf("/balance?playerId=%v", id), nil)
-	w := httptest.NewRecorder()
-	h := Handlers{db}
-	handler := http.HandlerFunc(h.balanceHandler)
-	handler.ServeHTTP(w, req)
-	return w
+func playerBalance(id string, db Datastore) *httptest.ResponseRecorder {
+	return call(db, "GET", fmt.Sprintf("/balance?playerId=%v", id), "")
+}
+
+func errorResponse(w *httptest.ResponseRecorder) APIError {
+	var response struct {
+		Error APIError `json:"error"`
+	}
+	json.NewDecoder(w.Body).Decode(&response)
+	return response.Error
 }
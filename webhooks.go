@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+//Event types webhooks can subscribe to
+const (
+	EventTournamentAnnounced = "tournament.announced"
+	EventEntryAccepted       = "entry.accepted"
+	EventTournamentFinished  = "tournament.finished"
+	EventPrizeCredited       = "prize.credited"
+	EventFundsAdded          = "funds.added"
+	EventFundsTaken          = "funds.taken"
+)
+
+//Delivery statuses, pending deliveries are retried until they are delivered or run out of attempts and fail
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+//deliveryLease is how long claimed delivery is hidden from other dispatchers while it is being sent
+const deliveryLease = time.Minute
+
+//deliveryTimeout is how long webhook endpoint has to respond
+const deliveryTimeout = 10 * time.Second
+
+//deliveryBatchSize is how many due deliveries dispatcher claims at once
+const deliveryBatchSize = 100
+
+//maxRetryDelay caps exponential backoff between delivery attempts
+const maxRetryDelay = 6 * time.Hour
+
+//Webhook is structure that represent webhooks table entry in database, endpoint receives events of given types, or of every type when none are given,
+//secret signs delivered events and is returned only when webhook is registered
+type Webhook struct {
+	ID        string         `db:"id" json:"id"`
+	URL       string         `db:"url" json:"url"`
+	Secret    string         `db:"secret" json:"secret,omitempty"`
+	Events    pq.StringArray `db:"events" json:"events"`
+	CreatedAt time.Time      `db:"created_at" json:"createdAt"`
+}
+
+//WebhookRequest is request body for v2 webhook registration
+type WebhookRequest struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+//Event is json body posted to webhooks, id is the same for every webhook event is delivered to so receivers can drop duplicates
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+//PrizeCredit is data of prize credited event, backedPlayerId is given when prize is paid for backing stake
+type PrizeCredit struct {
+	TournamentID   string `json:"tournamentId"`
+	PlayerID       string `json:"playerId"`
+	BackedPlayerID string `json:"backedPlayerId,omitempty"`
+	Currency       string `json:"currency"`
+	Amount         Money  `json:"amount"`
+}
+
+//WebhookDelivery is structure that represent webhook_deliveries table entry in database, delivery of single event to single webhook,
+//url and secret of webhook are only loaded for dispatcher
+type WebhookDelivery struct {
+	ID             int        `db:"id"`
+	WebhookID      string     `db:"webhook_id"`
+	EventID        string     `db:"event_id"`
+	EventType      string     `db:"event_type"`
+	Payload        string     `db:"payload"`
+	Status         string     `db:"status"`
+	Attempts       int        `db:"attempts"`
+	NextAttemptAt  time.Time  `db:"next_attempt_at"`
+	LastStatusCode int        `db:"last_status_code"`
+	LastError      string     `db:"last_error"`
+	CreatedAt      time.Time  `db:"created_at"`
+	DeliveredAt    *time.Time `db:"delivered_at"`
+	URL            string     `db:"url"`
+	Secret         string     `db:"secret"`
+}
+
+//MarshalJSON is custom json marshaler to present delivery in delivery log with event it delivers, next attempt is given only for pending delivery
+func (d *WebhookDelivery) MarshalJSON() ([]byte, error) {
+	var next *time.Time
+	if d.Status == DeliveryPending {
+		next = &d.NextAttemptAt
+	}
+	return json.Marshal(&struct {
+		ID             int             `json:"id"`
+		WebhookID      string          `json:"webhookId"`
+		EventID        string          `json:"eventId"`
+		EventType      string          `json:"eventType"`
+		Status         string          `json:"status"`
+		Attempts       int             `json:"attempts"`
+		NextAttemptAt  *time.Time      `json:"nextAttemptAt,omitempty"`
+		LastStatusCode int             `json:"lastStatusCode,omitempty"`
+		LastError      string          `json:"lastError,omitempty"`
+		CreatedAt      time.Time       `json:"createdAt"`
+		DeliveredAt    *time.Time      `json:"deliveredAt,omitempty"`
+		Event          json.RawMessage `json:"event"`
+	}{
+		ID:             d.ID,
+		WebhookID:      d.WebhookID,
+		EventID:        d.EventID,
+		EventType:      d.EventType,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		NextAttemptAt:  next,
+		LastStatusCode: d.LastStatusCode,
+		LastError:      d.LastError,
+		CreatedAt:      d.CreatedAt,
+		DeliveredAt:    d.DeliveredAt,
+		Event:          json.RawMessage(d.Payload),
+	})
+}
+
+//DeliveryFilter selects page of webhook delivery log, optionally by status, deliveries are ordered newest first
+//and only those with id below Before are returned when it is set
+type DeliveryFilter struct {
+	WebhookID string
+	Status    string
+	Before    int
+	Limit     int
+}
+
+//DeliveryList is response for v2 webhook delivery log, next cursor is given only when there are more deliveries to list
+type DeliveryList struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	NextCursor string            `json:"nextCursor,omitempty"`
+}
+
+//validEvent tells if event type is known
+func validEvent(eventType string) bool {
+	switch eventType {
+	case EventTournamentAnnounced, EventEntryAccepted, EventTournamentFinished, EventPrizeCredited, EventFundsAdded, EventFundsTaken:
+		return true
+	}
+	return false
+}
+
+//signWebhook returns hex encoded HMAC-SHA256 signature of unix timestamp and raw event body separated by new line
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(append([]byte(timestamp+"\n"), body...))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//retryDelay returns how long to wait before next delivery attempt, backoff doubles with every failed attempt
+func retryDelay(backoff time.Duration, attempts int) time.Duration {
+	delay := backoff
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+//newEventID returns random event id
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+//encodeEvent returns random id and json body of event of given type, stores queue it within the change which caused the event
+func encodeEvent(eventType string, data interface{}) (string, string, error) {
+	id, err := newEventID()
+	if err != nil {
+		return "", "", err
+	}
+	payload, err := json.Marshal(&Event{ID: id, Type: eventType, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return "", "", err
+	}
+	return id, string(payload), nil
+}
+
+//prizeCredits returns prize credited event data for every stake of finished tournament which won anything, ordered by position
+func prizeCredits(tournament *Tournament, results []TournamentResult) []PrizeCredit {
+	ranked := append([]TournamentResult(nil), results...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i].Position, ranked[j].Position
+		return a != nil && (b == nil || *a < *b)
+	})
+	var credits []PrizeCredit
+	for _, v := range ranked {
+		if v.Prize > 0 {
+			credits = append(credits, PrizeCredit{TournamentID: tournament.ID, PlayerID: v.UserID, BackedPlayerID: v.BackingID, Currency: tournament.Currency, Amount: Money(v.Prize)})
+		}
+	}
+	return credits
+}
+
+//Dispatcher periodically posts queued events to webhooks, failed deliveries are retried with exponential backoff until they run out of attempts
+type Dispatcher struct {
+	repo        Datastore
+	client      *http.Client
+	interval    time.Duration
+	maxAttempts int
+	backoff     time.Duration
+}
+
+//NewDispatcher creates dispatcher which sends due deliveries every interval
+func NewDispatcher(repo Datastore, interval time.Duration, maxAttempts int, backoff time.Duration) *Dispatcher {
+	return &Dispatcher{repo: repo, client: &http.Client{Timeout: deliveryTimeout}, interval: interval, maxAttempts: maxAttempts, backoff: backoff}
+}
+
+//Run sends due deliveries every interval until stop channel is closed
+func (d *Dispatcher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if err := d.Tick(now); err != nil {
+				log.Println("Dispatcher:", err)
+			}
+		}
+	}
+}
+
+//Tick claims deliveries whose next attempt is due at now and sends them
+func (d *Dispatcher) Tick(now time.Time) error {
+	deliveries, err := d.repo.DueDeliveries(now, now.Add(deliveryLease), deliveryBatchSize)
+	if err != nil {
+		return err
+	}
+	for i := range deliveries {
+		if err := d.deliver(&deliveries[i], now); err != nil {
+			log.Printf("Dispatcher: delivery %d: %v", deliveries[i].ID, err)
+		}
+	}
+	return nil
+}
+
+//deliver posts event to webhook and records attempt, delivery is done on 2xx response, otherwise it is scheduled for retry or failed
+func (d *Dispatcher) deliver(delivery *WebhookDelivery, now time.Time) error {
+	delivery.Attempts++
+	status, err := d.post(delivery, now)
+	delivery.LastStatusCode = status
+	delivery.LastError = ""
+	if err == nil && status >= 200 && status < 300 {
+		delivery.Status = DeliveryDelivered
+		delivery.DeliveredAt = &now
+		return d.repo.RecordDeliveryAttempt(delivery)
+	}
+
+	if err != nil {
+		delivery.LastError = err.Error()
+	} else {
+		delivery.LastError = "endpoint responded with status " + strconv.Itoa(status)
+	}
+	if delivery.Attempts >= d.maxAttempts {
+		delivery.Status = DeliveryFailed
+	} else {
+		delivery.NextAttemptAt = now.Add(retryDelay(d.backoff, delivery.Attempts))
+	}
+	return d.repo.RecordDeliveryAttempt(delivery)
+}
+
+//post sends signed event to webhook url and returns response status
+func (d *Dispatcher) post(delivery *WebhookDelivery, now time.Time) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest("POST", delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(signatureHeader, signWebhook(delivery.Secret, timestamp, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
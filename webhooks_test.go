@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+//webhookReceiver records events posted to it and responds with its status
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	received []receivedEvent
+}
+
+type receivedEvent struct {
+	timestamp string
+	signature string
+	body      []byte
+	event     Event
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	received := receivedEvent{timestamp: r.Header.Get(timestampHeader), signature: r.Header.Get(signatureHeader), body: body}
+	json.Unmarshal(body, &received.event)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.received = append(rc.received, received)
+	w.WriteHeader(rc.status)
+}
+
+func (rc *webhookReceiver) events() []receivedEvent {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]receivedEvent(nil), rc.received...)
+}
+
+func TestWebhooks(t *testing.T) {
+	db := newTestStore(t)
+	db.ResetDatabase()
+	defer db.ResetDatabase()
+	router := newRouter(&Handlers{repo: db, config: defaultConfig()})
+	createTestAPIKey(db, testOperatorKey, ScopeOperator, "")
+	createTestGameServer(db)
+	createTestAPIKey(db, "test-player-key-W1", ScopePlayer, "W1")
+
+	operator := loadSpecChecker(t, router, testOperatorKey)
+	player := loadSpecChecker(t, router, "test-player-key-W1")
+
+	receiver := &webhookReceiver{status: http.StatusNoContent}
+	failing := &webhookReceiver{status: http.StatusInternalServerError}
+	receiverServer := httptest.NewServer(receiver)
+	defer receiverServer.Close()
+	failingServer := httptest.NewServer(failing)
+	defer failingServer.Close()
+
+	dispatcher := NewDispatcher(db, time.Second, 3, time.Minute)
+	var secret string
+	var now time.Time
+
+	Convey("Given webhooks are registered", t, func() {
+		Convey("Given webhook W1 for every event and webhook W2 only for finished tournaments", func() {
+			w1 := operator.call("POST", "/v2/webhooks", `{"id": "W1", "url": "`+receiverServer.URL+`/events"}`)
+			w2 := operator.call("POST", "/v2/webhooks", `{"id": "W2", "url": "`+failingServer.URL+`", "events": ["tournament.finished"]}`)
+			w3 := operator.call("POST", "/v2/webhooks", `{"id": "W1", "url": "`+receiverServer.URL+`"}`)
+			w4 := operator.call("POST", "/v2/webhooks", `{"id": "W3", "url": "ftp://localhost"}`)
+			w5 := operator.call("POST", "/v2/webhooks", `{"id": "W3", "url": "`+receiverServer.URL+`", "events": ["player.banned"]}`)
+			w6 := player.call("POST", "/v2/webhooks", `{"id": "W3", "url": "`+receiverServer.URL+`"}`)
+			Convey("They should be stored with their secrets returned once", func() {
+				So(w1.Code, ShouldEqual, http.StatusCreated)
+				webhook := decodeMap(w1)
+				secret, _ = webhook["secret"].(string)
+				So(secret, ShouldNotBeEmpty)
+				So(webhook["events"], ShouldBeEmpty)
+				So(w2.Code, ShouldEqual, http.StatusCreated)
+				So(w3.Code, ShouldEqual, http.StatusConflict)
+				So(w4.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorResponse(w4).Field, ShouldEqual, "url")
+				So(w5.Code, ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorResponse(w5).Field, ShouldEqual, "events")
+				So(w6.Code, ShouldEqual, http.StatusForbidden)
+				So(operator.errors, ShouldBeEmpty)
+			})
+		})
+		Convey("Given webhooks are listed", func() {
+			w := operator.call("GET", "/v2/webhooks", "")
+			Convey("They should be returned without secrets", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				var list struct {
+					Webhooks []map[string]interface{} `json:"webhooks"`
+				}
+				json.NewDecoder(w.Body).Decode(&list)
+				So(len(list.Webhooks), ShouldEqual, 2)
+				So(list.Webhooks[0]["id"], ShouldEqual, "W1")
+				So(list.Webhooks[1]["events"], ShouldResemble, []interface{}{"tournament.finished"})
+				So(list.Webhooks[0]["secret"], ShouldBeNil)
+			})
+		})
+		Convey("Given W1 wins tournament W1 against W2 and dispatcher sends queued events", func() {
+			operator.call("POST", "/v2/players/W1/deposits", `{"amount": 100}`)
+			operator.call("POST", "/v2/players/W2/deposits", `{"amount": 100}`)
+			operator.call("POST", "/v2/tournaments", `{"id": "W1", "deposit": 10, "gameServerId": "test-server"}`)
+			operator.call("PUT", "/v2/tournaments/W1/state", `{"state": "registration_open"}`)
+			operator.call("POST", "/v2/tournaments/W1/entries", `{"playerId": "W1"}`)
+			operator.call("POST", "/v2/tournaments/W1/entries", `{"playerId": "W2"}`)
+			operator.call("PUT", "/v2/tournaments/W1/state", `{"state": "registration_closed"}`)
+			operator.call("PUT", "/v2/tournaments/W1/state", `{"state": "running"}`)
+			postResults(db, "/v2/tournaments/W1/results", `{"winners": [{"playerId": "W1", "prize": 20}]}`)
+			now = time.Now()
+			err := dispatcher.Tick(now)
+			Convey("W1 should receive every event signed with its secret in order they happened and W2 should be tried once and retried after backoff", func() {
+				So(err, ShouldBeNil)
+				So(operator.errors, ShouldBeEmpty)
+				events := receiver.events()
+				var types []string
+				for _, v := range events {
+					types = append(types, v.event.Type)
+					So(v.timestamp, ShouldEqual, strconv.FormatInt(now.Unix(), 10))
+					So(v.signature, ShouldEqual, signWebhook(secret, v.timestamp, v.body))
+				}
+				So(types, ShouldResemble, []string{EventFundsAdded, EventFundsAdded, EventTournamentAnnounced, EventEntryAccepted, EventEntryAccepted, EventTournamentFinished, EventPrizeCredited})
+				So(events[0].event.Data, ShouldResemble, map[string]interface{}{"playerId": "W1", "currency": "EUR", "amount": 100.0, "balance": 100.0})
+				So(events[4].event.Data.(map[string]interface{})["playerId"], ShouldEqual, "W2")
+				So(events[5].event.Data.(map[string]interface{})["state"], ShouldEqual, StateFinished)
+				So(events[6].event.Data, ShouldResemble, map[string]interface{}{"tournamentId": "W1", "playerId": "W1", "currency": "EUR", "amount": 20.0})
+				So(events[0].event.ID, ShouldNotEqual, events[1].event.ID)
+				So(len(failing.events()), ShouldEqual, 1)
+				So(failing.events()[0].event.Type, ShouldEqual, EventTournamentFinished)
+				So(failing.events()[0].event.ID, ShouldEqual, receiver.events()[5].event.ID)
+				deliveries, _ := db.WebhookDeliveries(DeliveryFilter{WebhookID: "W2", Limit: 10})
+				So(len(deliveries), ShouldEqual, 1)
+				So(deliveries[0].Status, ShouldEqual, DeliveryPending)
+				So(deliveries[0].Attempts, ShouldEqual, 1)
+				So(deliveries[0].LastStatusCode, ShouldEqual, http.StatusInternalServerError)
+				delay := deliveries[0].NextAttemptAt.Sub(now)
+				So(delay > time.Minute-time.Millisecond && delay < time.Minute+time.Millisecond, ShouldBeTrue)
+			})
+		})
+		Convey("Given dispatcher runs again before and after backoff of W2 doubles", func() {
+			dispatcher.Tick(now.Add(59 * time.Second))
+			attemptsBeforeBackoff := len(failing.events())
+			dispatcher.Tick(now.Add(time.Minute))
+			dispatcher.Tick(now.Add(2 * time.Minute))
+			attemptsBeforeDoubledBackoff := len(failing.events())
+			dispatcher.Tick(now.Add(3 * time.Minute))
+			Convey("Delivery should fail after its third attempt and nothing should be sent to W1 again", func() {
+				So(attemptsBeforeBackoff, ShouldEqual, 1)
+				So(attemptsBeforeDoubledBackoff, ShouldEqual, 2)
+				So(len(failing.events()), ShouldEqual, 3)
+				So(len(receiver.events()), ShouldEqual, 7)
+				dispatcher.Tick(now.Add(time.Hour))
+				So(len(failing.events()), ShouldEqual, 3)
+			})
+		})
+		Convey("Given delivery logs are read", func() {
+			w1 := operator.call("GET", "/v2/webhooks/W1/deliveries?status=delivered&limit=5", "")
+			list1 := decodeMap(w1)
+			w2 := operator.call("GET", "/v2/webhooks/W1/deliveries?cursor="+list1["nextCursor"].(string), "")
+			w3 := operator.call("GET", "/v2/webhooks/W2/deliveries", "")
+			w4 := operator.call("GET", "/v2/webhooks/W2/deliveries?status=pending", "")
+			w5 := player.call("GET", "/v2/webhooks/W2/deliveries", "")
+			Convey("They should list deliveries newest first with their attempts and last response", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(len(list1["deliveries"].([]interface{})), ShouldEqual, 5)
+				newest := list1["deliveries"].([]interface{})[0].(map[string]interface{})
+				So(newest["eventType"], ShouldEqual, EventPrizeCredited)
+				So(newest["attempts"], ShouldEqual, 1)
+				So(newest["lastStatusCode"], ShouldEqual, http.StatusNoContent)
+				So(newest["event"].(map[string]interface{})["id"], ShouldEqual, newest["eventId"])
+				list2 := decodeMap(w2)
+				So(len(list2["deliveries"].([]interface{})), ShouldEqual, 2)
+				So(list2["nextCursor"], ShouldBeNil)
+				failed := decodeMap(w3)["deliveries"].([]interface{})[0].(map[string]interface{})
+				So(failed["status"], ShouldEqual, DeliveryFailed)
+				So(failed["attempts"], ShouldEqual, 3)
+				So(failed["lastError"], ShouldEqual, "endpoint responded with status 500")
+				So(failed["nextAttemptAt"], ShouldBeNil)
+				So(decodeMap(w4)["deliveries"], ShouldBeEmpty)
+				So(w5.Code, ShouldEqual, http.StatusForbidden)
+				So(operator.errors, ShouldBeEmpty)
+			})
+		})
+		Convey("Given W2 is removed", func() {
+			w1 := operator.call("DELETE", "/v2/webhooks/W2", "")
+			operator.call("POST", "/v2/players/W1/withdrawals", `{"amount": 1}`)
+			w2 := operator.call("GET", "/v2/webhooks/W2/deliveries", "")
+			w3 := operator.call("DELETE", "/v2/webhooks/W2", "")
+			Convey("Its delivery log should be gone and it should not get new events", func() {
+				So(w1.Code, ShouldEqual, http.StatusNoContent)
+				So(w2.Code, ShouldEqual, http.StatusNotFound)
+				So(errorResponse(w2).Code, ShouldEqual, CodeWebhookNotFound)
+				So(w3.Code, ShouldEqual, http.StatusNotFound)
+				dispatcher.Tick(now.Add(2 * time.Hour))
+				events := receiver.events()
+				So(len(events), ShouldEqual, 8)
+				So(events[7].event.Type, ShouldEqual, EventFundsTaken)
+				So(len(failing.events()), ShouldEqual, 3)
+			})
+		})
+		Convey("Given W2 waits for the only seat of tournament W2 and gets it when W1 leaves", func() {
+			operator.call("POST", "/v2/tournaments", `{"id": "W2", "deposit": 10, "maxEntrants": 1}`)
+			operator.call("PUT", "/v2/tournaments/W2/state", `{"state": "registration_open"}`)
+			operator.call("POST", "/v2/tournaments/W2/entries", `{"playerId": "W1"}`)
+			w := operator.call("POST", "/v2/tournaments/W2/entries", `{"playerId": "W2"}`)
+			operator.call("DELETE", "/v2/tournaments/W2/entries/W1", "")
+			dispatcher.Tick(now.Add(3 * time.Hour))
+			Convey("Entry of W2 should be accepted only when it gets the seat", func() {
+				So(decodeMap(w)["waitlisted"], ShouldEqual, true)
+				So(operator.errors, ShouldBeEmpty)
+				events := receiver.events()
+				So(len(events), ShouldEqual, 11)
+				So(events[8].event.Type, ShouldEqual, EventTournamentAnnounced)
+				So(events[9].event.Type, ShouldEqual, EventEntryAccepted)
+				So(events[10].event.Type, ShouldEqual, EventEntryAccepted)
+				So(events[10].event.Data, ShouldResemble, map[string]interface{}{"tournamentId": "W2", "playerId": "W2", "waitlisted": false,
+					"stakes": []interface{}{map[string]interface{}{"playerId": "W2", "amount": 10.0, "share": 100.0}}})
+			})
+		})
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	Convey("Given backoff of 30 seconds", t, func() {
+		Convey("Delay should double with every attempt up to its cap", func() {
+			So(retryDelay(30*time.Second, 1), ShouldEqual, 30*time.Second)
+			So(retryDelay(30*time.Second, 2), ShouldEqual, time.Minute)
+			So(retryDelay(30*time.Second, 4), ShouldEqual, 4*time.Minute)
+			So(retryDelay(30*time.Second, 100), ShouldEqual, maxRetryDelay)
+		})
+	})
+}